@@ -0,0 +1,79 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// auditRecord - строка журнала аудита переходов
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Breaker string    `json:"breaker"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Reason  string    `json:"reason"`
+}
+
+// auditLog записывает события переходов в io.Writer
+type auditLog struct {
+	w      io.Writer
+	events chan Event
+	stop   chan struct{}
+}
+
+// SetAuditWriter включает журнал аудита: каждый переход CB менеджера записывается в w
+// отдельной строкой NDJSON вида {"time", "breaker", "from", "to", "reason"}, где reason -
+// причина перехода (см. TransitionCause: tripped, recovery_timeout, probe_triggered,
+// recovered, probe_failed, half_open_timeout, remote или manual). Строки пишутся одним
+// вызовом Write из одной горутины и не перемешиваются. Запись не блокирует CB: если w
+// не успевает или возвращает ошибку, записи отбрасываются и учитываются только
+// в AuditDropped (не в DroppedEvents). Повторный вызов заменяет writer, nil отключает журнал.
+func (m *CBManager) SetAuditWriter(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a := m.audit; a != nil {
+		m.events.unsubscribe(a.events)
+		close(a.stop)
+		m.audit = nil
+	}
+	if w == nil {
+		return
+	}
+	a := &auditLog{
+		w:      w,
+		events: m.events.subscribeCounted(eventBufferSize, &m.auditDropped),
+		stop:   make(chan struct{}),
+	}
+	m.audit = a
+	go a.run(&m.auditDropped)
+}
+
+// AuditDropped возвращает количество записей журнала аудита, потерянных из-за
+// медленного или неисправного writer
+func (m *CBManager) AuditDropped() uint64 {
+	return m.auditDropped.Load()
+}
+
+// run записывает события до остановки журнала
+func (a *auditLog) run(dropped *atomic.Uint64) {
+	for {
+		select {
+		case <-a.stop:
+			return
+		case ev := <-a.events:
+			line, _ := json.Marshal(auditRecord{
+				Time:    ev.Time,
+				Breaker: ev.Name,
+				From:    ev.From.String(),
+				To:      ev.To.String(),
+				Reason:  ev.Cause.String(),
+			})
+			if _, err := a.w.Write(append(line, '\n')); err != nil {
+				dropped.Add(1)
+			}
+		}
+	}
+}
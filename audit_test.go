@@ -0,0 +1,158 @@
+package circuitbreaker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer - bytes.Buffer, безопасный для записи из горутины журнала
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSuffix(b.buf.String(), "\n"), "\n")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestSetAuditWriter(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"db"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Minute,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+
+	var buf syncBuffer
+	m.SetAuditWriter(&buf)
+
+	m.ReportFailure("db")
+	clock.Advance(time.Minute)
+	m.AllowRequest("db")
+	m.ReportSuccess("db")
+
+	deadline := time.Now().Add(time.Second)
+	for len(buf.lines()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 3 audit lines, got %q", buf.lines())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	want := []auditRecord{
+		{Breaker: "db", From: "closed", To: "open", Reason: "tripped"},
+		{Breaker: "db", From: "open", To: "half-open", Reason: "recovery_timeout"},
+		{Breaker: "db", From: "half-open", To: "closed", Reason: "recovered"},
+	}
+	for i, line := range buf.lines() {
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("line %d: invalid JSON %q: %v", i, line, err)
+		}
+		if rec.Time.IsZero() {
+			t.Errorf("line %d: expected timestamp", i)
+		}
+		rec.Time = time.Time{}
+		if rec != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, rec, want[i])
+		}
+	}
+
+	// Ошибки записи не блокируют CB, а потерянные записи считаются
+	m.SetAuditWriter(failingWriter{})
+	m.ReportFailure("db")
+	deadline = time.Now().Add(time.Second)
+	for m.AuditDropped() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected failed audit write to be counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Отключенный журнал больше ничего не пишет
+	m.SetAuditWriter(nil)
+	m.Reset("db")
+	if n := len(buf.lines()); n != 3 {
+		t.Errorf("Expected no more lines in replaced writer, got %d", n)
+	}
+}
+
+// blockingWriter блокирует запись до закрытия release
+type blockingWriter struct{ release chan struct{} }
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestSetAuditWriter_Causes(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"db"}, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+
+	var buf syncBuffer
+	m.SetAuditWriter(&buf)
+
+	// Причина берется из самого перехода, а не выводится из пары состояний
+	m.GetCircuitBreaker("db").ForceState(StateOpen)
+	m.TriggerProbe("db")
+	m.GetCircuitBreaker("db").ForceState(StateOpen)
+
+	deadline := time.Now().Add(time.Second)
+	for len(buf.lines()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 3 audit lines, got %q", buf.lines())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	want := []string{"manual", "probe_triggered", "manual"}
+	for i, line := range buf.lines() {
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("line %d: invalid JSON %q: %v", i, line, err)
+		}
+		if rec.Reason != want[i] {
+			t.Errorf("line %d: reason = %s, want %s", i, rec.Reason, want[i])
+		}
+	}
+}
+
+func TestSetAuditWriter_DroppedSeparately(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"db"}, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+	w := blockingWriter{release: make(chan struct{})}
+	m.SetAuditWriter(w)
+	defer close(w.release)
+
+	// Переполнение буфера журнала не считается потерей событий подписчиков
+	cb := m.GetCircuitBreaker("db")
+	for i := 0; i < eventBufferSize+10; i++ {
+		cb.ForceState(StateOpen)
+		cb.ForceState(StateClosed)
+	}
+	if m.AuditDropped() == 0 {
+		t.Error("Expected audit drops to be counted")
+	}
+	if n := m.DroppedEvents(); n != 0 {
+		t.Errorf("Expected audit drops not to affect DroppedEvents, got %d", n)
+	}
+}
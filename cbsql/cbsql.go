@@ -0,0 +1,392 @@
+// Package cbsql защищает обращения к базе данных через database/sql circuit breaker'ом:
+// обертка над драйвером пропускает подключения, запросы и выполнение команд через CB
+// менеджера с заданным именем сервера. Пока CB не пропускает запросы, вызовы сразу
+// завершаются ошибкой, обернутой в circuitbreaker.ErrCircuitOpen, не обращаясь к базе.
+//
+// Каждое выполнение запроса и каждое новое подключение учитываются в CB один раз
+// (пул database/sql переиспользует подключения). Подготовка запроса (Prepare)
+// отдельным обращением не считается: при разомкнутом CB она сразу отклоняется, но не
+// расходует пробу half-open, которую затем займет выполнение. Запрос, возвращающий
+// строки, учитывается по завершении чтения: ошибка при получении строки - неудача,
+// закрытие строк без ошибок - успех.
+//
+//	db := sql.OpenDB(cbsql.WrapConnector(connector, m, "postgres-main"))
+package cbsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/a3ak/circuitbreaker"
+)
+
+// IsFailure сообщает, считается ли ошибка драйвера неудачей для CB. Не считаются
+// sql.ErrNoRows (пустой результат - нормальный ответ базы), отмена контекста вызывающим
+// и driver.ErrSkip (драйвер просит database/sql выполнить запрос другим способом).
+func IsFailure(err error) bool {
+	return err != nil &&
+		!errors.Is(err, sql.ErrNoRows) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, driver.ErrSkip)
+}
+
+// breaker выполняет вызовы драйвера через CB сервера name
+type breaker struct {
+	m    *circuitbreaker.CBManager
+	name string
+}
+
+// do выполняет fn, если CB пропускает запрос, и отмечает результат по IsFailure
+func (b breaker) do(fn func() error) error {
+	t, err := b.m.Acquire(b.name)
+	if err != nil {
+		return err
+	}
+	// При панике в fn билет закрывается неудачей; после отчета вызов ничего не делает
+	defer t.Failure()
+	err = fn()
+	report(t, err)
+	return err
+}
+
+// query выполняет fn, если CB пропускает запрос. Результат отмечается по завершении
+// чтения возвращенных строк (см. rows), а при ошибке fn - сразу.
+func (b breaker) query(fn func() (driver.Rows, error)) (driver.Rows, error) {
+	t, err := b.m.Acquire(b.name)
+	if err != nil {
+		return nil, err
+	}
+	opened := false
+	defer func() {
+		if !opened {
+			// Паника в fn
+			t.Failure()
+		}
+	}()
+	r, err := fn()
+	opened = true
+	if err != nil {
+		report(t, err)
+		return nil, err
+	}
+	return &rows{Rows: r, t: t}, nil
+}
+
+// check отклоняет обращение без учета в CB, если CB разомкнут
+func (b breaker) check() error {
+	if state := b.m.Peek(b.name); state == circuitbreaker.StateOpen {
+		return fmt.Errorf("%w: %s (%s)", circuitbreaker.ErrCircuitOpen, b.name, state)
+	}
+	return nil
+}
+
+// report закрывает билет по ошибке err с учетом IsFailure
+func report(t *circuitbreaker.Ticket, err error) {
+	if IsFailure(err) {
+		t.Failure()
+	} else {
+		t.Success()
+	}
+}
+
+// Wrap возвращает драйвер, пропускающий обращения драйвера d через CB сервера name
+// менеджера m. Результат можно зарегистрировать через sql.Register.
+func Wrap(d driver.Driver, m *circuitbreaker.CBManager, name string) driver.Driver {
+	return &wrappedDriver{Driver: d, b: breaker{m: m, name: name}}
+}
+
+// WrapConnector возвращает driver.Connector для sql.OpenDB, пропускающий обращения
+// через CB сервера name менеджера m
+func WrapConnector(c driver.Connector, m *circuitbreaker.CBManager, name string) driver.Connector {
+	return &connector{Connector: c, b: breaker{m: m, name: name}}
+}
+
+type wrappedDriver struct {
+	driver.Driver
+	b breaker
+}
+
+// Open открывает подключение через CB
+func (d *wrappedDriver) Open(dsn string) (driver.Conn, error) {
+	var c driver.Conn
+	err := d.b.do(func() (err error) {
+		c, err = d.Driver.Open(dsn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, b: d.b}, nil
+}
+
+type connector struct {
+	driver.Connector
+	b breaker
+}
+
+// Connect открывает подключение через CB
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	var dc driver.Conn
+	err := c.b.do(func() (err error) {
+		dc, err = c.Connector.Connect(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: dc, b: c.b}, nil
+}
+
+// Driver возвращает обернутый драйвер исходного Connector
+func (c *connector) Driver() driver.Driver {
+	return &wrappedDriver{Driver: c.Connector.Driver(), b: c.b}
+}
+
+// conn - подключение, выполняющее запросы через CB
+type conn struct {
+	driver.Conn
+	b breaker
+}
+
+// Prepare подготавливает запрос через CB
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext подготавливает запрос, выполнение которого проходит через CB.
+// Сама подготовка в CB не учитывается и отклоняется, только пока CB разомкнут.
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.b.check(); err != nil {
+		return nil, err
+	}
+	var (
+		s   driver.Stmt
+		err error
+	)
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, b: c.b}, nil
+}
+
+// BeginTx начинает транзакцию. Транзакции не проходят через CB: их запросы
+// выполняются через подключение и учитываются по отдельности.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bt, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bt.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) || opts.ReadOnly {
+		return nil, errors.New("cbsql: driver does not support non-default transaction options")
+	}
+	return c.Conn.Begin()
+}
+
+// QueryContext выполняет запрос через CB
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return c.b.query(func() (driver.Rows, error) {
+		return qc.QueryContext(ctx, query, args)
+	})
+}
+
+// ExecContext выполняет команду через CB
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var res driver.Result
+	err := c.b.do(func() (err error) {
+		res, err = ec.ExecContext(ctx, query, args)
+		return err
+	})
+	return res, err
+}
+
+// Ping проверяет подключение через CB
+func (c *conn) Ping(ctx context.Context) error {
+	p, ok := c.Conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	return c.b.do(func() error { return p.Ping(ctx) })
+}
+
+// ResetSession передает сброс сессии исходному подключению
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid передает проверку исходному подключению
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue передает проверку аргументов исходному подключению
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// stmt - подготовленный запрос, выполняемый через CB
+type stmt struct {
+	driver.Stmt
+	b breaker
+}
+
+// ExecContext выполняет подготовленную команду через CB
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	var res driver.Result
+	err := s.b.do(func() (err error) {
+		if ec, ok := s.Stmt.(driver.StmtExecContext); ok {
+			res, err = ec.ExecContext(ctx, args)
+			return err
+		}
+		values, err := namedToValues(args)
+		if err != nil {
+			return err
+		}
+		res, err = s.Stmt.Exec(values)
+		return err
+	})
+	return res, err
+}
+
+// QueryContext выполняет подготовленный запрос через CB
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.b.query(func() (driver.Rows, error) {
+		if qc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			return qc.QueryContext(ctx, args)
+		}
+		values, err := namedToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Stmt.Query(values)
+	})
+}
+
+// CheckNamedValue передает проверку аргументов исходному запросу
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// rows - строки результата запроса. Билет запроса закрывается первой ошибкой чтения
+// (неудача по IsFailure) или закрытием строк; до этого запрос учитывается в in_flight.
+type rows struct {
+	driver.Rows
+	t *circuitbreaker.Ticket
+}
+
+// Next читает следующую строку и отмечает ошибку чтения в CB
+func (r *rows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err != nil && err != io.EOF {
+		report(r.t, err)
+	}
+	return err
+}
+
+// Close закрывает строки и завершает запрос в CB
+func (r *rows) Close() error {
+	err := r.Rows.Close()
+	report(r.t, err)
+	return err
+}
+
+// HasNextResultSet передает вызов исходным строкам
+func (r *rows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet переходит к следующему набору строк и отмечает ошибку перехода в CB
+func (r *rows) NextResultSet() error {
+	rs, ok := r.Rows.(driver.RowsNextResultSet)
+	if !ok {
+		return io.EOF
+	}
+	err := rs.NextResultSet()
+	if err != nil && err != io.EOF {
+		report(r.t, err)
+	}
+	return err
+}
+
+// ColumnTypeScanType передает вызов исходным строкам
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return ct.ColumnTypeScanType(index)
+	}
+	return reflect.TypeFor[any]()
+}
+
+// ColumnTypeDatabaseTypeName передает вызов исходным строкам
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return ct.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength передает вызов исходным строкам
+func (r *rows) ColumnTypeLength(index int) (int64, bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return ct.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable передает вызов исходным строкам
+func (r *rows) ColumnTypeNullable(index int) (bool, bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return ct.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale передает вызов исходным строкам
+func (r *rows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return ct.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+// namedToValues преобразует аргументы для драйверов без поддержки именованных параметров
+func namedToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("cbsql: driver does not support named parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
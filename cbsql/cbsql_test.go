@@ -0,0 +1,276 @@
+package cbsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/a3ak/circuitbreaker"
+)
+
+var errDown = errors.New("connection refused")
+
+// fakeDB - драйвер, который отвечает err на каждый запрос
+type fakeDB struct {
+	err     atomic.Pointer[error]
+	calls   atomic.Int32
+	rowsErr error // ошибка чтения строк результата
+}
+
+func (f *fakeDB) result() error {
+	f.calls.Add(1)
+	if err := f.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (f *fakeDB) fail(err error) { f.err.Store(&err) }
+
+func (f *fakeDB) Open(string) (driver.Conn, error)             { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return f }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return &fakeStmt{db: c.db}, c.db.result() }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not implemented") }
+
+func (c *fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	if err := c.db.result(); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	if err := c.db.result(); err != nil {
+		return nil, err
+	}
+	return fakeRows{err: c.db.rowsErr}, nil
+}
+
+type fakeStmt struct{ db *fakeDB }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if err := s.db.result(); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	if err := s.db.result(); err != nil {
+		return nil, err
+	}
+	return fakeRows{err: s.db.rowsErr}, nil
+}
+
+type fakeRows struct{ err error }
+
+func (fakeRows) Columns() []string { return []string{"n"} }
+func (fakeRows) Close() error      { return nil }
+
+func (r fakeRows) Next(dest []driver.Value) error {
+	if r.err != nil {
+		return r.err
+	}
+	return io.EOF
+}
+
+func newDB(t *testing.T) (*sql.DB, *fakeDB, *circuitbreaker.CBManager) {
+	m := circuitbreaker.NewCBManager()
+	m.InitCircuitBreakers([]string{"db"}, circuitbreaker.CircuitBreakerConf{
+		FailureThreshold: 3,
+		RecoveryTimeout:  time.Hour,
+	})
+	fake := &fakeDB{}
+	db := sql.OpenDB(WrapConnector(fake, m, "db"))
+	t.Cleanup(func() { db.Close() })
+	return db, fake, m
+}
+
+func TestWrapConnector_Trips(t *testing.T) {
+	db, fake, m := newDB(t)
+	ctx := context.Background()
+
+	if _, err := db.ExecContext(ctx, "UPDATE t SET n = 1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Ошибки драйвера учитываются как неудачи и размыкают CB
+	fake.fail(errDown)
+	for i := 0; i < 3; i++ {
+		if _, err := db.QueryContext(ctx, "SELECT n FROM t"); !errors.Is(err, errDown) {
+			t.Fatalf("Expected driver error, got %v", err)
+		}
+	}
+	if state := m.Peek("db"); state != circuitbreaker.StateOpen {
+		t.Fatalf("Expected open, got %s", state)
+	}
+
+	// В open запрос сразу отклоняется без обращения к базе
+	before := fake.calls.Load()
+	if _, err := db.ExecContext(ctx, "UPDATE t SET n = 1"); !errors.Is(err, circuitbreaker.ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if fake.calls.Load() != before {
+		t.Error("Expected rejected query not to reach the driver")
+	}
+}
+
+func TestWrapConnector_IgnoredErrors(t *testing.T) {
+	db, fake, m := newDB(t)
+	ctx := context.Background()
+
+	// Пустой результат и отмена вызывающим не считаются неудачами
+	for _, err := range []error{sql.ErrNoRows, context.Canceled} {
+		fake.fail(err)
+		for i := 0; i < 5; i++ {
+			db.ExecContext(ctx, "DELETE FROM t")
+		}
+	}
+	if state := m.Peek("db"); state != circuitbreaker.StateClosed {
+		t.Errorf("Expected closed, got %s", state)
+	}
+}
+
+func TestWrap_Register(t *testing.T) {
+	m := circuitbreaker.NewCBManager()
+	m.InitCircuitBreakers([]string{"db"}, circuitbreaker.CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+	fake := &fakeDB{}
+	sql.Register("cbsql-fake", Wrap(fake, m, "db"))
+
+	db, err := sql.Open("cbsql-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fake.fail(errDown)
+	db.Exec("UPDATE t SET n = 1")
+	if _, err := db.Exec("UPDATE t SET n = 1"); !errors.Is(err, circuitbreaker.ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{sql.ErrNoRows, false},
+		{context.Canceled, false},
+		{driver.ErrSkip, false},
+		{context.DeadlineExceeded, true},
+		{errDown, true},
+	}
+	for _, tt := range tests {
+		if got := IsFailure(tt.err); got != tt.want {
+			t.Errorf("IsFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBreaker_Panic(t *testing.T) {
+	_, _, m := newDB(t)
+	b := breaker{m: m, name: "db"}
+
+	func() {
+		defer func() { recover() }()
+		b.do(func() error { panic("driver bug") })
+	}()
+
+	// Паника в драйвере закрывает билет неудачей
+	stats := m.GetCircuitBreaker("db").Stats()
+	if stats["in_flight"] != int64(0) || stats["failure_count"] != 1 {
+		t.Errorf("Expected released ticket and one failure, got in_flight=%v failures=%v", stats["in_flight"], stats["failure_count"])
+	}
+}
+
+func TestPrepare_CountsExecutionOnly(t *testing.T) {
+	m := circuitbreaker.NewCBManager()
+	m.InitCircuitBreakers([]string{"db"}, circuitbreaker.CircuitBreakerConf{
+		FailureThreshold:    1,
+		RecoveryTimeout:     time.Hour,
+		SuccessThreshold:    2,
+		HalfOpenSingleProbe: true,
+	})
+	fake := &fakeDB{}
+	db := sql.OpenDB(WrapConnector(fake, m, "db"))
+	defer db.Close()
+	ctx := context.Background()
+	// Подключение открывается заранее: оно учитывается в CB как отдельное обращение
+	if err := db.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.ReportFailure("db")
+
+	// Разомкнутый CB отклоняет подготовку без обращения к базе
+	if _, err := db.PrepareContext(ctx, "UPDATE t SET n = ?"); !errors.Is(err, circuitbreaker.ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen on prepare, got %v", err)
+	}
+	if fake.calls.Load() != 0 {
+		t.Error("Expected rejected prepare not to reach the driver")
+	}
+
+	// В half-open подготовка не расходует единственную пробу
+	m.TriggerProbe("db")
+	st, err := db.PrepareContext(ctx, "UPDATE t SET n = ?")
+	if err != nil {
+		t.Fatalf("Expected prepare in half-open, got %v", err)
+	}
+	defer st.Close()
+	if _, err := st.ExecContext(ctx, 1); err != nil {
+		t.Fatalf("Expected probe to be admitted, got %v", err)
+	}
+	if n := m.GetCircuitBreaker("db").Stats()["half_open_successes"]; n != 1 {
+		t.Errorf("Expected one counted probe, got %v", n)
+	}
+}
+
+func TestQuery_RowsError(t *testing.T) {
+	db, fake, m := newDB(t)
+	ctx := context.Background()
+
+	rows, err := db.QueryContext(ctx, "SELECT n FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Пока строки не прочитаны, запрос выполняется
+	if n := m.GetCircuitBreaker("db").Stats()["in_flight"]; n != int64(1) {
+		t.Errorf("Expected query in flight until rows are closed, got %v", n)
+	}
+	rows.Close()
+
+	// Ошибка при чтении строк учитывается как неудача
+	fake.rowsErr = errDown
+	for i := 0; i < 3; i++ {
+		rows, err := db.QueryContext(ctx, "SELECT n FROM t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		if !errors.Is(rows.Err(), errDown) {
+			t.Errorf("Expected rows error, got %v", rows.Err())
+		}
+		rows.Close()
+	}
+	if state := m.Peek("db"); state != circuitbreaker.StateOpen {
+		t.Errorf("Expected open after rows errors, got %s", state)
+	}
+	if n := m.GetCircuitBreaker("db").Stats()["in_flight"]; n != int64(0) {
+		t.Errorf("Expected no requests in flight, got %v", n)
+	}
+}
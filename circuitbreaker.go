@@ -1,51 +1,406 @@
 package circuitbreaker
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBreakerNotFound возвращается, когда для сервера не настроен Circuit Breaker
+var ErrBreakerNotFound = errors.New("circuit breaker not found")
+
+// ErrBreakerBusy возвращается при попытке удалить CB, через который выполняются запросы
+var ErrBreakerBusy = errors.New("circuit breaker has in-flight requests")
+
+// ErrBreakerExists возвращается при добавлении CB под уже занятым ключом сервера
+var ErrBreakerExists = errors.New("circuit breaker already exists")
+
+// ErrInvalidConfig оборачивает ошибки некорректной конфигурации или аргументов
+// (пустое имя, недопустимое состояние и т.п.); причина доступна в тексте ошибки
+var ErrInvalidConfig = errors.New("invalid circuit breaker config")
 
 type CBManager struct {
-	breakers map[string]*circuitBreaker
+	breakers map[string]*CircuitBreaker
+	groups   map[string]*cbGroup // группы CB по имени
+	memberOf map[string][]string // сервер -> имена групп, в которые он входит
+	links    map[string][]string // сервер -> связанные серверы (см. Link)
+	events   *eventHub           // рассылка событий переходов
+	parent   *CBManager          // родительский менеджер для CB, не найденных в этом
+	sync     *stateSync          // синхронизация с внешним хранилищем состояний (SetStateStore)
+	audit    *auditLog           // журнал аудита переходов (SetAuditWriter)
 	mu       sync.RWMutex
+
+	maxBreakers int                // максимальное количество CB для динамического добавления (0 - без ограничений)
+	autoCreate  bool               // создавать CB при первом обращении к неизвестному серверу
+	defaultCfg  CircuitBreakerConf // конфигурация для автоматически создаваемых CB
+	onCreated   func(string)       // обработчик автоматического создания CB
+
+	maxOpenFraction float64             // допустимая доля разомкнутых CB для HealthSummary
+	strictServers   bool                // строгий режим: обращения к ненастроенным серверам считаются ошибкой
+	keyNormalizer   func(string) string // приведение ключей серверов к каноническому виду (nil - без изменений)
+	useTick         atomic.Uint64       // логические часы для отслеживания давности использования CB
+	disabled        atomic.Bool         // глобальное отключение блокировки запросов (DisableAll)
+	auditDropped    atomic.Uint64       // записи журнала аудита, потерянные из-за медленного или неисправного writer
 }
 
 // NewManager создает новый менеджер circuit breakers
 func NewCBManager() *CBManager {
 	return &CBManager{
-		breakers: make(map[string]*circuitBreaker),
+		breakers: make(map[string]*CircuitBreaker),
+		groups:   make(map[string]*cbGroup),
+		memberOf: make(map[string][]string),
+		links:    make(map[string][]string),
+		events:   newEventHub(),
 	}
 }
 
-// InitCircuitBreakers инициализирует Circuit Breakers для серверов
+// NewCBManagerWithParent создает менеджер, который ищет CB сервера сначала у себя,
+// а при промахе - в родительском менеджере parent (и далее по цепочке). CB, зарегистрированные
+// в дочернем менеджере, перекрывают одноименные CB родителя. AllowRequest, Report* и прочие
+// обращения к отдельному CB разрешаются по цепочке; статистика, группы и события - только
+// собственные для каждого менеджера.
+func NewCBManagerWithParent(parent *CBManager) *CBManager {
+	m := NewCBManager()
+	m.parent = parent
+	return m
+}
+
+// InitCircuitBreakers инициализирует Circuit Breakers для серверов.
+// Возвращает ошибки некорректных записей без указания серверов; чтобы узнать,
+// какие именно записи не удалось создать, используйте InitCircuitBreakersReport.
+// Уже зарегистрированные CB серверов заменяются новыми (см. AddCircuitBreakerWithPolicy).
 func (m *CBManager) InitCircuitBreakers(servers []string, cfg CircuitBreakerConf) (cbInitErr []error) {
+	m.initBreakers(servers, cfg, func(_ string, err error) {
+		if err != nil {
+			cbInitErr = append(cbInitErr, err)
+		}
+	})
+	return cbInitErr
+}
+
+// InitResult - результат InitCircuitBreakersReport
+type InitResult struct {
+	Created []string         // серверы, для которых созданы CB (в порядке перечисления)
+	Failed  map[string]error // сервер -> причина, по которой CB не создан
+}
+
+// InitCircuitBreakersReport инициализирует Circuit Breakers для серверов, как InitCircuitBreakers,
+// и сообщает, какие серверы созданы, а какие отклонены и почему (например, пустое имя).
+// Ключи в результате приведены нормализатором (см. SetKeyNormalizer).
+func (m *CBManager) InitCircuitBreakersReport(servers []string, cfg CircuitBreakerConf) InitResult {
+	res := InitResult{Failed: make(map[string]error)}
+	m.initBreakers(servers, cfg, func(srv string, err error) {
+		if err != nil {
+			res.Failed[srv] = err
+			return
+		}
+		res.Created = append(res.Created, srv)
+	})
+	return res
+}
+
+// initBreakers создает и регистрирует CB для серверов, сообщая результат по каждой записи в report
+func (m *CBManager) initBreakers(servers []string, cfg CircuitBreakerConf, report func(srv string, err error)) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	for _, srv := range servers {
+		srv = m.keyLocked(srv)
 		cb, err := new(srv, cfg)
 		if cb == nil && err != nil {
-			cbInitErr = append(cbInitErr, err)
+			report(srv, err)
 			continue
 		}
-		m.breakers[srv] = cb
+		m.registerLocked(srv, cb)
+		report(srv, nil)
 	}
-	return cbInitErr
 }
 
-// GetCircuitBreaker возвращает Circuit Breaker для сервера
-func (m *CBManager) GetCircuitBreaker(serverURL string) *circuitBreaker {
+// InitSharedBreaker создает один Circuit Breaker с именем key и регистрирует его под всеми
+// именами names (например, URL реплик одного сервиса за балансировщиком). Неудачи,
+// сообщенные для любого из имен, учитываются в общем состоянии, а обращение по любому
+// имени возвращает общий CB. В статистике CB присутствует под каждым из имен.
+func (m *CBManager) InitSharedBreaker(names []string, key string, cfg CircuitBreakerConf) error {
+	if len(names) == 0 {
+		return fmt.Errorf("%w: shared circuit breaker must have at least one name", ErrInvalidConfig)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cb, err := new(m.keyLocked(key), cfg)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		m.registerLocked(m.keyLocked(name), cb)
+	}
+	return nil
+}
+
+// AddCircuitBreaker добавляет (или заменяет) Circuit Breaker для сервера во время работы.
+// Если задан лимит SetMaxBreakers и он превышен, вытесняется давно не использовавшийся CB.
+// Чтобы не потерять состояние существующего CB, используйте AddCircuitBreakerWithPolicy.
+func (m *CBManager) AddCircuitBreaker(serverURL string, cfg CircuitBreakerConf) error {
+	_, err := m.AddCircuitBreakerWithPolicy(serverURL, cfg, CollisionReplace)
+	return err
+}
+
+// CollisionPolicy определяет поведение AddCircuitBreakerWithPolicy, если CB для сервера уже есть
+type CollisionPolicy int
+
+const (
+	CollisionFail    CollisionPolicy = iota // вернуть ErrBreakerExists
+	CollisionReplace                        // заменить CB новым, состояние прежнего теряется (как AddCircuitBreaker)
+	CollisionKeep                           // оставить существующий CB и его конфигурацию
+)
+
+// AddCircuitBreakerWithPolicy добавляет Circuit Breaker для сервера, разрешая коллизию
+// с уже зарегистрированным CB согласно policy. Возвращает CB, который после вызова
+// обслуживает сервер: новый или, при CollisionKeep, существующий.
+func (m *CBManager) AddCircuitBreakerWithPolicy(serverURL string, cfg CircuitBreakerConf, policy CollisionPolicy) (*CircuitBreaker, error) {
+	serverURL = m.normalize(serverURL)
+	cb, err := new(serverURL, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, exists := m.breakers[serverURL]; exists {
+		switch policy {
+		case CollisionFail:
+			return nil, fmt.Errorf("%w: %s", ErrBreakerExists, serverURL)
+		case CollisionKeep:
+			return existing, nil
+		}
+	} else {
+		m.evictLocked()
+	}
+	m.registerLocked(serverURL, cb)
+	return cb, nil
+}
+
+// Drain переводит CB сервера в режим вывода из эксплуатации: новые запросы отклоняются,
+// а уже выполняющиеся могут завершиться и сообщить результат. После того как счетчик
+// in_flight достигнет нуля, CB можно удалить через RemoveCircuitBreaker.
+func (m *CBManager) Drain(serverURL string) error {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return fmt.Errorf("%w: %s", ErrBreakerNotFound, serverURL)
+	}
+	cb.draining.Store(true)
+	return nil
+}
+
+// RemoveCircuitBreaker удаляет CB сервера. Если через CB выполняются запросы, допущенные
+// через Acquire/Execute, CB не удаляется и возвращается ErrBreakerBusy: обычно сначала
+// вызывают Drain и повторяют удаление, когда in_flight достигнет нуля.
+// Сервер также исключается из групп (опустевшая группа удаляется) и связей Link.
+func (m *CBManager) RemoveCircuitBreaker(serverURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.keyLocked(serverURL)
+	cb := m.breakers[key]
+	if cb == nil {
+		return fmt.Errorf("%w: %s", ErrBreakerNotFound, serverURL)
+	}
+	if n := cb.inFlight.Load(); n > 0 {
+		return fmt.Errorf("%w: %s (%d)", ErrBreakerBusy, serverURL, n)
+	}
+	delete(m.breakers, key)
+	m.syncReleaseLocked(cb)
+	m.leaveGroupsLocked(key)
+	m.unlinkLocked(key)
+	return nil
+}
+
+// UpdateConfig изменяет конфигурацию Circuit Breaker сервера без сброса его состояния.
+// Если после снижения порога накопленных ошибок уже достаточно для размыкания,
+// замкнутый CB сразу переходит в open; повышение порога разомкнутый CB не замыкает.
+// Конфигурация со StrictValidation, не прошедшая Validate, не применяется.
+func (m *CBManager) UpdateConfig(serverURL string, cfg CircuitBreakerConf) error {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return fmt.Errorf("%w: %s", ErrBreakerNotFound, serverURL)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	cb.updateConfig(cfg)
+	return nil
+}
+
+// SetMaxBreakers ограничивает количество CB, добавляемых динамически (0 - без ограничений).
+// При превышении лимита вытесняется CB, к которому дольше всего не обращались; разомкнутые CB
+// вытесняются только если других кандидатов нет. Вызов не вытесняет уже существующие CB.
+func (m *CBManager) SetMaxBreakers(n int) {
+	if n < 0 {
+		n = 0
+	}
+	m.mu.Lock()
+	m.maxBreakers = n
+	m.mu.Unlock()
+}
+
+// SetDefaultConfig задает конфигурацию для CB, создаваемых автоматически (см. SetAutoCreate)
+func (m *CBManager) SetDefaultConfig(cfg CircuitBreakerConf) {
+	m.mu.Lock()
+	m.defaultCfg = cfg
+	m.mu.Unlock()
+}
+
+// SetAutoCreate включает автоматическое создание CB: AllowRequest для неизвестного сервера
+// создает CB с конфигурацией SetDefaultConfig (с учетом лимита SetMaxBreakers).
+// При выключенном режиме (по умолчанию) запросы к неизвестным серверам пропускаются без создания CB.
+func (m *CBManager) SetAutoCreate(enabled bool) {
+	m.mu.Lock()
+	m.autoCreate = enabled
+	m.mu.Unlock()
+}
+
+// SetOnBreakerCreated задает обработчик, вызываемый при автоматическом создании CB
+// (см. SetAutoCreate) с ключом нового сервера. Вызывается ровно один раз на каждое создание,
+// вне блокировок менеджера. Полезен для журналирования и метрик о новых серверах.
+func (m *CBManager) SetOnBreakerCreated(fn func(serverURL string)) {
+	m.mu.Lock()
+	m.onCreated = fn
+	m.mu.Unlock()
+}
+
+// SetKeyNormalizer задает функцию приведения ключей серверов к каноническому виду
+// (например, удаление завершающего слэша), чтобы эквивалентные URL соответствовали одному CB.
+// Функция применяется при регистрации CB, в группах и во всех обращениях к менеджеру.
+// Ключи уже зарегистрированных CB не пересчитываются, поэтому нормализатор следует задавать
+// до InitCircuitBreakers. nil восстанавливает поведение по умолчанию (ключ не изменяется).
+func (m *CBManager) SetKeyNormalizer(fn func(string) string) {
+	m.mu.Lock()
+	m.keyNormalizer = fn
+	m.mu.Unlock()
+}
+
+// normalize приводит ключ сервера к каноническому виду
+func (m *CBManager) normalize(serverURL string) string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	return m.keyLocked(serverURL)
+}
 
-	return m.breakers[serverURL]
+// keyLocked приводит ключ сервера к каноническому виду. Вызывается под m.mu.
+func (m *CBManager) keyLocked(serverURL string) string {
+	if m.keyNormalizer == nil {
+		return serverURL
+	}
+	return m.keyNormalizer(serverURL)
+}
+
+// getOrCreate возвращает CB сервера, создавая его при включенном AutoCreate.
+// created равно true, если CB был создан этим вызовом.
+func (m *CBManager) getOrCreate(serverURL string) (cb *CircuitBreaker, created bool) {
+	if cb = m.GetCircuitBreaker(serverURL); cb != nil {
+		return cb, false
+	}
+
+	m.mu.Lock()
+	serverURL = m.keyLocked(serverURL)
+	cb, created = m.autoCreateLocked(serverURL)
+	onCreated := m.onCreated
+	m.mu.Unlock()
+
+	// Обработчик вызывается вне блокировки и может обращаться к менеджеру
+	if created && onCreated != nil {
+		onCreated(serverURL)
+	}
+	return cb, created
+}
+
+// autoCreateLocked создает CB для нормализованного ключа, если включен SetAutoCreate.
+// Вызывается под m.mu.
+func (m *CBManager) autoCreateLocked(serverURL string) (*CircuitBreaker, bool) {
+	if !m.autoCreate {
+		return nil, false
+	}
+	// Повторная проверка: CB мог быть создан конкурентно
+	if cb := m.breakers[serverURL]; cb != nil {
+		return cb, false
+	}
+	cb, err := new(serverURL, m.defaultCfg)
+	if err != nil {
+		return nil, false
+	}
+	m.evictLocked()
+	m.registerLocked(serverURL, cb)
+	return cb, true
+}
+
+// registerLocked регистрирует CB в менеджере. Вызывается под m.mu.
+func (m *CBManager) registerLocked(serverURL string, cb *CircuitBreaker) {
+	cb.notify = m.events.publish
+	cb.onRecovered = m.propagateRecovery
+	cb.lastUsed.Store(m.useTick.Add(1))
+	replaced := m.breakers[serverURL]
+	m.breakers[serverURL] = cb
+	m.syncRegisterLocked(cb, replaced)
+}
+
+// evictLocked освобождает место под новый CB, если достигнут лимит. Вызывается под m.mu.
+func (m *CBManager) evictLocked() {
+	if m.maxBreakers <= 0 {
+		return
+	}
+	for len(m.breakers) >= m.maxBreakers {
+		var victim string
+		var victimUsed uint64
+		victimOpen := true
+		for srv, cb := range m.breakers {
+			used := cb.lastUsed.Load()
+			open := cb.curState() == stateOpen
+			// Предпочитаем не разомкнутые CB, среди них - давно не использовавшиеся
+			if victim == "" || (victimOpen && !open) || (victimOpen == open && used < victimUsed) {
+				victim, victimUsed, victimOpen = srv, used, open
+			}
+		}
+		evicted := m.breakers[victim]
+		delete(m.breakers, victim)
+		m.syncReleaseLocked(evicted)
+	}
+}
+
+// GetCircuitBreaker возвращает Circuit Breaker для сервера (с учетом родительского менеджера)
+func (m *CBManager) GetCircuitBreaker(serverURL string) *CircuitBreaker {
+	m.mu.RLock()
+	cb := m.breakers[m.keyLocked(serverURL)]
+	if cb != nil && m.maxBreakers > 0 {
+		cb.lastUsed.Store(m.useTick.Add(1))
+	}
+	m.mu.RUnlock()
+
+	if cb == nil && m.parent != nil {
+		return m.parent.GetCircuitBreaker(serverURL)
+	}
+	return cb
+}
+
+// Breaker возвращает Circuit Breaker сервера для прямого использования.
+// Полученный CB можно сохранить и вызывать его Allow/Success/Failure напрямую,
+// избегая поиска в менеджере на каждый запрос. Проверки уровня менеджера
+// (например, блокировка участников группы) при этом не выполняются.
+func (m *CBManager) Breaker(serverURL string) (*CircuitBreaker, bool) {
+	cb := m.GetCircuitBreaker(serverURL)
+	return cb, cb != nil
 }
 
 // AllowRequest проверяет, разрешен ли запрос к серверу
 func (m *CBManager) AllowRequest(serverURL string) (bool, State) {
-	cb := m.GetCircuitBreaker(serverURL)
-	if cb == nil {
-		//logger.Warningf("Circuit breaker not configured for %s, allowing request", serverURL)
-		return true, notConfigured // Если CB не настроен, разрешаем запрос
-	}
-	return cb.allow()
+	allowed, state, _ := m.AllowRequestDetailed(serverURL)
+	return allowed, state
 
 	/*
 		allowed := cb.Allow()
@@ -60,20 +415,211 @@ func (m *CBManager) AllowRequest(serverURL string) (bool, State) {
 	*/
 }
 
+// DisableAll глобально отключает блокировку: AllowRequest пропускает все запросы,
+// в том числе к разомкнутым CB, не выполняя переходов и не расходуя квоту half-open.
+// Учет результатов через Report* продолжается, поэтому после EnableAll CB работают
+// с актуальным состоянием. Предназначен для экстренного отключения во время инцидентов.
+func (m *CBManager) DisableAll() {
+	m.disabled.Store(true)
+}
+
+// EnableAll восстанавливает блокировку после DisableAll
+func (m *CBManager) EnableAll() {
+	m.disabled.Store(false)
+}
+
+// AllowRequestDetailed проверяет, разрешен ли запрос к серверу, и дополнительно
+// возвращает причину решения (например, для логов с объяснением отказа)
+func (m *CBManager) AllowRequestDetailed(serverURL string) (bool, State, DecisionReason) {
+	a := m.allowRequest(serverURL, PriorityNormal, false)
+	return a.allowed, a.state, a.reason
+}
+
+// Приоритеты запросов для AllowRequestPriority
+const (
+	PriorityLow    = -1 // фоновые запросы: в half-open отклоняются первыми
+	PriorityNormal = 0  // приоритет AllowRequest
+)
+
+// AllowRequestPriority проверяет, разрешен ли запрос к серверу, с учетом его приоритета.
+// Приоритет влияет на отбор в half-open при ограничении HalfOpenMaxConcurrent: запросам
+// с отрицательным приоритетом доступна только половина слотов, поэтому при нехватке
+// бюджета проб пропускаются более важные запросы. В остальных случаях работает как AllowRequest.
+func (m *CBManager) AllowRequestPriority(serverURL string, priority int) (bool, State) {
+	a := m.allowRequest(serverURL, priority, false)
+	return a.allowed, a.state
+}
+
+// admission - решение менеджера о пропуске запроса
+type admission struct {
+	allowed bool
+	state   State
+	reason  DecisionReason
+	cb      *CircuitBreaker // CB, принявший решение (nil, если CB не настроен)
+	gen     uint64          // период состояния CB, в котором пропущен запрос (см. Ticket)
+}
+
+// allowRequest принимает решение о пропуске запроса с приоритетом priority.
+// При reserve пропущенный запрос занимает слот in_flight своего CB (см. Acquire).
+func (m *CBManager) allowRequest(serverURL string, priority int, reserve bool) admission {
+	if m.disabled.Load() {
+		// Блокировка отключена: пропускаем запрос, не меняя состояние CB
+		a := admission{allowed: true, state: m.Peek(serverURL), reason: ReasonDisabled}
+		if cb := m.GetCircuitBreaker(serverURL); cb != nil {
+			a.cb, a.gen = cb, cb.generation.Load()
+			if reserve {
+				cb.inFlight.Add(1)
+			}
+		}
+		return a
+	}
+	cb, _ := m.getOrCreate(serverURL)
+	if cb == nil {
+		//logger.Warningf("Circuit breaker not configured for %s, allowing request", serverURL)
+		// Если CB не настроен, разрешаем запрос (в строгом режиме - запрещаем)
+		if m.isStrict() {
+			return admission{state: notConfigured, reason: ReasonStrictNotConfigured}
+		}
+		return admission{allowed: true, state: notConfigured, reason: ReasonNotConfigured}
+	}
+	// Группа проверяется до отбора, чтобы отклоненный запрос не занял пробу, слоты и токены CB
+	if m.groupBlocked(serverURL) {
+		cb.gate(ReasonGroupOpen)
+		return admission{state: stateOpen, reason: ReasonGroupOpen}
+	}
+	allowed, state, reason, gen := cb.admit(priority, reserve)
+	return admission{allowed: allowed, state: state, reason: reason, cb: cb, gen: gen}
+}
+
+// AllowRequestContext проверяет, разрешен ли запрос к серверу, с учетом контекста вызывающего.
+// Если ctx уже завершен (отменен или истек дедлайн), запрос отклоняется без обращения к CB:
+// завершенный контекст имеет приоритет над вероятностным пропуском в half-open,
+// а переход open -> half-open в этом случае не выполняется.
+func (m *CBManager) AllowRequestContext(ctx context.Context, serverURL string) (bool, State) {
+	if ctx.Err() != nil {
+		cb := m.GetCircuitBreaker(serverURL)
+		if cb == nil {
+			return false, notConfigured
+		}
+		return false, cb.curState()
+	}
+	return m.AllowRequest(serverURL)
+}
+
 // ReportSuccess отмечает успешный запрос
-func (m *CBManager) ReportSuccess(serverURL string) {
-	cb := m.GetCircuitBreaker(serverURL)
+// В строгом режиме для ненастроенного сервера возвращается ErrBreakerNotFound.
+func (m *CBManager) ReportSuccess(serverURL string) error {
+	cb, err := m.lookup(serverURL)
 	if cb != nil {
 		cb.success()
 	}
+	return err
 }
 
-// ReportFailure отмечает неудачный запрос
-func (m *CBManager) ReportFailure(serverURL string) {
-	cb := m.GetCircuitBreaker(serverURL)
+// ReportFailure отмечает неудачный запрос.
+// В строгом режиме для ненастроенного сервера возвращается ErrBreakerNotFound.
+func (m *CBManager) ReportFailure(serverURL string) error {
+	cb, err := m.lookup(serverURL)
 	if cb != nil {
 		cb.failure()
 	}
+	return err
+}
+
+// ReportFailureWeighted отмечает неудачный запрос с весом weight (1.0 - обычная неудача).
+// Тяжелые неудачи (например, таймаут после долгого ожидания) быстрее приближают CB к размыканию:
+// при стратегии count CB размыкается, когда взвешенный счет ошибок достигает FailureThreshold.
+// Вес <= 0 считается равным 1. В строгом режиме для ненастроенного сервера возвращается ErrBreakerNotFound.
+func (m *CBManager) ReportFailureWeighted(serverURL string, weight float64) error {
+	cb, err := m.lookup(serverURL)
+	if cb != nil {
+		cb.failureWeighted(weight)
+	}
+	return err
+}
+
+// ReportFailureCategory отмечает неудачный запрос с указанием категории причины
+// (например, "timeout", "connection_refused", "5xx"). Счетчики по категориям доступны
+// в статистике под ключом failures_by_category; ReportFailure использует категорию
+// FailureUnspecified. В строгом режиме для ненастроенного сервера возвращается ErrBreakerNotFound.
+func (m *CBManager) ReportFailureCategory(serverURL, category string) error {
+	cb, err := m.lookup(serverURL)
+	if cb != nil {
+		cb.failureCategory(category)
+	}
+	return err
+}
+
+// ReportTimeout отмечает таймаут запроса. Таймаут учитывается как неудача категории
+// FailureTimeout с весом TimeoutWeight и отдельно в счетчике timeout_count, который при
+// заданном TimeoutThreshold размыкает CB независимо от порога обычных ошибок.
+// В строгом режиме для ненастроенного сервера возвращается ErrBreakerNotFound.
+func (m *CBManager) ReportTimeout(serverURL string) error {
+	cb, err := m.lookup(serverURL)
+	if cb != nil {
+		cb.timeout()
+	}
+	return err
+}
+
+// SetStrictServers включает строгий режим: AllowRequest для ненастроенного сервера
+// возвращает (false, notConfigured), а Report* - ErrBreakerNotFound. Это позволяет
+// обнаружить опечатки в именах серверов. По умолчанию режим выключен: запросы к
+// ненастроенным серверам пропускаются, а отчеты о них игнорируются.
+func (m *CBManager) SetStrictServers(strict bool) {
+	m.mu.Lock()
+	m.strictServers = strict
+	m.mu.Unlock()
+}
+
+// isStrict сообщает, включен ли строгий режим
+func (m *CBManager) isStrict() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.strictServers
+}
+
+// lookup возвращает CB сервера. Если CB не найден, в строгом режиме возвращается ErrBreakerNotFound.
+func (m *CBManager) lookup(serverURL string) (*CircuitBreaker, error) {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil && m.isStrict() {
+		return nil, fmt.Errorf("%w: %s", ErrBreakerNotFound, serverURL)
+	}
+	return cb, nil
+}
+
+// GetConfig возвращает эффективную конфигурацию Circuit Breaker сервера.
+// Второе значение false, если CB для сервера не настроен.
+func (m *CBManager) GetConfig(serverURL string) (CircuitBreakerConf, bool) {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return CircuitBreakerConf{}, false
+	}
+	return cb.Config(), true
+}
+
+// ReportResult отмечает результат запроса по ошибке: nil - успех, иначе неудача
+// (с учетом классификатора CircuitBreakerConf.IsFailure).
+// В строгом режиме для ненастроенного сервера возвращается ErrBreakerNotFound.
+func (m *CBManager) ReportResult(serverURL string, err error) error {
+	cb, lookupErr := m.lookup(serverURL)
+	if cb != nil {
+		cb.result(err)
+	}
+	return lookupErr
+}
+
+// RetryAfter возвращает время, оставшееся до истечения таймаута восстановления
+// разомкнутого CB (не меньше 0; 0 означает, что CB готов перейти в half-open).
+// Для замкнутого, half-open или ненастроенного CB, для CB, исчерпавшего
+// MaxRecoveryAttempts, а также для CB с RecoveryPolicy, пока она не разрешила
+// переход в half-open, второе значение false.
+func (m *CBManager) RetryAfter(serverURL string) (time.Duration, bool) {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return 0, false
+	}
+	return cb.retryAfter()
 }
 
 // GetCircuitBreakerStats возвращает статистику всех Circuit Breakers
@@ -88,6 +634,220 @@ func (m *CBManager) GetCircuitBreakerStats() map[string]any {
 	return stats
 }
 
+// FastStats возвращает сокращенную статистику всех CB (см. CircuitBreaker.FastStats)
+// без захвата мьютексов отдельных CB. Частый опрос FastStats не конкурирует с обработкой
+// запросов; для точного снимка используйте GetCircuitBreakerStats.
+func (m *CBManager) FastStats() map[string]any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := make(map[string]any, len(m.breakers))
+	for srv, cb := range m.breakers {
+		stats[srv] = cb.FastStats()
+	}
+	return stats
+}
+
+// Dump возвращает статистику всех CB в текстовом виде: по строке на CB в формате
+// "name: key=value ..." (см. CircuitBreaker.StatsString), упорядоченно по имени.
+// Предназначен для логов и отладки: при одинаковом состоянии вывод совпадает между запусками.
+func (m *CBManager) Dump() string {
+	var b strings.Builder
+	for _, s := range m.breakerSnapshot() {
+		fmt.Fprintf(&b, "%s: %s\n", s.name, s.cb.StatsString())
+	}
+	return b.String()
+}
+
+// namedBreaker - CB с ключом, под которым он зарегистрирован
+type namedBreaker struct {
+	name string
+	cb   *CircuitBreaker
+}
+
+// breakerSnapshot возвращает все CB менеджера, упорядоченные по имени.
+// Блокировка менеджера удерживается только на время копирования.
+func (m *CBManager) breakerSnapshot() []namedBreaker {
+	m.mu.RLock()
+	snapshot := make([]namedBreaker, 0, len(m.breakers))
+	for srv, cb := range m.breakers {
+		snapshot = append(snapshot, namedBreaker{name: srv, cb: cb})
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(snapshot, func(a, b namedBreaker) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return snapshot
+}
+
+// ForEach вызывает fn для каждого Circuit Breaker со снимком его статистики.
+// Имена CB собираются под блокировкой менеджера, но fn вызывается без нее,
+// поэтому внутри fn можно обращаться к менеджеру. CB, добавленные или удаленные
+// во время обхода, могут как попасть, так и не попасть в обход.
+func (m *CBManager) ForEach(fn func(name string, s Stats)) {
+	m.mu.RLock()
+	names := make([]string, 0, len(m.breakers))
+	for srv := range m.breakers {
+		names = append(names, srv)
+	}
+	m.mu.RUnlock()
+
+	for _, srv := range names {
+		m.mu.RLock()
+		cb := m.breakers[srv]
+		m.mu.RUnlock()
+		if cb == nil {
+			continue
+		}
+		fn(srv, cb.stats())
+	}
+}
+
+// Health - сводка состояния всех Circuit Breakers менеджера
+type Health struct {
+	Total    int
+	Closed   int
+	Open     int
+	HalfOpen int
+	Healthy  bool // false, если доля разомкнутых CB превышает допустимую (см. SetMaxOpenFraction)
+}
+
+// SetMaxOpenFraction задает долю разомкнутых CB (0..1), при превышении которой HealthSummary
+// считает систему нездоровой. По умолчанию 0: любой разомкнутый CB делает систему нездоровой.
+func (m *CBManager) SetMaxOpenFraction(f float64) {
+	if f < 0 {
+		f = 0
+	} else if f > 1 {
+		f = 1
+	}
+	m.mu.Lock()
+	m.maxOpenFraction = f
+	m.mu.Unlock()
+}
+
+// HealthSummary возвращает сводку состояний всех CB, снятую под одной блокировкой менеджера.
+// Подходит для readiness-проб: Healthy отражает общее состояние зависимостей.
+func (m *CBManager) HealthSummary() Health {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	h := Health{Total: len(m.breakers)}
+	for _, cb := range m.breakers {
+		switch cb.curState() {
+		case stateClosed:
+			h.Closed++
+		case stateOpen:
+			h.Open++
+		case stateHalfOpen:
+			h.HalfOpen++
+		}
+	}
+	h.Healthy = h.Total == 0 || float64(h.Open)/float64(h.Total) <= m.maxOpenFraction
+	return h
+}
+
+// OpenBreaker описывает разомкнутый CB в OpenBreakersReport
+type OpenBreaker struct {
+	Name      string
+	OpenSince time.Time     // момент последнего перехода в open
+	Downtime  time.Duration // время в open к моменту отчета
+}
+
+// OpenBreakersReport возвращает разомкнутые CB (отсортированные по имени) с временем
+// размыкания. Дополняет HealthSummary для обзора инцидентов.
+func (m *CBManager) OpenBreakersReport() []OpenBreaker {
+	var report []OpenBreaker
+	for _, nb := range m.breakerSnapshot() {
+		if since, downtime, ok := nb.cb.openSince(); ok {
+			report = append(report, OpenBreaker{Name: nb.name, OpenSince: since, Downtime: downtime})
+		}
+	}
+	return report
+}
+
+// StateSnapshot возвращает текущие состояния всех CB менеджера по именам серверов
+func (m *CBManager) StateSnapshot() map[string]State {
+	snapshot := make(map[string]State)
+	for _, s := range m.stateSnapshot() {
+		snapshot[s.name] = s.state
+	}
+	return snapshot
+}
+
+// Diff сравнивает текущие состояния CB со снимком prev (см. StateSnapshot) и возвращает
+// только изменившиеся серверы: [0] - состояние в prev, [1] - текущее. Для CB, добавленных
+// после снимка, прежнее состояние - StateNotConfigured, для удаленных - текущее.
+func (m *CBManager) Diff(prev map[string]State) map[string][2]State {
+	cur := m.StateSnapshot()
+	diff := make(map[string][2]State)
+	for name, state := range cur {
+		old, ok := prev[name]
+		if !ok {
+			old = notConfigured
+		}
+		if old != state {
+			diff[name] = [2]State{old, state}
+		}
+	}
+	for name, old := range prev {
+		if _, ok := cur[name]; !ok && old != notConfigured {
+			diff[name] = [2]State{old, notConfigured}
+		}
+	}
+	return diff
+}
+
+// RelaxFor временно умножает порог ошибок CB сервера на multiplier на время d
+// (например, на время выкладки, когда ожидаются кратковременные ошибки). По истечении d
+// порог автоматически возвращается к настроенному; конфигурация CB не изменяется.
+// Повторный вызов заменяет предыдущее ослабление.
+func (m *CBManager) RelaxFor(serverURL string, multiplier float64, d time.Duration) error {
+	if multiplier <= 0 {
+		return fmt.Errorf("%w: threshold multiplier %v must be positive", ErrInvalidConfig, multiplier)
+	}
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return fmt.Errorf("%w: %s", ErrBreakerNotFound, serverURL)
+	}
+	cb.relax(multiplier, d)
+	return nil
+}
+
+// Reset принудительно переводит CB сервера в closed со сбросом счетчиков. В том числе
+// снимает блокировку автоматического восстановления после исчерпания MaxRecoveryAttempts.
+func (m *CBManager) Reset(serverURL string) error {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return fmt.Errorf("%w: %s", ErrBreakerNotFound, serverURL)
+	}
+	cb.reset()
+	return nil
+}
+
+// TriggerProbe немедленно переводит разомкнутый CB сервера в half-open, не дожидаясь
+// RecoveryTimeout, чтобы следующие запросы проверили сервер. Полезно, когда о восстановлении
+// известно из внешнего сигнала. Для closed и half-open CB ничего не делает.
+func (m *CBManager) TriggerProbe(serverURL string) error {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return fmt.Errorf("%w: %s", ErrBreakerNotFound, serverURL)
+	}
+	cb.triggerProbe()
+	return nil
+}
+
+// Peek возвращает состояние CB сервера без побочных эффектов: разомкнутый CB с истекшим
+// таймаутом восстановления сообщается как half-open, но фактический переход не выполняется
+// и квота half-open не расходуется. Подходит для часто опрашивающих дашбордов.
+func (m *CBManager) Peek(serverURL string) State {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return notConfigured
+	}
+	return cb.peek()
+}
+
 // GetCircuitBreakerstate возвращает текстовое состояние Circuit Breaker
 func (m *CBManager) GetCircuitBreakerState(serverURL string) string {
 	cb := m.GetCircuitBreaker(serverURL)
@@ -112,3 +872,15 @@ func (m *CBManager) GetCircuitBreakerState(serverURL string) string {
 
 	*/
 }
+
+// GetCircuitBreakerStateDetailed возвращает текстовое состояние CB (как GetCircuitBreakerState)
+// и время, оставшееся до истечения таймаута восстановления, например для вывода
+// "open (retry in 12s)". Для состояний, отличных от open, retryAfter равен 0.
+func (m *CBManager) GetCircuitBreakerStateDetailed(serverURL string) (state string, retryAfter time.Duration) {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return "disabled", 0
+	}
+	s, wait := cb.stateRetryAfter()
+	return s.String(), wait
+}
@@ -10,19 +10,268 @@ package circuitbreaker
 
 import (
 	"errors"
+	"fmt"
+	"maps"
+	"math"
 	"math/rand/v2"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrCircuitOpen возвращается, когда circuit breaker не пропускает запрос
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
 // Структура для конфигурации Circuit Breaker
 type CircuitBreakerConf struct {
-	FailureThreshold int           `yaml:"failure_threshold"` // Количество неудач до срабатывания
-	RecoveryTimeout  time.Duration `yaml:"recovery_timeout"`  // Время до попытки восстановления
-	SuccessThreshold int           `yaml:"success_threshold"` // Количество успешных запросов для восстановления
-	HalfOpenPrc      int           `yaml:"half_open_prc"`     // Процент пропускаемых запросов
+	FailureThreshold        int           `yaml:"failure_threshold"`         // Количество неудач до срабатывания
+	RecoveryTimeout         time.Duration `yaml:"recovery_timeout"`          // Время до попытки восстановления
+	SuccessThreshold        int           `yaml:"success_threshold"`         // Количество успешных запросов для восстановления
+	HalfOpenPrc             int           `yaml:"half_open_prc"`             // Процент пропускаемых в half-open запросов (см. StrictValidation)
+	WarmupPeriod            time.Duration `yaml:"warmup_period"`             // Период после создания, в течение которого CB не размыкается
+	WarnThreshold           int           `yaml:"warn_threshold"`            // Порог ошибок для предупреждения (меньше FailureThreshold, 0 - отключено)
+	MaxRecoveryAttempts     int           `yaml:"max_recovery_attempts"`     // Неудачных попыток восстановления подряд, после которых CB остается в open до Reset/TriggerProbe (0 - без ограничений)
+	ClosedRecoverySuccesses int           `yaml:"closed_recovery_successes"` // Серия успехов в closed, после которой счетчик ошибок обнуляется (0 - отключено)
+
+	// Экспоненциальное увеличение таймаута восстановления: после каждой неудачной попытки
+	// восстановления (half-open -> open) RecoveryTimeout умножается на RecoveryBackoff
+	// (<= 1 - без увеличения), но не превышает MaxRecoveryTimeout (0 - без ограничения).
+	// Задержка сбрасывается до RecoveryTimeout при замыкании CB, а при BackoffResetOnProbe -
+	// уже после первой успешной пробы в half-open, даже если для замыкания нужны еще успехи.
+	RecoveryBackoff     float64       `yaml:"recovery_backoff"`
+	MaxRecoveryTimeout  time.Duration `yaml:"max_recovery_timeout"`
+	BackoffResetOnProbe bool          `yaml:"backoff_reset_on_probe"`
+
+	// Испытательный срок: только что созданный или сброшенный (Reset) CB размыкается уже
+	// после ProbationFailureThreshold ошибок, пока не получит ProbationSuccesses успехов
+	// в closed; затем действует обычный FailureThreshold. ProbationSuccesses = 0 - отключено;
+	// ProbationFailureThreshold по умолчанию - половина FailureThreshold (не меньше 1).
+	ProbationSuccesses        int `yaml:"probation_successes"`
+	ProbationFailureThreshold int `yaml:"probation_failure_threshold"`
+
+	// Количество ошибок, допустимых в half-open до возврата в open ("мягкий" режим).
+	// Ошибка сбрасывает счетчик успехов, но размыкает CB только при превышении допуска.
+	// 0 - "жесткий" режим: любая ошибка в half-open возвращает в open.
+	HalfOpenFailureTolerance int `yaml:"half_open_failure_tolerance"`
+
+	// Минимальная длительность half-open без ошибок перед переходом в closed.
+	// CB замыкается, только когда выполнены и порог успехов, и это условие.
+	HalfOpenStabilizeDuration time.Duration `yaml:"half_open_stabilize_duration"`
+
+	// Первая проба после перехода в half-open выполняется в одиночку: остальные запросы
+	// отклоняются, пока не получен ее результат. Успех продолжает обычную логику half-open,
+	// ошибка сразу возвращает CB в open. Результат пробы обязательно нужно сообщить
+	// (например, через Ticket или Execute), иначе CB не пропустит другие запросы.
+	HalfOpenSingleProbe bool `yaml:"half_open_single_probe"`
+
+	// Максимум запросов, одновременно пропущенных в half-open без сообщенного результата
+	// (0 - без ограничений). Применяется вместе с HalfOpenStrategy; при нехватке слотов
+	// в первую очередь отклоняются запросы с отрицательным приоритетом (см. AllowRequestPriority).
+	HalfOpenMaxConcurrent int `yaml:"half_open_max_concurrent"`
+
+	// Адаптивный бюджет проб: если HalfOpenMaxConcurrentCap больше HalfOpenMaxConcurrent,
+	// лимит одновременных проб начинается с HalfOpenMaxConcurrent, растет на единицу с каждой
+	// засчитанной успешной пробой (до HalfOpenMaxConcurrentCap) и уменьшается на единицу
+	// с каждой допущенной ошибкой (HalfOpenFailureTolerance), но не ниже 1. Бюджет
+	// сбрасывается при каждом переходе в half-open.
+	HalfOpenMaxConcurrentCap int `yaml:"half_open_max_concurrent_cap"`
+
+	HalfOpenStrategy       HalfOpenStrategy `yaml:"half_open_strategy"`        // Способ отбора запросов в half-open (по умолчанию random)
+	HalfOpenBucketSize     int              `yaml:"half_open_bucket_size"`     // Емкость ведра токенов в half-open (по умолчанию 1)
+	HalfOpenRefillInterval time.Duration    `yaml:"half_open_refill_interval"` // Интервал восполнения одного токена (по умолчанию 1 секунда)
+	HalfOpenRampStep       int              `yaml:"half_open_ramp_step"`       // Прирост доли пропускаемых запросов за успех для ramp_up (по умолчанию 10)
+
+	// Максимальная длительность half-open (0 - без ограничений). Если за это время CB не
+	// принял решение (например, из-за отсутствия трафика), он переходит в состояние согласно
+	// HalfOpenTimeoutPolicy: reopen (по умолчанию) - в open, close - в closed.
+	HalfOpenTimeout       time.Duration         `yaml:"half_open_timeout"`
+	HalfOpenTimeoutPolicy HalfOpenTimeoutPolicy `yaml:"half_open_timeout_policy"`
+
+	// Стратегия размыкания (по умолчанию count): count - по FailureThreshold ошибок,
+	// ratio - по доле ошибок FailureRatio среди последних WindowSize запросов
+	TripStrategy TripStrategy `yaml:"trip_strategy"`
+	WindowSize   int          `yaml:"window_size"`   // Размер скользящего окна запросов для ratio (по умолчанию 20)
+	MinRequests  int          `yaml:"min_requests"`  // Минимум запросов в окне для оценки доли (по умолчанию WindowSize)
+	FailureRatio float64      `yaml:"failure_ratio"` // Доля ошибок в окне для размыкания (0..1, по умолчанию 0.5)
+
+	// Ограничение частоты пропущенных запросов в любом состоянии (0 - без ограничения):
+	// не более MaxRPS запросов в секунду с допустимым всплеском до MaxRPS. Запросы сверх
+	// лимита отклоняются с причиной rate_limited, в half-open - без расхода квоты проб.
+	MaxRPS int `yaml:"max_rps"`
+
+	// Засчитывать в half-open не больше одного успеха от каждого источника (ключа клиента,
+	// переданного в AcquireFrom/ExecuteFrom): для замыкания нужны успехи SuccessThreshold
+	// разных источников, и один активный клиент не решает за всех. Успехи без ключа
+	// (ReportSuccess, Acquire) считаются одним общим источником. Набор источников
+	// сбрасывается при каждом переходе в half-open.
+	HalfOpenDistinctSources bool `yaml:"half_open_distinct_sources"`
+
+	// Ограничение одновременно выполняющихся запросов (bulkhead) в любом состоянии
+	// (0 - без ограничения). Слоты занимают запросы, допущенные через Acquire/Execute,
+	// до закрытия билета; при заполнении запросы отклоняются с причиной bulkhead_full.
+	// AllowRequest при заполнении тоже отклоняет запросы, но слот не занимает.
+	MaxConcurrent int `yaml:"max_concurrent"`
+
+	// Пользовательская политика размыкания; если задана, заменяет TripStrategy
+	// (TimeoutThreshold продолжает действовать). См. TripPolicy.
+	TripPolicy TripPolicy `yaml:"-"`
+
+	// Пользовательская политика восстановления; если задана, заменяет RecoveryTimeout
+	// и SuccessThreshold в решениях о переходах open -> half-open и half-open -> closed.
+	// См. RecoveryPolicy.
+	RecoveryPolicy RecoveryPolicy `yaml:"-"`
+
+	// Гистограмма результатов по интервалам HistogramBucket за последние HistogramRetention
+	// (по умолчанию 1 час) для анализа инцидентов, см. CBManager.FailureHistogram.
+	// Отключена, если HistogramBucket равен 0.
+	HistogramBucket    time.Duration `yaml:"histogram_bucket"`
+	HistogramRetention time.Duration `yaml:"histogram_retention"`
+
+	FlapWindow time.Duration `yaml:"flap_window"` // Окно оценки частоты переключений (по умолчанию 1 минута)
+	FlapRate   float64       `yaml:"flap_rate"`   // Частота переключений (в минуту), при которой вызывается OnFlap (0 - отключено)
+
+	// Дополнительные измерения для метрик (регион, команда и т.п.): попадают в статистику
+	// и MetricsSnapshot. Копируются при создании CB, изменяются только через UpdateConfig.
+	Labels map[string]string `yaml:"labels"`
+
+	OnStateChange func(name string, from, to State)               `yaml:"-"` // Вызывается при каждом переходе между состояниями
+	OnWarn        func(name string, count int)                    `yaml:"-"` // Вызывается один раз при превышении WarnThreshold
+	OnFlap        func(name string, transitionsPerMinute float64) `yaml:"-"` // Вызывается при превышении FlapRate
+
+	// OnRecover вызывается при переходе half-open -> closed; downtime - время от выхода
+	// из closed (обычно первого размыкания) в текущем инциденте до восстановления
+	OnRecover func(name string, downtime time.Duration) `yaml:"-"`
+
+	// OnReject вызывается при каждом отклоненном запросе с причиной отказа. Отказы при отборе
+	// в half-open (half_open_denied, probe_in_flight, half_open_busy) - штатная часть проверки
+	// восстановления, их следует отличать от отказов разомкнутого CB. Должен быть быстрым.
+	OnReject func(name string, reason DecisionReason) `yaml:"-"`
+
+	// OnDecision вызывается при каждом решении CB о пропуске запроса (Allow, AllowRequest,
+	// Acquire, Execute и т.п.) - для выборочной телеметрии и трассировки. Вызов синхронный
+	// и находится на горячем пути: стоимость обработчика добавляется к каждому запросу,
+	// поэтому он должен быть очень быстрым и не обращаться к CB. Без обработчика (по умолчанию)
+	// накладные расходы сводятся к одной атомарной загрузке. Сообщается решение самого CB,
+	// без учета групп (GateMembers) и DisableAll.
+	OnDecision func(name string, allowed bool, state State, reason DecisionReason) `yaml:"-"`
+
+	// Отдельный учет таймаутов (ReportTimeout). Таймаут всегда учитывается как неудача
+	// с весом TimeoutWeight (по умолчанию 1); дополнительно при TimeoutThreshold > 0
+	// CB размыкается, когда количество таймаутов в closed достигает этого порога.
+	TimeoutThreshold int     `yaml:"timeout_threshold"`
+	TimeoutWeight    float64 `yaml:"timeout_weight"`
+
+	// Минимальное время пребывания в состоянии: переходы, вызванные результатами запросов,
+	// откладываются до его истечения (результаты при этом учитываются). Сглаживает
+	// переключения при нестабильном сервере; переход open -> half-open и ручные переходы
+	// (Reset, TriggerProbe) не откладываются.
+	MinStateDuration time.Duration `yaml:"min_state_duration"`
+
+	// Начальное состояние CB: closed (по умолчанию) или open. CB, созданный в open
+	// (например, на время обслуживания), пропускает запросы после RecoveryTimeout.
+	InitialState State `yaml:"initial_state"`
+
+	// Постоянно разомкнутый CB (например, для декларативного отключения заведомо неисправного
+	// сервера): CB создается в open независимо от InitialState и не восстанавливается
+	// автоматически, пока не будет вызван Reset или не применена конфигурация без AlwaysOpen.
+	// Запросы отклоняются с причиной forced_open; TriggerProbe и Link на такой CB не действуют.
+	AlwaysOpen bool `yaml:"always_open"`
+
+	// Уменьшение счетчика ошибок на единицу при каждом успехе в closed (nil - по умолчанию,
+	// включено): CB работает как "дырявое ведро" ошибок. При false любой успех обнуляет
+	// счетчики ошибок, и CB размыкается только после FailureThreshold ошибок подряд.
+	DecrementOnSuccess *bool `yaml:"decrement_on_success"`
+
+	// Строгая проверка конфигурации при создании CB и в UpdateConfig: вместо тихой замены
+	// значений по умолчанию возвращается ошибка ErrInvalidConfig (см. Validate). В обычном
+	// режиме HalfOpenPrc <= 0 (в том числе незаданный) заменяется на 20, а > 100 - на 100;
+	// в строгом режиме HalfOpenPrc должен быть задан явно в диапазоне 1..100, так что
+	// нулевое значение не превращается незаметно в 20%.
+	StrictValidation bool `yaml:"strict_validation"`
+
+	// Поведение Execute при панике в fn: паника всегда учитывается как неудача, после чего
+	// по умолчанию возбуждается повторно, а при RecoverPanics = true возвращается ошибка ErrPanic
+	RecoverPanics bool `yaml:"recover_panics"`
+
+	// Учет неудач, сообщенных в open, в диагностическом счетчике open_failures (сбрасывается
+	// при каждом размыкании). Состояние CB не меняется; помогает отличить полностью недоступный
+	// сервер от тихо восстановившегося.
+	CountOpenFailures bool `yaml:"count_open_failures"`
+
+	// Адаптивный сброс нагрузки в closed (выключен, если LoadShedStart равен 0). Когда доля
+	// ошибок в скользящем окне WindowSize (не менее MinRequests запросов) достигает LoadShedStart,
+	// CB отклоняет случайную долю запросов, линейно растущую от LoadShedMin до maxShedFraction
+	// при доле ошибок LoadShedFull (по умолчанию 1). Размыкание по TripStrategy работает как обычно.
+	LoadShedStart float64 `yaml:"load_shed_start"`
+	LoadShedMin   float64 `yaml:"load_shed_min"`
+	LoadShedFull  float64 `yaml:"load_shed_full"`
+
+	// LoadSignal возвращает текущую нагрузку на сервер как долю от емкости (например,
+	// число выполняющихся запросов к допустимому). Если задан, порог FailureThreshold
+	// (стратегия count) адаптируется к нагрузке: повышается при низкой и понижается при высокой.
+	// Вызывается под блокировкой CB, поэтому должен быть быстрым и не обращаться к CB.
+	LoadSignal func() float64 `yaml:"-"`
+
+	// IsFailure определяет, считается ли ошибка неудачей (по умолчанию - любая ненулевая ошибка).
+	// Ошибки, для которых возвращается false, учитываются как успех.
+	IsFailure func(err error) bool `yaml:"-"`
+
+	Clock Clock `yaml:"-"` // Источник времени (по умолчанию системные часы)
+}
+
+// HalfOpenStrategy определяет, как в half-open выбираются пропускаемые запросы
+type HalfOpenStrategy string
+
+const (
+	// HalfOpenRandom пропускает каждый запрос с вероятностью HalfOpenPrc%
+	HalfOpenRandom HalfOpenStrategy = "random"
+	// HalfOpenDeterministic пропускает каждый N-й запрос, где N = 100/HalfOpenPrc
+	// (например, при 25% пропускается ровно 1 запрос из 4)
+	HalfOpenDeterministic HalfOpenStrategy = "deterministic"
+	// HalfOpenTokenBucket пропускает запросы, пока в ведре есть токены: каждый пропущенный
+	// запрос расходует токен, токены восполняются по одному за HalfOpenRefillInterval
+	// (не более HalfOpenBucketSize). Частота проб ограничена независимо от входящего потока;
+	// HalfOpenPrc в этом режиме не используется.
+	HalfOpenTokenBucket HalfOpenStrategy = "token_bucket"
+	// HalfOpenRampUp начинает с HalfOpenPrc% и увеличивает долю пропускаемых запросов
+	// на HalfOpenRampStep после каждого успеха; ошибка (в пределах HalfOpenFailureTolerance)
+	// возвращает долю к HalfOpenPrc. CB замыкается только по достижении 100% и SuccessThreshold.
+	HalfOpenRampUp HalfOpenStrategy = "ramp_up"
+)
+
+// HalfOpenTimeoutPolicy определяет переход по истечении HalfOpenTimeout
+type HalfOpenTimeoutPolicy string
+
+const (
+	// HalfOpenTimeoutReopen возвращает CB в open (консервативная политика)
+	HalfOpenTimeoutReopen HalfOpenTimeoutPolicy = "reopen"
+	// HalfOpenTimeoutClose замыкает CB (оптимистичная политика)
+	HalfOpenTimeoutClose HalfOpenTimeoutPolicy = "close"
+)
+
+// TripStrategy определяет условие перехода closed -> open
+type TripStrategy string
+
+const (
+	// CountBased размыкает CB, когда счетчик ошибок достигает FailureThreshold
+	CountBased TripStrategy = "count"
+	// RatioBased размыкает CB, когда доля ошибок среди последних WindowSize запросов
+	// достигает FailureRatio (при наличии в окне не менее MinRequests запросов)
+	RatioBased TripStrategy = "ratio"
+)
+
+// Clock - источник текущего времени. Позволяет подменять время в тестах.
+type Clock interface {
+	Now() time.Time
 }
 
+// realClock использует системные часы
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
 // State представляет состояние Circuit Breaker
 type State uint8
 
@@ -34,28 +283,167 @@ const (
 	notConfigured
 )
 
-// circuitBreaker реализует паттерн Circuit Breaker
-type circuitBreaker struct {
+// Экспортируемые состояния для использования вне пакета (например, в заглушках Breaker)
+const (
+	StateClosed        = stateClosed
+	StateOpen          = stateOpen
+	StateHalfOpen      = stateHalfOpen
+	StateNotConfigured = notConfigured
+)
+
+// CircuitBreaker реализует паттерн Circuit Breaker
+type CircuitBreaker struct {
 	mu               sync.RWMutex
 	state            State
 	failureCount     int
+	failureScore     float64       // взвешенный счет ошибок в closed (для ReportFailureWeighted)
+	closedStreak     int           // серия успехов подряд в closed
+	timeoutCount     int           // таймауты в closed (для TimeoutThreshold)
+	periodSuccesses  int           // успехи с момента последнего перехода
+	periodFailures   int           // неудачи с момента последнего перехода
+	window           outcomeWindow // результаты последних запросов в closed (стратегия ratio)
+	hist             *histogram    // гистограмма результатов по интервалам (nil - отключена)
 	failureThreshold int
 	recoveryTimeout  time.Duration
 	lastFailureTime  time.Time
-	successCount     int
-	successThreshold int
-	name             string
-	halfOpenPrc      int //процент пропускаемых запросов
-	transaction      int //количество переходв из состояния close в open
+	openedAt         time.Time       // момент последнего перехода в open
+	incidentStart    time.Time       // момент выхода из closed в текущем инциденте (для OnRecover)
+	halfOpenAt       time.Time       // момент последнего перехода в half-open (для HalfOpenTimeout)
+	failedRecoveries int             // неудачные попытки восстановления подряд (half-open -> open)
+	openFailures     int             // неудачи, сообщенные в текущем open (CountOpenFailures)
+	backoffLevel     int             // степень увеличения таймаута восстановления (RecoveryBackoff)
+	totalSuccesses   uint64          // успехи за все время
+	probationLeft    int             // успехов до окончания испытательного срока
+	totalFailures    uint64          // неудачи за все время
+	stateSince       time.Time       // момент последнего перехода (для MinStateDuration)
+	deferred         State           // переход, отложенный до истечения MinStateDuration
+	deferredCause    TransitionCause // причина отложенного перехода
+	hasDeferred      bool            // есть отложенный переход
+	relaxMult        float64         // временный множитель порога ошибок (RelaxFor)
+	relaxUntil       time.Time       // момент окончания действия relaxMult
+	// Копии state, openedAt (UnixNano) и recoveryTimeout для чтения без блокировки в allow().
+	// Если автоматическое восстановление отключено (MaxRecoveryAttempts), fastRecovery бесконечен.
+	// Изменяются только под cb.mu вместе с основными полями.
+	fastState    atomic.Uint32
+	fastOpenedAt atomic.Int64
+	fastRecovery atomic.Int64
+	// Копии счетчиков для FastStats, обновляются при каждом снятии блокировки на запись
+	fastFailures      atomic.Int64
+	fastSuccesses     atomic.Int64
+	fastTransitions   atomic.Int64
+	successCount      int
+	halfOpenStreak    int // успехи подряд в текущем half-open без ограничения порогом (для RecoveryPolicy)
+	successThreshold  int
+	name              string
+	halfOpenPrc       int         //процент пропускаемых запросов
+	transaction       int         //количество переходв из состояния close в open
+	flaps             []time.Time // моменты переходов closed -> open и half-open -> closed в пределах FlapWindow
+	flapping          bool        // OnFlap уже вызван для текущего эпизода
+	clock             Clock
+	createdAt         time.Time
+	softFailures      int                 // ошибки в текущем периоде half-open (мягкий режим)
+	rampPrc           int                 // текущая доля пропускаемых запросов для ramp_up
+	halfOpenSuccesses int                 // успешные пробы в half-open за все время
+	halfOpenFailures  int                 // неудачные пробы в half-open за все время
+	failuresByCat     map[string]uint64   // количество неудач по категориям за все время
+	probeSources      map[string]struct{} // источники успехов в текущем half-open (HalfOpenDistinctSources)
+	cleanSince        time.Time           // начало текущей серии half-open без ошибок
+	warned            bool                // предупреждение о приближении к порогу уже отправлено
+	pending           []func()            // колбэки, ожидающие освобождения cb.mu
+	halfOpenSeq       atomic.Uint64       // порядковый номер запроса в half-open (детерминированный отбор)
+	bucket            tokenBucket         // ведро токенов half-open (стратегия token_bucket)
+	limiter           tokenBucket         // ведро токенов ограничения MaxRPS
+	probe             atomic.Uint32       // состояние одиночной первой пробы (HalfOpenSingleProbe)
+	halfOpenActive    atomic.Int64        // занятые слоты half-open (HalfOpenMaxConcurrent)
+	probeBudget       int                 // текущий лимит слотов half-open (HalfOpenMaxConcurrentCap)
+	notify            func(Event)         // получатель событий переходов (устанавливается менеджером)
+	onRecovered       func(name string)   // распространение восстановления на связанные CB (устанавливается менеджером)
+	publish           func(Event)         // публикация переходов во внешнее хранилище (SetStateStore)
+	inFlight          atomic.Int64        // количество выполняющихся запросов, допущенных через Acquire/Execute
+	draining          atomic.Bool         // режим вывода из эксплуатации: новые запросы отклоняются
+	forcedOpen        atomic.Bool         // CB разомкнут до ручного сброса (AlwaysOpen)
+	generation        atomic.Uint64       // номер периода состояния, увеличивается при каждом переходе
+	staleResults      atomic.Uint64       // результаты билетов, отброшенные из-за смены состояния
+	rejected          atomic.Uint64       // запросы, отклоненные за все время
+	halfOpenDenied    atomic.Uint64       // из них отклоненные при отборе в half-open
+	lastUsed          atomic.Uint64       // логическое время последнего обращения через менеджер (для вытеснения)
+	conf              CircuitBreakerConf  // эффективная конфигурация после применения значений по умолчанию
+
+	// Копии OnReject, OnDecision и MaxConcurrent для чтения без блокировки на горячем пути
+	onReject      atomic.Pointer[rejectFunc]
+	onDecision    atomic.Pointer[decisionFunc]
+	maxConcurrent atomic.Int64
 }
 
 // New создает новый Circuit Breaker
-func new(name string, config CircuitBreakerConf) (*circuitBreaker, error) {
+func new(name string, config CircuitBreakerConf) (*CircuitBreaker, error) {
 	if name == "" {
-		return nil, errors.New("circuit breaker name cannot be empty")
+		return nil, fmt.Errorf("%w: circuit breaker name cannot be empty", ErrInvalidConfig)
+	}
+
+	if config.InitialState != stateClosed && config.InitialState != stateOpen {
+		return nil, fmt.Errorf("%w: initial state %s: only closed and open are allowed", ErrInvalidConfig, config.InitialState)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	config = withDefaults(config)
+	if config.AlwaysOpen {
+		config.InitialState = stateOpen
+	}
+
+	cb := &CircuitBreaker{
+		state:            config.InitialState,
+		failureThreshold: config.FailureThreshold,
+		recoveryTimeout:  config.RecoveryTimeout,
+		successThreshold: config.SuccessThreshold,
+		name:             name,
+		halfOpenPrc:      config.HalfOpenPrc,
+		clock:            config.Clock,
+		createdAt:        config.Clock.Now(),
+		window:           newOutcomeWindow(config.WindowSize),
+		probationLeft:    config.ProbationSuccesses,
+		limiter:          tokenBucket{noIdleCredit: true},
+		conf:             config,
+	}
+	cb.stateSince = cb.createdAt
+	if config.HistogramBucket > 0 {
+		cb.hist = newHistogram(config.HistogramBucket, config.HistogramRetention, cb.createdAt)
+	}
+	if cb.state == stateOpen {
+		// CB, созданный разомкнутым, восстанавливается по обычному таймауту
+		cb.openedAt = cb.createdAt
+		cb.lastFailureTime = cb.createdAt
+		cb.incidentStart = cb.createdAt
+		cb.fastOpenedAt.Store(cb.openedAt.UnixNano())
+	}
+	cb.forcedOpen.Store(config.AlwaysOpen)
+	cb.fastState.Store(uint32(cb.state))
+	cb.syncFastRecoveryLocked()
+	cb.storeHooks(config)
+	return cb, nil
+}
+
+// Validate проверяет конфигурацию. Значения, которые в обычном режиме заменяются
+// значениями по умолчанию (отрицательные пороги и таймаут, HalfOpenPrc вне 1..100,
+// в том числе нулевой), считаются ошибкой только при StrictValidation; ошибки оборачивают ErrInvalidConfig.
+func (c CircuitBreakerConf) Validate() error {
+	if !c.StrictValidation {
+		return nil
+	}
+	if c.HalfOpenPrc < 1 || c.HalfOpenPrc > 100 {
+		return fmt.Errorf("%w: half_open_prc %d: must be set explicitly in 1..100", ErrInvalidConfig, c.HalfOpenPrc)
 	}
+	if c.FailureThreshold < 0 || c.SuccessThreshold < 0 || c.RecoveryTimeout < 0 {
+		return fmt.Errorf("%w: thresholds and recovery timeout must not be negative", ErrInvalidConfig)
+	}
+	return nil
+}
 
-	// Установка значений по умолчанию, если не заданы или заданы некорректно
+// withDefaults устанавливает значения по умолчанию для незаданных или некорректных параметров
+func withDefaults(config CircuitBreakerConf) CircuitBreakerConf {
 	if config.SuccessThreshold <= 0 {
 		config.SuccessThreshold = 3
 	}
@@ -74,115 +462,1589 @@ func new(name string, config CircuitBreakerConf) (*circuitBreaker, error) {
 		config.HalfOpenPrc = 100
 	}
 
-	return &circuitBreaker{
-		state:            stateClosed,
-		failureThreshold: config.FailureThreshold,
-		recoveryTimeout:  config.RecoveryTimeout,
-		successThreshold: config.SuccessThreshold,
-		name:             name,
-		halfOpenPrc:      config.HalfOpenPrc,
-	}, nil
+	if config.WarmupPeriod < 0 {
+		config.WarmupPeriod = 0
+	}
+
+	if config.HalfOpenStabilizeDuration < 0 {
+		config.HalfOpenStabilizeDuration = 0
+	}
+
+	if config.HalfOpenFailureTolerance < 0 {
+		config.HalfOpenFailureTolerance = 0
+	}
+
+	if config.ProbationSuccesses < 0 {
+		config.ProbationSuccesses = 0
+	}
+	if config.ProbationSuccesses > 0 && config.ProbationFailureThreshold <= 0 {
+		config.ProbationFailureThreshold = max(1, config.FailureThreshold/2)
+	}
+
+	switch config.HalfOpenStrategy {
+	case HalfOpenRandom, HalfOpenDeterministic, HalfOpenTokenBucket, HalfOpenRampUp:
+	default:
+		config.HalfOpenStrategy = HalfOpenRandom
+	}
+
+	if config.HalfOpenBucketSize <= 0 {
+		config.HalfOpenBucketSize = 1
+	}
+
+	if config.HalfOpenRefillInterval <= 0 {
+		config.HalfOpenRefillInterval = time.Second
+	}
+
+	if config.HalfOpenRampStep <= 0 {
+		config.HalfOpenRampStep = 10
+	}
+
+	if config.MinStateDuration < 0 {
+		config.MinStateDuration = 0
+	}
+
+	if config.HalfOpenTimeout < 0 {
+		config.HalfOpenTimeout = 0
+	}
+
+	switch config.HalfOpenTimeoutPolicy {
+	case HalfOpenTimeoutReopen, HalfOpenTimeoutClose:
+	default:
+		config.HalfOpenTimeoutPolicy = HalfOpenTimeoutReopen
+	}
+
+	if config.WarnThreshold < 0 || config.WarnThreshold >= config.FailureThreshold {
+		config.WarnThreshold = 0
+	}
+
+	switch config.TripStrategy {
+	case CountBased, RatioBased:
+	default:
+		config.TripStrategy = CountBased
+	}
+
+	if config.WindowSize <= 0 {
+		config.WindowSize = 20
+	}
+
+	if config.MinRequests <= 0 || config.MinRequests > config.WindowSize {
+		config.MinRequests = config.WindowSize
+	}
+
+	if config.FailureRatio <= 0 || config.FailureRatio > 1 {
+		config.FailureRatio = 0.5
+	}
+
+	if config.TimeoutThreshold < 0 {
+		config.TimeoutThreshold = 0
+	}
+
+	if config.TimeoutWeight <= 0 {
+		config.TimeoutWeight = 1
+	}
+
+	if config.HistogramBucket < 0 {
+		config.HistogramBucket = 0
+	}
+
+	if config.HistogramBucket > 0 && config.HistogramRetention <= 0 {
+		config.HistogramRetention = time.Hour
+	}
+
+	if config.HistogramRetention < config.HistogramBucket {
+		config.HistogramRetention = config.HistogramBucket
+	}
+
+	config.LoadShedStart = min(max(config.LoadShedStart, 0), 1)
+	config.LoadShedMin = min(max(config.LoadShedMin, 0), 1)
+	if config.LoadShedStart > 0 && (config.LoadShedFull <= 0 || config.LoadShedFull > 1) {
+		config.LoadShedFull = 1
+	}
+
+	if config.FlapWindow <= 0 {
+		config.FlapWindow = time.Minute
+	}
+
+	if config.FlapRate < 0 {
+		config.FlapRate = 0
+	}
+
+	if config.Clock == nil {
+		config.Clock = realClock{}
+	}
+
+	config.Labels = maps.Clone(config.Labels)
+
+	return config
 }
 
-// Allow проверяет, разрешено ли выполнение запроса
-func (cb *circuitBreaker) allow() (bool, State) {
+// updateConfig применяет новую конфигурацию, сохраняя состояние, счетчики и источник времени.
+// Если в closed накопленные ошибки уже удовлетворяют новому условию размыкания
+// (например, после снижения FailureThreshold), CB сразу переходит в open.
+// Повышение порога не замыкает уже разомкнутый CB.
+func (cb *CircuitBreaker) updateConfig(config CircuitBreakerConf) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	// Источник времени не меняется после создания CB
+	config.Clock = cb.clock
+	config = withDefaults(config)
+
+	cb.failureThreshold = config.FailureThreshold
+	cb.recoveryTimeout = config.RecoveryTimeout
+	cb.successThreshold = config.SuccessThreshold
+	cb.successCount = min(cb.successCount, cb.successThreshold)
+	cb.halfOpenPrc = config.HalfOpenPrc
+	if config.WindowSize != cb.conf.WindowSize {
+		cb.window = newOutcomeWindow(config.WindowSize)
+	}
+	if config.HistogramBucket != cb.conf.HistogramBucket || config.HistogramRetention != cb.conf.HistogramRetention {
+		cb.hist = nil
+		if config.HistogramBucket > 0 {
+			cb.hist = newHistogram(config.HistogramBucket, config.HistogramRetention, cb.clock.Now())
+		}
+	}
+	// Включение AlwaysOpen размыкает CB сразу, выключение возвращает обычное восстановление
+	forceOpen := config.AlwaysOpen && !cb.conf.AlwaysOpen
+	if cb.conf.AlwaysOpen && !config.AlwaysOpen {
+		cb.forcedOpen.Store(false)
+	}
+	cb.conf = config
+	if forceOpen {
+		cb.forcedOpen.Store(true)
+		if cb.state != stateOpen {
+			cb.setStateLocked(stateOpen, CauseManual)
+		}
+	}
+	cb.syncFastRecoveryLocked()
+	cb.storeHooks(config)
+
+	if cb.state == stateClosed && cb.shouldTripLocked() && !cb.inWarmupLocked() {
+		cb.transitionLocked(stateOpen, CauseTripped)
+	}
+}
+
+// Breaker - интерфейс Circuit Breaker. Позволяет подменять CB в тестах кода,
+// который от него зависит (например, всегда разомкнутой или всегда замкнутой заглушкой).
+type Breaker interface {
+	Allow() (bool, State)
+	Success()
+	Failure()
+	State() State
+	Stats() Stats
+}
+
+var _ Breaker = (*CircuitBreaker)(nil)
+
+// Allow проверяет, разрешено ли выполнение запроса, и возвращает текущее состояние
+func (cb *CircuitBreaker) Allow() (bool, State) {
+	return cb.allow()
+}
+
+// Success отмечает успешное выполнение запроса
+func (cb *CircuitBreaker) Success() {
+	cb.success()
+}
+
+// Failure отмечает неудачное выполнение запроса
+func (cb *CircuitBreaker) Failure() {
+	cb.failure()
+}
+
+// State возвращает текущее состояние
+func (cb *CircuitBreaker) State() State {
+	return cb.curState()
+}
+
+// Stats возвращает снимок статистики
+func (cb *CircuitBreaker) Stats() Stats {
+	return cb.stats()
+}
+
+// ForceState принудительно переводит CB в состояние s (closed, open или half-open),
+// минуя пороги и MinStateDuration; обработчики переходов вызываются как обычно.
+// Предназначен для тестов (см. пакет testutil) и ручного вмешательства.
+func (cb *CircuitBreaker) ForceState(s State) error {
+	if s != stateClosed && s != stateOpen && s != stateHalfOpen {
+		return fmt.Errorf("%w: state %s", ErrInvalidConfig, s)
+	}
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	if cb.state != s {
+		cb.setStateLocked(s, CauseManual)
+	}
+	return nil
+}
+
+// Clone возвращает независимую копию CB: состояние, счетчики, временные метки и
+// конфигурация копируются, мьютекс и буферы у копии собственные. Копия не связана
+// с менеджером (не публикует события) и подходит для моделирования сценариев
+// "что если" на снимке работающего CB. Выполняющиеся запросы (in_flight, пробы и
+// слоты half-open) к копии не относятся; колбэки конфигурации копия вызывает так же,
+// как оригинал.
+func (cb *CircuitBreaker) Clone() *CircuitBreaker {
 	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	c := &CircuitBreaker{
+		state:             cb.state,
+		failureCount:      cb.failureCount,
+		failureScore:      cb.failureScore,
+		closedStreak:      cb.closedStreak,
+		timeoutCount:      cb.timeoutCount,
+		periodSuccesses:   cb.periodSuccesses,
+		periodFailures:    cb.periodFailures,
+		window:            cb.window.clone(),
+		failureThreshold:  cb.failureThreshold,
+		recoveryTimeout:   cb.recoveryTimeout,
+		lastFailureTime:   cb.lastFailureTime,
+		openedAt:          cb.openedAt,
+		incidentStart:     cb.incidentStart,
+		halfOpenAt:        cb.halfOpenAt,
+		failedRecoveries:  cb.failedRecoveries,
+		openFailures:      cb.openFailures,
+		backoffLevel:      cb.backoffLevel,
+		stateSince:        cb.stateSince,
+		deferred:          cb.deferred,
+		deferredCause:     cb.deferredCause,
+		hasDeferred:       cb.hasDeferred,
+		relaxMult:         cb.relaxMult,
+		relaxUntil:        cb.relaxUntil,
+		totalSuccesses:    cb.totalSuccesses,
+		probationLeft:     cb.probationLeft,
+		totalFailures:     cb.totalFailures,
+		successCount:      cb.successCount,
+		halfOpenStreak:    cb.halfOpenStreak,
+		successThreshold:  cb.successThreshold,
+		name:              cb.name,
+		halfOpenPrc:       cb.halfOpenPrc,
+		transaction:       cb.transaction,
+		flaps:             slices.Clone(cb.flaps),
+		flapping:          cb.flapping,
+		clock:             cb.clock,
+		createdAt:         cb.createdAt,
+		softFailures:      cb.softFailures,
+		rampPrc:           cb.rampPrc,
+		halfOpenSuccesses: cb.halfOpenSuccesses,
+		halfOpenFailures:  cb.halfOpenFailures,
+		failuresByCat:     maps.Clone(cb.failuresByCat),
+		probeSources:      maps.Clone(cb.probeSources),
+		probeBudget:       cb.probeBudget,
+		limiter:           tokenBucket{noIdleCredit: true},
+		cleanSince:        cb.cleanSince,
+		warned:            cb.warned,
+		conf:              cb.configLocked(),
+	}
+	if cb.hist != nil {
+		h := *cb.hist
+		h.buckets = slices.Clone(cb.hist.buckets)
+		c.hist = &h
+	}
+	cb.bucket.mu.Lock()
+	c.bucket.tokens, c.bucket.lastRefill = cb.bucket.tokens, cb.bucket.lastRefill
+	cb.bucket.mu.Unlock()
+	cb.limiter.mu.Lock()
+	c.limiter.tokens, c.limiter.lastRefill = cb.limiter.tokens, cb.limiter.lastRefill
+	cb.limiter.mu.Unlock()
+
+	c.fastState.Store(cb.fastState.Load())
+	c.fastOpenedAt.Store(cb.fastOpenedAt.Load())
+	c.fastRecovery.Store(cb.fastRecovery.Load())
+	c.fastFailures.Store(cb.fastFailures.Load())
+	c.fastSuccesses.Store(cb.fastSuccesses.Load())
+	c.fastTransitions.Store(cb.fastTransitions.Load())
+	c.halfOpenSeq.Store(cb.halfOpenSeq.Load())
+	// Пропущенная оригиналом проба и занятые им слоты half-open к копии не относятся
+	if probe := cb.probe.Load(); probe != probePending {
+		c.probe.Store(probe)
+	}
+	c.draining.Store(cb.draining.Load())
+	c.forcedOpen.Store(cb.forcedOpen.Load())
+	c.generation.Store(cb.generation.Load())
+	c.staleResults.Store(cb.staleResults.Load())
+	c.rejected.Store(cb.rejected.Load())
+	c.halfOpenDenied.Store(cb.halfOpenDenied.Load())
+	c.storeHooks(c.conf)
+	return c
+}
+
+// FastStats возвращает сокращенную статистику CB (state, failure_count, success_count,
+// transaction, total_rejected, in_flight), не захватывая мьютекс CB. Поля читаются
+// атомарно по отдельности, поэтому могут быть слегка несогласованы между собой.
+// Подходит для дашбордов с частым опросом; для точного снимка используйте Stats.
+func (cb *CircuitBreaker) FastStats() Stats {
+	return Stats{
+		"name":           cb.name,
+		"state":          State(cb.fastState.Load()).String(),
+		"failure_count":  int(cb.fastFailures.Load()),
+		"success_count":  int(cb.fastSuccesses.Load()),
+		"transaction":    int(cb.fastTransitions.Load()),
+		"total_rejected": cb.rejected.Load(),
+		"in_flight":      cb.inFlight.Load(),
+	}
+}
+
+// StatsString возвращает статистику CB в виде строки "key=value" с ключами,
+// упорядоченными по алфавиту, для логов и отладки. Конфигурация (ключ config)
+// не выводится: она содержит функции, представление которых нестабильно.
+func (cb *CircuitBreaker) StatsString() string {
+	stats := cb.stats()
+	delete(stats, "config")
+
+	keys := slices.Sorted(maps.Keys(stats))
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%v", k, stats[k])
+	}
+	return b.String()
+}
+
+// Allow проверяет, разрешено ли выполнение запроса
+func (cb *CircuitBreaker) allow() (bool, State) {
+	allowed, state, _ := cb.allowDetailed(PriorityNormal)
+	return allowed, state
+}
+
+// allowDetailed решает, пропустить ли запрос, и сообщает причину решения
+func (cb *CircuitBreaker) allowDetailed(priority int) (bool, State, DecisionReason) {
+	allowed, state, reason, _ := cb.admit(priority, false)
+	return allowed, state, reason
+}
+
+// admit решает, пропустить ли запрос. При reserve пропущенный запрос занимает слот
+// in_flight, который освобождается при закрытии билета (см. Acquire). Для пропущенного
+// запроса возвращается период состояния, прочитанный под той же блокировкой, что и решение.
+func (cb *CircuitBreaker) admit(priority int, reserve bool) (bool, State, DecisionReason, uint64) {
+	var (
+		allowed bool
+		state   State
+		reason  = ReasonBulkheadFull
+		gen     uint64
+	)
+	if cb.takeSlot(reserve) {
+		allowed, state, reason, gen = cb.decide(priority)
+		if !allowed && reserve {
+			cb.inFlight.Add(-1)
+		}
+	} else {
+		state = cb.curState()
+	}
+	if !allowed {
+		cb.reject(reason)
+	}
+	if onDecision := cb.onDecision.Load(); onDecision != nil {
+		(*onDecision)(cb.name, allowed, state, reason)
+	}
+	return allowed, state, reason, gen
+}
+
+// gate отклоняет запрос по причине уровня менеджера (например, разомкнутая группа), не расходуя
+// пробы, слоты и токены CB. Переходы по времени (отложенный переход, open -> half-open)
+// при этом выполняются, чтобы заблокированный CB мог восстановиться.
+func (cb *CircuitBreaker) gate(reason DecisionReason) {
+	cb.mu.Lock()
+	cb.applyDeferredLocked()
+	if cb.state == stateOpen && cb.recoveryDueLocked() {
+		cb.setStateLocked(stateHalfOpen, CauseRecoveryTimeout)
+	}
 	state := cb.state
-	lastFailureTime := cb.lastFailureTime
-	recoveryTimeout := cb.recoveryTimeout
-	halfOpenPrc := cb.halfOpenPrc
-	//name := cb.name
-	cb.mu.RUnlock()
+	cb.unlock()
+
+	cb.reject(reason)
+	if onDecision := cb.onDecision.Load(); onDecision != nil {
+		(*onDecision)(cb.name, false, state, reason)
+	}
+}
+
+// takeSlot проверяет лимит MaxConcurrent; при reserve атомарно занимает слот in_flight
+func (cb *CircuitBreaker) takeSlot(reserve bool) bool {
+	limit := cb.maxConcurrent.Load()
+	if !reserve {
+		return limit == 0 || cb.inFlight.Load() < limit
+	}
+	for {
+		n := cb.inFlight.Load()
+		if limit > 0 && n >= limit {
+			return false
+		}
+		if cb.inFlight.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// decide принимает решение о пропуске запроса с приоритетом priority
+func (cb *CircuitBreaker) decide(priority int) (bool, State, DecisionReason, uint64) {
+	if cb.draining.Load() {
+		return false, cb.curState(), ReasonDraining, 0
+	}
+
+	// Быстрый путь без блокировок: CB разомкнут и таймаут восстановления еще не истек
+	if State(cb.fastState.Load()) == stateOpen {
+		recovery := cb.fastRecovery.Load()
+		if cb.clock.Now().UnixNano()-cb.fastOpenedAt.Load() < recovery {
+			if recovery == math.MaxInt64 {
+				if cb.forcedOpen.Load() {
+					return false, stateOpen, ReasonForcedOpen, 0
+				}
+				return false, stateOpen, ReasonRecoveryExhausted, 0
+			}
+			return false, stateOpen, ReasonOpen, 0
+		}
+	}
+
+	cb.mu.RLock()
+	state, gen := cb.state, cb.generation.Load()
+
+	// Отложенный переход выполняется под блокировкой на запись
+	if cb.deferredDueLocked() {
+		cb.mu.RUnlock()
+		cb.mu.Lock()
+		defer cb.unlock()
+		cb.applyDeferredLocked()
+		return cb.decideLocked(priority)
+	}
 
 	switch state {
 	case stateClosed:
-		return true, state
+		allowed, reason := cb.admitClosedLocked()
+		cb.mu.RUnlock()
+		return allowed, state, reason, gen
 	case stateHalfOpen:
-		return rand.IntN(100) < halfOpenPrc, state
+		if cb.halfOpenExpiredLocked() {
+			cb.mu.RUnlock()
+			return cb.expireHalfOpen(priority)
+		}
+		// Отбор в half-open использует атомарные счетчики и не требует блокировки на запись
+		allowed, reason := cb.admitHalfOpen(priority)
+		cb.mu.RUnlock()
+		return allowed, state, reason, gen
 	case stateOpen:
-		if time.Since(lastFailureTime) >= recoveryTimeout {
+		// Таймаут восстановления отсчитывается от момента перехода в open,
+		// поэтому ошибки, сообщаемые в open, не откладывают восстановление
+		recovered, reason := cb.recoveryDueLocked(), cb.openReasonLocked()
+		cb.mu.RUnlock()
+		if recovered {
 			cb.mu.Lock()
-			defer cb.mu.Unlock()
+			defer cb.unlock()
 			// Повторная проверка, чтобы избежать гонки
-			if cb.state == stateOpen && time.Since(cb.lastFailureTime) >= cb.recoveryTimeout {
-				cb.state = stateHalfOpen
+			if cb.state == stateOpen && cb.recoveryDueLocked() {
+				cb.setStateLocked(stateHalfOpen, CauseRecoveryTimeout)
 			}
-
-			// В half-open состоянии пропускаем только часть запросов
-			return rand.IntN(100) < halfOpenPrc, stateHalfOpen
+			return cb.decideLocked(priority)
 		}
-		return false, state
+		return false, state, reason, 0
 	default:
-		return false, state
+		cb.mu.RUnlock()
+		return false, state, ReasonOpen, 0
 	}
 }
 
-// Success отмечает успешное выполнение запроса
-func (cb *circuitBreaker) success() {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
+// decideLocked решает судьбу запроса в текущем состоянии без переходов. Вызывается под cb.mu.
+func (cb *CircuitBreaker) decideLocked(priority int) (bool, State, DecisionReason, uint64) {
+	gen := cb.generation.Load()
 	switch cb.state {
 	case stateClosed:
-		// Декрементируем счетчик ошибок при успешных запросах
-		if cb.failureCount > 0 {
-			cb.failureCount--
-		}
+		allowed, reason := cb.admitClosedLocked()
+		return allowed, stateClosed, reason, gen
 	case stateHalfOpen:
-		// В half-open состоянии считаем успешные запросы
-		cb.successCount++
-		// Если достигнут порог успешных запросов, переходим в closed
-		if cb.successCount >= cb.successThreshold {
-			cb.state = stateClosed
-			cb.failureCount = 0
-			cb.successCount = 0
-			cb.transaction++
-		}
+		// В half-open состоянии пропускаем только часть запросов
+		allowed, reason := cb.admitHalfOpen(priority)
+		return allowed, stateHalfOpen, reason, gen
 	}
+	return false, cb.state, cb.openReasonLocked(), 0
 }
 
-// Failure отмечает неудачное выполнение запроса
-func (cb *circuitBreaker) failure() {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
+// admitClosedLocked решает, пропустить ли запрос в closed (сброс нагрузки и MaxRPS).
+// Вызывается под cb.mu.
+func (cb *CircuitBreaker) admitClosedLocked() (bool, DecisionReason) {
+	if cb.shedLocked() {
+		return false, ReasonLoadShed
+	}
+	if !cb.takeRateLocked() {
+		return false, ReasonRateLimited
+	}
+	return true, ReasonClosed
+}
 
-	switch cb.state {
-	case stateClosed:
-		cb.failureCount++
-		// Если достигнут порог ошибок, переходим в open
-		if cb.failureCount >= cb.failureThreshold {
-			cb.state = stateOpen
-			cb.lastFailureTime = time.Now()
-			//Инициализируем счетчики переходов состояний
-			cb.transaction++
-		}
-	case stateHalfOpen:
-		// В half-open состоянии любая ошибка возвращает в open
-		cb.state = stateOpen
-		cb.lastFailureTime = time.Now()
-		cb.successCount = 0
+// takeRateLocked расходует токен ограничения MaxRPS; false, если лимит исчерпан.
+// Вызывается под cb.mu.
+func (cb *CircuitBreaker) takeRateLocked() bool {
+	n := cb.conf.MaxRPS
+	if n <= 0 {
+		return true
 	}
+	return cb.limiter.take(cb.clock.Now(), n, time.Second/time.Duration(n))
 }
 
-// State возвращает текущее состояние
-func (cb *circuitBreaker) curState() State {
-	cb.mu.RLock()
-	defer cb.mu.RUnlock()
-	return cb.state
+// shedLocked решает, отклонить ли запрос в closed при адаптивном сбросе нагрузки.
+// Вызывается под cb.mu.
+func (cb *CircuitBreaker) shedLocked() bool {
+	if cb.conf.LoadShedStart <= 0 || cb.window.count < cb.conf.MinRequests {
+		return false
+	}
+	fraction := cb.shedFraction(cb.window.ratio())
+	return fraction > 0 && rand.Float64() < fraction
+}
+
+// maxShedFraction - предельная доля отклоняемых запросов при сбросе нагрузки. Часть запросов
+// всегда пропускается: окно ошибок не стареет по времени и обновляется только их результатами,
+// поэтому полный отказ навсегда оставил бы CB в closed без единого запроса к серверу.
+const maxShedFraction = 0.95
+
+// shedFraction возвращает долю отклоняемых запросов при доле ошибок ratio
+func (cb *CircuitBreaker) shedFraction(ratio float64) float64 {
+	start, full := cb.conf.LoadShedStart, cb.conf.LoadShedFull
+	if ratio < start {
+		return 0
+	}
+	if ratio >= full {
+		return maxShedFraction
+	}
+	low := min(cb.conf.LoadShedMin, maxShedFraction)
+	return low + (maxShedFraction-low)*(ratio-start)/(full-start)
 }
 
+// openReasonLocked возвращает причину отказа разомкнутого CB. Вызывается под cb.mu.
+func (cb *CircuitBreaker) openReasonLocked() DecisionReason {
+	if cb.forcedOpen.Load() {
+		return ReasonForcedOpen
+	}
+	if !cb.autoRecoveryLocked() {
+		return ReasonRecoveryExhausted
+	}
+	return ReasonOpen
+}
+
+// reject учитывает отклоненный запрос и вызывает OnReject. Вызывается вне cb.mu.
+func (cb *CircuitBreaker) reject(reason DecisionReason) {
+	cb.rejected.Add(1)
+	switch reason {
+	case ReasonHalfOpenDenied, ReasonProbeInFlight, ReasonHalfOpenBusy:
+		cb.halfOpenDenied.Add(1)
+	}
+	if onReject := cb.onReject.Load(); onReject != nil {
+		(*onReject)(cb.name, reason)
+	}
+}
+
+// Типы OnReject и OnDecision для хранения в atomic.Pointer
+type (
+	rejectFunc   = func(name string, reason DecisionReason)
+	decisionFunc = func(name string, allowed bool, state State, reason DecisionReason)
+)
+
+// storeHooks публикует OnReject и OnDecision для чтения без блокировки
+func (cb *CircuitBreaker) storeHooks(config CircuitBreakerConf) {
+	cb.onReject.Store(nil)
+	if fn := config.OnReject; fn != nil {
+		cb.onReject.Store(&fn)
+	}
+	cb.onDecision.Store(nil)
+	if fn := config.OnDecision; fn != nil {
+		cb.onDecision.Store(&fn)
+	}
+	cb.maxConcurrent.Store(int64(max(config.MaxConcurrent, 0)))
+}
+
+// admitHalfOpen решает, пропустить ли запрос в half-open, согласно HalfOpenStrategy.
+// Вызывается под cb.mu (достаточно блокировки на чтение).
+// Приоритет учитывается только при ограничении HalfOpenMaxConcurrent.
+func (cb *CircuitBreaker) admitHalfOpen(priority int) (bool, DecisionReason) {
+	halfOpenPrc := cb.halfOpenPrc
+	if cb.conf.HalfOpenSingleProbe {
+		switch cb.probe.Load() {
+		case probeAwaiting:
+			// Пропускаем ровно одну первую пробу
+			if cb.probe.CompareAndSwap(probeAwaiting, probePending) {
+				if !cb.takeRateLocked() {
+					// Проба не состоялась: ее сможет занять следующий запрос
+					cb.probe.Store(probeAwaiting)
+					return false, ReasonRateLimited
+				}
+				return true, ReasonHalfOpenAdmitted
+			}
+			return false, ReasonProbeInFlight
+		case probePending:
+			return false, ReasonProbeInFlight
+		}
+	}
+
+	// Сначала занимаем слот, чтобы не расходовать квоту стратегии на запрос сверх лимита
+	limited := cb.conf.HalfOpenMaxConcurrent > 0
+	if limited && !cb.acquireHalfOpenSlot(priority) {
+		return false, ReasonHalfOpenBusy
+	}
+
+	var allowed bool
+	switch cb.conf.HalfOpenStrategy {
+	case HalfOpenDeterministic:
+		n := uint64(100 / halfOpenPrc)
+		allowed = (cb.halfOpenSeq.Add(1)-1)%n == 0
+	case HalfOpenTokenBucket:
+		allowed = cb.bucket.take(cb.clock.Now(), cb.conf.HalfOpenBucketSize, cb.conf.HalfOpenRefillInterval)
+	case HalfOpenRampUp:
+		allowed = rand.IntN(100) < cb.rampPrc
+	default:
+		allowed = rand.IntN(100) < halfOpenPrc
+	}
+	reason := ReasonHalfOpenDenied
+	if allowed {
+		if cb.takeRateLocked() {
+			return true, ReasonHalfOpenAdmitted
+		}
+		reason = ReasonRateLimited
+	}
+	if limited {
+		cb.releaseHalfOpenSlot()
+	}
+	return false, reason
+}
+
+// acquireHalfOpenSlot занимает слот half-open в пределах HalfOpenMaxConcurrent.
+// Запросам с отрицательным приоритетом доступна только половина слотов (но не меньше
+// одного, иначе при лимите 1 фоновые запросы не получили бы пробу никогда),
+// остальные зарезервированы для более важных запросов.
+func (cb *CircuitBreaker) acquireHalfOpenSlot(priority int) bool {
+	limit := int64(cb.halfOpenLimitLocked())
+	if priority < PriorityNormal {
+		limit = max(1, limit/2)
+	}
+	for {
+		active := cb.halfOpenActive.Load()
+		if active >= limit {
+			return false
+		}
+		if cb.halfOpenActive.CompareAndSwap(active, active+1) {
+			return true
+		}
+	}
+}
+
+// halfOpenLimitLocked возвращает текущий лимит одновременных проб half-open
+// с учетом адаптивного бюджета. Вызывается под cb.mu.
+func (cb *CircuitBreaker) halfOpenLimitLocked() int {
+	base, limitCap := cb.conf.HalfOpenMaxConcurrent, cb.conf.HalfOpenMaxConcurrentCap
+	if base <= 0 || limitCap <= base || cb.probeBudget <= 0 {
+		return base
+	}
+	return min(cb.probeBudget, limitCap)
+}
+
+// adjustProbeBudgetLocked изменяет адаптивный бюджет проб на delta в пределах
+// 1..HalfOpenMaxConcurrentCap. Вызывается под cb.mu.
+func (cb *CircuitBreaker) adjustProbeBudgetLocked(delta int) {
+	if base := cb.conf.HalfOpenMaxConcurrent; base <= 0 || cb.conf.HalfOpenMaxConcurrentCap <= base {
+		return
+	}
+	cb.probeBudget = min(max(cb.halfOpenLimitLocked()+delta, 1), cb.conf.HalfOpenMaxConcurrentCap)
+}
+
+// releaseHalfOpenSlot освобождает слот half-open, занятый acquireHalfOpenSlot
+func (cb *CircuitBreaker) releaseHalfOpenSlot() {
+	for {
+		active := cb.halfOpenActive.Load()
+		if active <= 0 || cb.halfOpenActive.CompareAndSwap(active, active-1) {
+			return
+		}
+	}
+}
+
+// outcomeWindow - скользящее окно результатов последних запросов (кольцевой буфер)
+type outcomeWindow struct {
+	outcomes []bool // true - ошибка
+	pos      int
+	count    int
+	failures int
+}
+
+func newOutcomeWindow(size int) outcomeWindow {
+	return outcomeWindow{outcomes: make([]bool, size)}
+}
+
+// add добавляет результат, вытесняя самый старый при заполненном окне
+func (w *outcomeWindow) add(failure bool) {
+	if w.count == len(w.outcomes) {
+		if w.outcomes[w.pos] {
+			w.failures--
+		}
+	} else {
+		w.count++
+	}
+	w.outcomes[w.pos] = failure
+	if failure {
+		w.failures++
+	}
+	w.pos = (w.pos + 1) % len(w.outcomes)
+}
+
+// ratio возвращает долю ошибок в окне
+func (w *outcomeWindow) ratio() float64 {
+	if w.count == 0 {
+		return 0
+	}
+	return float64(w.failures) / float64(w.count)
+}
+
+// clone возвращает копию окна с собственным буфером
+func (w outcomeWindow) clone() outcomeWindow {
+	w.outcomes = slices.Clone(w.outcomes)
+	return w
+}
+
+// reset очищает окно
+func (w *outcomeWindow) reset() {
+	clear(w.outcomes)
+	w.pos, w.count, w.failures = 0, 0, 0
+}
+
+// Состояния одиночной первой пробы в half-open (HalfOpenSingleProbe)
+const (
+	probeAwaiting uint32 = iota // проба еще не пропущена
+	probePending                // проба пропущена, результат не получен
+	probeDone                   // результат пробы получен
+)
+
+// tokenBucket - ведро токенов для отбора запросов в half-open и ограничения MaxRPS.
+// Токены восполняются по сетке интервалов от момента reset; при noIdleCredit полное
+// ведро не накапливает время и сетка сдвигается к моменту последнего восполнения.
+type tokenBucket struct {
+	mu           sync.Mutex
+	tokens       int
+	lastRefill   time.Time
+	noIdleCredit bool
+}
+
+// reset наполняет ведро до емкости size
+func (b *tokenBucket) reset(now time.Time, size int) {
+	b.mu.Lock()
+	b.tokens = size
+	b.lastRefill = now
+	b.mu.Unlock()
+}
+
+// take восполняет токены за прошедшее время и пытается израсходовать один
+func (b *tokenBucket) take(now time.Time, size int, interval time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.lastRefill); elapsed >= interval {
+		n := int(elapsed / interval)
+		b.tokens = min(size, b.tokens+n)
+		b.lastRefill = b.lastRefill.Add(time.Duration(n) * interval)
+		// Полное ведро не накапливает время (в том числе после долгого простоя)
+		if b.noIdleCredit && b.tokens == size {
+			b.lastRefill = now
+		}
+	}
+	if b.tokens == 0 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Success отмечает успешное выполнение запроса
+func (cb *CircuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.unlock()
+	cb.successLocked()
+}
+
+// successLocked учитывает успешный запрос без указания источника. Вызывается под cb.mu.
+func (cb *CircuitBreaker) successLocked() {
+	cb.successFromLocked("")
+}
+
+// successFromLocked учитывает успешный запрос от источника source. Вызывается под cb.mu.
+func (cb *CircuitBreaker) successFromLocked(source string) {
+	cb.applyDeferredLocked()
+	cb.totalSuccesses++
+	cb.periodSuccesses++
+	if cb.hist != nil {
+		cb.hist.add(cb.clock.Now(), false)
+	}
+
+	switch cb.state {
+	case stateClosed:
+		cb.window.add(false)
+		if d := cb.conf.DecrementOnSuccess; d != nil && !*d {
+			// Учитываются только ошибки подряд: любой успех их обнуляет
+			cb.failureCount = 0
+			cb.failureScore = 0
+			cb.timeoutCount = 0
+		}
+		// Декрементируем счетчик ошибок при успешных запросах
+		if cb.failureCount > 0 {
+			cb.failureCount--
+		}
+		cb.failureScore = max(0, cb.failureScore-1)
+		if cb.timeoutCount > 0 {
+			cb.timeoutCount--
+		}
+		if cb.probationLeft > 0 {
+			cb.probationLeft--
+		}
+		// После серии успехов подряд забываем старые ошибки целиком
+		cb.closedStreak++
+		if n := cb.conf.ClosedRecoverySuccesses; n > 0 && cb.closedStreak >= n {
+			cb.failureCount = 0
+			cb.failureScore = 0
+			cb.timeoutCount = 0
+			cb.closedStreak = 0
+		}
+		// Снимаем предупреждение, когда счетчик опустился ниже порога
+		if cb.warned && cb.failureCount < cb.conf.WarnThreshold {
+			cb.warned = false
+		}
+	case stateHalfOpen:
+		// Первая проба прошла, дальше работает обычная логика half-open
+		cb.probe.CompareAndSwap(probePending, probeDone)
+		cb.releaseHalfOpenSlot()
+		cb.halfOpenSuccesses++
+		if !cb.countProbeSourceLocked(source) {
+			// Повторный успех того же источника не приближает замыкание
+			return
+		}
+		// В half-open состоянии считаем успешные запросы; счетчик не превышает порог,
+		// даже если замыкание откладывается (HalfOpenStabilizeDuration, ramp_up)
+		cb.successCount = min(cb.successCount+1, cb.successThreshold)
+		cb.halfOpenStreak++
+		if cb.conf.BackoffResetOnProbe {
+			cb.backoffLevel = 0
+		}
+		cb.rampPrc = min(100, cb.rampPrc+cb.conf.HalfOpenRampStep)
+		cb.adjustProbeBudgetLocked(1)
+		// Если достигнут порог успешных запросов и CB достаточно долго работает без ошибок, переходим в closed
+		if cb.recoveryPolicyLocked().ShouldClose(cb.recoverySnapshotLocked()) && cb.stableLocked() && cb.rampedLocked() {
+			cb.transitionLocked(stateClosed, CauseRecovered)
+		}
+	}
+}
+
+// countProbeSourceLocked сообщает, засчитывается ли успех источника source в half-open
+// (при HalfOpenDistinctSources - только первый от каждого источника). Вызывается под cb.mu.
+func (cb *CircuitBreaker) countProbeSourceLocked(source string) bool {
+	if !cb.conf.HalfOpenDistinctSources {
+		return true
+	}
+	if _, seen := cb.probeSources[source]; seen {
+		return false
+	}
+	if cb.probeSources == nil {
+		cb.probeSources = make(map[string]struct{})
+	}
+	cb.probeSources[source] = struct{}{}
+	return true
+}
+
+// result отмечает результат запроса по ошибке с учетом IsFailure
+func (cb *CircuitBreaker) result(err error) {
+	if cb.isFailure(err) {
+		cb.failure()
+	} else {
+		cb.success()
+	}
+}
+
+// isFailure классифицирует ошибку с учетом IsFailure
+func (cb *CircuitBreaker) isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if cb.conf.IsFailure != nil {
+		return cb.conf.IsFailure(err)
+	}
+	return true
+}
+
+const (
+	// FailureUnspecified - категория неудач, сообщенных без указания причины
+	FailureUnspecified = "unspecified"
+	// FailureTimeout - категория неудач, сообщенных через ReportTimeout
+	FailureTimeout = "timeout"
+)
+
+// Failure отмечает неудачное выполнение запроса
+func (cb *CircuitBreaker) failure() {
+	cb.failureWeighted(1)
+}
+
+// resolve учитывает результат запроса, допущенного в состоянии from в период gen.
+// Если с тех пор состояние менялось, а запрос был пробой half-open (или CB сейчас в half-open),
+// результат отбрасывается: запоздавшая проба не должна влиять на решение в новом периоде.
+// Успех засчитывается от источника source (см. HalfOpenDistinctSources).
+func (cb *CircuitBreaker) resolve(gen uint64, from State, failed bool, source string) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	if cb.generation.Load() != gen && (from == stateHalfOpen || cb.state == stateHalfOpen) {
+		cb.staleResults.Add(1)
+		return
+	}
+	if failed {
+		cb.reportLocked(1, FailureUnspecified)
+	} else {
+		cb.successFromLocked(source)
+	}
+}
+
+// timeout отмечает таймаут запроса (см. TimeoutThreshold и TimeoutWeight)
+func (cb *CircuitBreaker) timeout() {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	if cb.state == stateClosed {
+		cb.timeoutCount++
+	}
+	cb.reportLocked(cb.conf.TimeoutWeight, FailureTimeout)
+}
+
+// failureCategory отмечает неудачу с категорией category (пустая - FailureUnspecified)
+func (cb *CircuitBreaker) failureCategory(category string) {
+	cb.report(1, category)
+}
+
+// failureWeighted отмечает неудачу с весом weight: в closed (стратегия count) CB размыкается,
+// когда накопленный взвешенный счет ошибок достигает FailureThreshold.
+// Вес <= 0 считается равным 1.
+func (cb *CircuitBreaker) failureWeighted(weight float64) {
+	cb.report(weight, FailureUnspecified)
+}
+
+// report учитывает неудачу с весом weight и категорией category
+func (cb *CircuitBreaker) report(weight float64, category string) {
+	if weight <= 0 {
+		weight = 1
+	}
+	if category == "" {
+		category = FailureUnspecified
+	}
+
+	cb.mu.Lock()
+	defer cb.unlock()
+	cb.reportLocked(weight, category)
+}
+
+// reportLocked учитывает неудачу. Вызывается под cb.mu.
+func (cb *CircuitBreaker) reportLocked(weight float64, category string) {
+	cb.applyDeferredLocked()
+	if cb.failuresByCat == nil {
+		cb.failuresByCat = make(map[string]uint64)
+	}
+	cb.failuresByCat[category]++
+	cb.totalFailures++
+	cb.periodFailures++
+	if cb.hist != nil {
+		cb.hist.add(cb.clock.Now(), true)
+	}
+	cb.closedStreak = 0
+
+	switch cb.state {
+	case stateClosed:
+		cb.failureCount++
+		cb.failureScore += weight
+		cb.window.add(true)
+		// Предупреждаем один раз при пересечении порога снизу вверх
+		if cb.conf.WarnThreshold > 0 && !cb.warned && cb.failureCount >= cb.conf.WarnThreshold {
+			cb.warned = true
+			if onWarn := cb.conf.OnWarn; onWarn != nil {
+				name, count := cb.name, cb.failureCount
+				cb.afterUnlock(func() { onWarn(name, count) })
+			}
+		}
+		// Если достигнут порог ошибок, переходим в open (но не во время прогрева)
+		if cb.shouldTripLocked() && !cb.inWarmupLocked() {
+			cb.transitionLocked(stateOpen, CauseTripped)
+		}
+	case stateHalfOpen:
+		// В мягком режиме ошибка сбрасывает серию успехов, пока не превышен допуск
+		// (ошибка одиночной первой пробы всегда возвращает в open)
+		cb.halfOpenFailures++
+		cb.releaseHalfOpenSlot()
+		cb.softFailures++
+		firstProbe := cb.probe.Load() == probePending
+		if !firstProbe && cb.softFailures <= cb.conf.HalfOpenFailureTolerance {
+			cb.hasDeferred = false
+			cb.successCount = 0
+			cb.halfOpenStreak = 0
+			cb.probeSources = nil
+			cb.rampPrc = cb.halfOpenPrc
+			cb.cleanSince = cb.clock.Now()
+			cb.adjustProbeBudgetLocked(-1)
+			return
+		}
+		// В жестком режиме любая ошибка возвращает в open
+		cb.transitionLocked(stateOpen, CauseProbeFailed)
+	case stateOpen:
+		// Запоминаем ошибку, но не сдвигаем момент перехода в open
+		cb.lastFailureTime = cb.clock.Now()
+		if cb.conf.CountOpenFailures {
+			cb.openFailures++
+		}
+	}
+}
+
+// shouldTripLocked проверяет условие размыкания: TimeoutThreshold, затем TripPolicy
+// (по умолчанию - политика, соответствующая TripStrategy). Вызывается под cb.mu.
+func (cb *CircuitBreaker) shouldTripLocked() bool {
+	if t := cb.conf.TimeoutThreshold; t > 0 && cb.timeoutCount >= t {
+		return true
+	}
+	return cb.tripPolicyLocked().ShouldTrip(cb.snapshotLocked())
+}
+
+// tripPolicyLocked возвращает действующую политику размыкания. Вызывается под cb.mu.
+func (cb *CircuitBreaker) tripPolicyLocked() TripPolicy {
+	if p := cb.conf.TripPolicy; p != nil {
+		return p
+	}
+	if cb.conf.TripStrategy == RatioBased {
+		return RatioTripPolicy{Ratio: cb.conf.FailureRatio, MinRequests: cb.conf.MinRequests}
+	}
+	return CountTripPolicy{}
+}
+
+// snapshotLocked собирает снимок счетчиков для TripPolicy. Вызывается под cb.mu.
+func (cb *CircuitBreaker) snapshotLocked() BreakerSnapshot {
+	return BreakerSnapshot{
+		Name:           cb.name,
+		FailureCount:   cb.failureCount,
+		FailureScore:   cb.failureScore,
+		TimeoutCount:   cb.timeoutCount,
+		Threshold:      cb.effectiveThresholdLocked(),
+		WindowRequests: cb.window.count,
+		WindowFailures: cb.window.failures,
+		Now:            cb.clock.Now(),
+	}
+}
+
+// effectiveThresholdLocked возвращает порог ошибок с учетом испытательного срока, RelaxFor и LoadSignal.
+// Вызывается под cb.mu.
+//
+// Эвристика: нагрузка load (доля от емкости) ограничивается диапазоном [0, 1], порог
+// умножается на 2 - 1.5*load. При нулевой нагрузке порог удваивается (редкие ошибки на
+// малом трафике не размыкают CB), при полной - снижается вдвое (CB быстрее сбрасывает
+// нагрузку с перегруженного сервера). Порог не бывает меньше 1.
+func (cb *CircuitBreaker) effectiveThresholdLocked() float64 {
+	threshold := float64(cb.failureThreshold)
+	if cb.probationLeft > 0 {
+		threshold = float64(min(cb.failureThreshold, cb.conf.ProbationFailureThreshold))
+	}
+	if cb.relaxMult > 0 && cb.clock.Now().Before(cb.relaxUntil) {
+		threshold *= cb.relaxMult
+	}
+	if cb.conf.LoadSignal == nil {
+		return threshold
+	}
+	load := min(max(cb.conf.LoadSignal(), 0), 1)
+	return max(1, threshold*(2-1.5*load))
+}
+
+// transitionLocked выполняет переход, вызванный результатами запросов. Если с момента
+// предыдущего перехода не прошло MinStateDuration, переход откладывается и выполняется
+// при первом обращении к CB после истечения этого срока. Вызывается под cb.mu.
+func (cb *CircuitBreaker) transitionLocked(to State, cause TransitionCause) {
+	if d := cb.conf.MinStateDuration; d > 0 && cb.clock.Now().Sub(cb.stateSince) < d {
+		cb.deferred, cb.deferredCause, cb.hasDeferred = to, cause, true
+		return
+	}
+	// Неудачная проба в half-open - неудачная попытка восстановления
+	if cb.state == stateHalfOpen && to == stateOpen {
+		cb.failedRecoveries++
+		cb.backoffLevel++
+	}
+	cb.setStateLocked(to, cause)
+}
+
+// deferredDueLocked проверяет, пора ли выполнить отложенный переход. Вызывается под cb.mu.
+func (cb *CircuitBreaker) deferredDueLocked() bool {
+	return cb.hasDeferred && cb.clock.Now().Sub(cb.stateSince) >= cb.conf.MinStateDuration
+}
+
+// applyDeferredLocked выполняет отложенный переход, если срок MinStateDuration истек.
+// Вызывается под cb.mu.
+func (cb *CircuitBreaker) applyDeferredLocked() {
+	if cb.deferredDueLocked() {
+		cb.hasDeferred = false
+		cb.transitionLocked(cb.deferred, cb.deferredCause)
+	}
+}
+
+// setStateLocked переводит CB в состояние to по причине cause и сбрасывает счетчики
+// текущего периода. Вызывается под cb.mu.
+func (cb *CircuitBreaker) setStateLocked(to State, cause TransitionCause) {
+	from := cb.state
+	cb.state = to
+	cb.stateSince = cb.clock.Now()
+	cb.hasDeferred = false
+	cb.generation.Add(1)
+	defer cb.fastState.Store(uint32(to))
+	cb.successCount = 0
+	cb.halfOpenStreak = 0
+	cb.softFailures = 0
+	cb.halfOpenSeq.Store(0)
+
+	cb.periodSuccesses, cb.periodFailures = 0, 0
+
+	// Счетчик неудачных попыток восстановления увеличивается при ошибке в half-open
+	if to == stateClosed {
+		cb.failedRecoveries = 0
+		cb.backoffLevel = 0
+	}
+	// Принудительное размыкание снимается при любом ручном выходе из open
+	if to != stateOpen {
+		cb.forcedOpen.Store(false)
+	}
+	cb.syncFastRecoveryLocked()
+
+	switch to {
+	case stateHalfOpen:
+		cb.cleanSince = cb.clock.Now()
+		cb.halfOpenAt = cb.cleanSince
+		cb.bucket.reset(cb.cleanSince, cb.conf.HalfOpenBucketSize)
+		cb.probe.Store(probeAwaiting)
+		cb.halfOpenActive.Store(0)
+		cb.rampPrc = cb.halfOpenPrc
+		cb.probeSources = nil
+		cb.probeBudget = 0
+	case stateOpen:
+		cb.openFailures = 0
+		cb.openedAt = cb.clock.Now()
+		cb.lastFailureTime = cb.openedAt
+		cb.fastOpenedAt.Store(cb.openedAt.UnixNano())
+	case stateClosed:
+		cb.clearFailuresLocked()
+	}
+
+	// Считаем переходы closed -> open и half-open -> closed
+	if (from == stateClosed && to == stateOpen) || (from == stateHalfOpen && to == stateClosed) {
+		cb.transaction++
+		cb.recordFlapLocked()
+	}
+
+	// Инцидент длится от выхода из closed (обычно размыкания, но и ручного перевода
+	// в half-open) до полного восстановления
+	if from == stateClosed && to != stateClosed {
+		cb.incidentStart = cb.stateSince
+	}
+	if from == stateHalfOpen && to == stateClosed {
+		if onRecover := cb.conf.OnRecover; onRecover != nil {
+			name, downtime := cb.name, cb.clock.Now().Sub(cb.incidentStart)
+			cb.afterUnlock(func() { onRecover(name, downtime) })
+		}
+		if onRecovered := cb.onRecovered; onRecovered != nil {
+			name := cb.name
+			cb.afterUnlock(func() { onRecovered(name) })
+		}
+		cb.incidentStart = time.Time{}
+	}
+
+	if onChange := cb.conf.OnStateChange; onChange != nil && from != to {
+		name := cb.name
+		cb.afterUnlock(func() { onChange(name, from, to) })
+	}
+
+	if cb.notify != nil && from != to {
+		cb.notify(Event{Name: cb.name, From: from, To: to, Time: cb.clock.Now(), Cause: cause})
+	}
+	// Переход, полученный из хранилища, обратно не публикуется
+	if cb.publish != nil && from != to && cause != CauseRemote {
+		cb.publish(Event{Name: cb.name, From: from, To: to, Time: cb.clock.Now(), Cause: cause})
+	}
+}
+
+// recordFlapLocked запоминает переход для оценки частоты переключений и вызывает OnFlap
+// при превышении FlapRate (один раз, пока частота не опустится ниже порога). Вызывается под cb.mu.
+func (cb *CircuitBreaker) recordFlapLocked() {
+	now := cb.clock.Now()
+	cb.flaps = append(cb.trimFlapsLocked(now), now)
+
+	if cb.conf.FlapRate <= 0 {
+		return
+	}
+	rate := cb.flapScoreLocked(now)
+	if rate < cb.conf.FlapRate {
+		cb.flapping = false
+		return
+	}
+	if !cb.flapping {
+		cb.flapping = true
+		if onFlap := cb.conf.OnFlap; onFlap != nil {
+			name := cb.name
+			cb.afterUnlock(func() { onFlap(name, rate) })
+		}
+	}
+}
+
+// trimFlapsLocked отбрасывает переходы старше FlapWindow. Вызывается под cb.mu.
+func (cb *CircuitBreaker) trimFlapsLocked(now time.Time) []time.Time {
+	i := 0
+	for i < len(cb.flaps) && now.Sub(cb.flaps[i]) > cb.conf.FlapWindow {
+		i++
+	}
+	return append(cb.flaps[:0], cb.flaps[i:]...)
+}
+
+// flapScoreLocked возвращает частоту переходов (в минуту) за последнее окно FlapWindow.
+// Вызывается под cb.mu (достаточно блокировки на чтение).
+func (cb *CircuitBreaker) flapScoreLocked(now time.Time) float64 {
+	n := 0
+	for _, ts := range cb.flaps {
+		if now.Sub(ts) <= cb.conf.FlapWindow {
+			n++
+		}
+	}
+	return float64(n) / cb.conf.FlapWindow.Minutes()
+}
+
+// afterUnlock откладывает вызов пользовательского колбэка до освобождения cb.mu.
+// Вызывается под cb.mu.
+func (cb *CircuitBreaker) afterUnlock(fn func()) {
+	cb.pending = append(cb.pending, fn)
+}
+
+// unlock освобождает cb.mu и вызывает отложенные колбэки вне блокировки
+func (cb *CircuitBreaker) unlock() {
+	cb.fastFailures.Store(int64(cb.failureCount))
+	cb.fastSuccesses.Store(int64(cb.successCount))
+	cb.fastTransitions.Store(int64(cb.transaction))
+
+	pending := cb.pending
+	cb.pending = nil
+	cb.mu.Unlock()
+
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+// stableLocked проверяет, что half-open длится без ошибок не меньше HalfOpenStabilizeDuration.
+// Вызывается под cb.mu.
+func (cb *CircuitBreaker) stableLocked() bool {
+	d := cb.conf.HalfOpenStabilizeDuration
+	return d == 0 || cb.clock.Now().Sub(cb.cleanSince) >= d
+}
+
+// inWarmupLocked проверяет, не истек ли период прогрева. Вызывается под cb.mu.
+func (cb *CircuitBreaker) inWarmupLocked() bool {
+	return cb.conf.WarmupPeriod > 0 && cb.clock.Now().Sub(cb.createdAt) < cb.conf.WarmupPeriod
+}
+
+// State возвращает текущее состояние
+func (cb *CircuitBreaker) curState() State {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.state
+}
+
+// rampedLocked проверяет, что при стратегии ramp_up доля пропускаемых запросов достигла 100%.
+// Вызывается под cb.mu.
+func (cb *CircuitBreaker) rampedLocked() bool {
+	return cb.conf.HalfOpenStrategy != HalfOpenRampUp || cb.rampPrc >= 100
+}
+
+// halfOpenExpiredLocked проверяет, истек ли HalfOpenTimeout для CB в half-open. Вызывается под cb.mu.
+func (cb *CircuitBreaker) halfOpenExpiredLocked() bool {
+	return cb.state == stateHalfOpen && cb.conf.HalfOpenTimeout > 0 &&
+		cb.clock.Now().Sub(cb.halfOpenAt) >= cb.conf.HalfOpenTimeout
+}
+
+// halfOpenTimeoutTargetLocked возвращает состояние, в которое CB переходит по истечении
+// HalfOpenTimeout. Вызывается под cb.mu.
+func (cb *CircuitBreaker) halfOpenTimeoutTargetLocked() State {
+	if cb.conf.HalfOpenTimeoutPolicy == HalfOpenTimeoutClose {
+		return stateClosed
+	}
+	return stateOpen
+}
+
+// expireHalfOpen выполняет переход по истечении HalfOpenTimeout и решает судьбу запроса
+// в новом состоянии
+func (cb *CircuitBreaker) expireHalfOpen(priority int) (bool, State, DecisionReason, uint64) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	// Повторная проверка: переход мог выполнить конкурентный вызов
+	if cb.halfOpenExpiredLocked() {
+		cb.setStateLocked(cb.halfOpenTimeoutTargetLocked(), CauseHalfOpenTimeout)
+	}
+	return cb.decideLocked(priority)
+}
+
+// recoveryTimeoutLocked возвращает действующий таймаут восстановления с учетом
+// RecoveryBackoff и MaxRecoveryTimeout. Вызывается под cb.mu.
+func (cb *CircuitBreaker) recoveryTimeoutLocked() time.Duration {
+	if cb.conf.RecoveryBackoff <= 1 || cb.backoffLevel == 0 {
+		return cb.recoveryTimeout
+	}
+	d := float64(cb.recoveryTimeout) * math.Pow(cb.conf.RecoveryBackoff, float64(cb.backoffLevel))
+	if limit := cb.conf.MaxRecoveryTimeout; limit > 0 && d > float64(limit) {
+		return limit
+	}
+	// math.MaxInt64 в fastRecovery означает отключенное восстановление
+	if d >= math.MaxInt64 {
+		return math.MaxInt64 - 1
+	}
+	return time.Duration(d)
+}
+
+// autoRecoveryLocked сообщает, разрешен ли автоматический переход open -> half-open
+// (CB не разомкнут принудительно и не исчерпан лимит MaxRecoveryAttempts). Вызывается под cb.mu.
+func (cb *CircuitBreaker) autoRecoveryLocked() bool {
+	if cb.forcedOpen.Load() {
+		return false
+	}
+	return cb.conf.MaxRecoveryAttempts <= 0 || cb.failedRecoveries < cb.conf.MaxRecoveryAttempts
+}
+
+// recoveryDueLocked проверяет, пора ли переводить разомкнутый CB в half-open. Вызывается под cb.mu.
+func (cb *CircuitBreaker) recoveryDueLocked() bool {
+	return cb.autoRecoveryLocked() && cb.recoveryPolicyLocked().ShouldProbe(cb.recoverySnapshotLocked())
+}
+
+// recoveryPolicyLocked возвращает действующую политику восстановления. Вызывается под cb.mu.
+func (cb *CircuitBreaker) recoveryPolicyLocked() RecoveryPolicy {
+	if p := cb.conf.RecoveryPolicy; p != nil {
+		return p
+	}
+	return FixedRecoveryPolicy{}
+}
+
+// recoverySnapshotLocked собирает снимок для RecoveryPolicy. Вызывается под cb.mu.
+func (cb *CircuitBreaker) recoverySnapshotLocked() RecoverySnapshot {
+	return RecoverySnapshot{
+		Name:             cb.name,
+		OpenedAt:         cb.openedAt,
+		HalfOpenAt:       cb.halfOpenAt,
+		Now:              cb.clock.Now(),
+		FailedRecoveries: cb.failedRecoveries,
+		Successes:        cb.halfOpenStreak,
+		RecoveryTimeout:  cb.recoveryTimeoutLocked(),
+		SuccessThreshold: cb.successThreshold,
+	}
+}
+
+// syncFastRecoveryLocked обновляет копию таймаута восстановления для быстрого пути allow().
+// Вызывается под cb.mu.
+// С пользовательской RecoveryPolicy быстрый путь не применяется: решение принимает политика.
+func (cb *CircuitBreaker) syncFastRecoveryLocked() {
+	switch {
+	case !cb.autoRecoveryLocked():
+		cb.fastRecovery.Store(math.MaxInt64)
+	case cb.conf.RecoveryPolicy != nil:
+		cb.fastRecovery.Store(0)
+	default:
+		cb.fastRecovery.Store(int64(cb.recoveryTimeoutLocked()))
+	}
+}
+
+// clearFailuresLocked сбрасывает накопленные в closed ошибки
+func (cb *CircuitBreaker) clearFailuresLocked() {
+	cb.failureCount = 0
+	cb.failureScore = 0
+	cb.timeoutCount = 0
+	cb.closedStreak = 0
+	cb.warned = false
+	cb.window.reset()
+}
+
+// reset принудительно замыкает CB и снимает ограничение MaxRecoveryAttempts и AlwaysOpen,
+// начиная испытательный срок заново. Счетчики и увеличение таймаута восстановления
+// сбрасываются и у уже замкнутого CB.
+func (cb *CircuitBreaker) reset() {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	cb.failedRecoveries = 0
+	cb.backoffLevel = 0
+	cb.probationLeft = cb.conf.ProbationSuccesses
+	cb.forcedOpen.Store(false)
+	if cb.state != stateClosed {
+		cb.setStateLocked(stateClosed, CauseManual)
+	} else {
+		cb.successCount = 0
+		cb.clearFailuresLocked()
+	}
+	cb.syncFastRecoveryLocked()
+}
+
+// relax временно умножает порог ошибок на multiplier на время d
+func (cb *CircuitBreaker) relax(multiplier float64, d time.Duration) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	cb.relaxMult = multiplier
+	cb.relaxUntil = cb.clock.Now().Add(d)
+}
+
+// applyRemote переводит CB в состояние, опубликованное другим экземпляром через StateStore.
+// Применяются только размыкание и замыкание разомкнутого или half-open CB; half-open каждый
+// экземпляр проходит сам. Переход не публикуется обратно; CB, разомкнутый через AlwaysOpen,
+// остается разомкнутым.
+func (cb *CircuitBreaker) applyRemote(to State) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	switch {
+	case cb.state == to:
+		return
+	case to == stateOpen:
+	case to == stateClosed && !cb.forcedOpen.Load():
+	default:
+		return
+	}
+	cb.setStateLocked(to, CauseRemote)
+
+	// Инцидент начинается с удаленного размыкания, даже если локально CB был в half-open
+	if to == stateOpen && cb.incidentStart.IsZero() {
+		cb.incidentStart = cb.openedAt
+	}
+}
+
+// triggerProbe немедленно переводит разомкнутый CB в half-open, не дожидаясь таймаута
+// восстановления. Для closed и half-open ничего не делает.
+func (cb *CircuitBreaker) triggerProbe() {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	if cb.state == stateOpen && !cb.forcedOpen.Load() {
+		cb.setStateLocked(stateHalfOpen, CauseProbeTriggered)
+	}
+}
+
+// periodFailureRatioLocked возвращает долю неудач среди результатов, сообщенных
+// с момента последнего перехода (0, если результатов не было). Вызывается под cb.mu.
+func (cb *CircuitBreaker) periodFailureRatioLocked() float64 {
+	total := cb.periodSuccesses + cb.periodFailures
+	if total == 0 {
+		return 0
+	}
+	return float64(cb.periodFailures) / float64(total)
+}
+
+// peek возвращает состояние, которое сообщил бы allow(), не выполняя переход
+// open -> half-open и не расходуя квоту half-open
+func (cb *CircuitBreaker) peek() State {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	if cb.state == stateOpen && cb.recoveryDueLocked() {
+		return stateHalfOpen
+	}
+	if cb.halfOpenExpiredLocked() {
+		return cb.halfOpenTimeoutTargetLocked()
+	}
+	return cb.state
+}
+
+// Config возвращает эффективную конфигурацию CB (после применения значений по умолчанию)
+func (cb *CircuitBreaker) Config() CircuitBreakerConf {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.configLocked()
+}
+
+// configLocked собирает эффективную конфигурацию. Вызывается под cb.mu.
+func (cb *CircuitBreaker) configLocked() CircuitBreakerConf {
+	conf := cb.conf
+	conf.FailureThreshold = cb.failureThreshold
+	conf.RecoveryTimeout = cb.recoveryTimeout
+	conf.SuccessThreshold = cb.successThreshold
+	conf.HalfOpenPrc = cb.halfOpenPrc
+	conf.Labels = maps.Clone(conf.Labels)
+	return conf
+}
+
+// openSince возвращает момент перехода в open и время, прошедшее с него.
+// Для состояний, отличных от open, третье значение false.
+func (cb *CircuitBreaker) openSince() (time.Time, time.Duration, bool) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	if cb.state != stateOpen {
+		return time.Time{}, 0, false
+	}
+	return cb.openedAt, cb.clock.Now().Sub(cb.openedAt), true
+}
+
+// retryAfter возвращает время, оставшееся до истечения таймаута восстановления
+// (не меньше 0). Для состояний, отличных от open, второе значение false.
+func (cb *CircuitBreaker) retryAfter() (time.Duration, bool) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.retryAfterLocked()
+}
+
+// stateRetryAfter согласованно возвращает текущее состояние и время до истечения
+// таймаута восстановления (0, если оно неизвестно или CB не разомкнут)
+func (cb *CircuitBreaker) stateRetryAfter() (State, time.Duration) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	wait, _ := cb.retryAfterLocked()
+	return cb.state, wait
+}
+
+// retryAfterLocked - реализация retryAfter. Вызывается под cb.mu.
+func (cb *CircuitBreaker) retryAfterLocked() (time.Duration, bool) {
+	// Без автоматического восстановления (MaxRecoveryAttempts) время ожидания неизвестно
+	if cb.state != stateOpen || !cb.autoRecoveryLocked() {
+		return 0, false
+	}
+	// Момент восстановления по пользовательской политике известен, только когда он наступил
+	if cb.conf.RecoveryPolicy != nil {
+		return 0, cb.recoveryDueLocked()
+	}
+	remaining := cb.recoveryTimeoutLocked() - cb.clock.Now().Sub(cb.openedAt)
+	if remaining < 0 {
+		return 0, true
+	}
+	return remaining, true
+}
+
+// Stats - снимок статистики Circuit Breaker (ключ - название показателя)
+type Stats = map[string]any
+
 // Stats возвращает статистику
-func (cb *circuitBreaker) stats() map[string]any {
+func (cb *CircuitBreaker) stats() Stats {
 	cb.mu.RLock()
 	defer cb.mu.RUnlock()
 
-	return map[string]any{
-		"state":             cb.state.String(),
-		"failure_count":     cb.failureCount,
-		"success_count":     cb.successCount,
-		"last_failure_time": cb.lastFailureTime,
-		"name":              cb.name,
-		"transaction":       cb.transaction,
+	return Stats{
+		"state":                  cb.state.String(),
+		"failure_count":          cb.failureCount,
+		"failure_score":          cb.failureScore,
+		"success_count":          cb.successCount,
+		"last_failure_time":      cb.lastFailureTime,
+		"opened_at":              cb.openedAt,
+		"name":                   cb.name,
+		"transaction":            cb.transaction,
+		"total_rejected":         cb.rejected.Load(),
+		"total_successes":        cb.totalSuccesses,
+		"probation_left":         cb.probationLeft,
+		"total_failures":         cb.totalFailures,
+		"half_open_denied_count": cb.halfOpenDenied.Load(),
+		"half_open_budget":       cb.halfOpenLimitLocked(),
+		"open_failures":          cb.openFailures,
+		"recovery_timeout":       cb.recoveryTimeoutLocked(),
+		"half_open_successes":    cb.halfOpenSuccesses,
+		"half_open_failures":     cb.halfOpenFailures,
+		"flap_score":             cb.flapScoreLocked(cb.clock.Now()),
+		"in_flight":              cb.inFlight.Load(),
+		"draining":               cb.draining.Load(),
+		"failures_by_category":   maps.Clone(cb.failuresByCat),
+		"labels":                 maps.Clone(cb.conf.Labels),
+		"failed_recoveries":      cb.failedRecoveries,
+		"stale_results":          cb.staleResults.Load(),
+		"effective_threshold":    cb.effectiveThresholdLocked(),
+		"timeout_count":          cb.timeoutCount,
+		"ramp_prc":               cb.rampPrc,
+		"failure_ratio":          cb.periodFailureRatioLocked(),
+		"config":                 cb.configLocked(),
 	}
 }
 
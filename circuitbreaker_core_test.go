@@ -1,7 +1,10 @@
 package circuitbreaker
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -9,7 +12,7 @@ import (
 )
 
 // Helper to create a CircuitBreaker for testing
-func newTestCB(name string, FailureThreshold int, recoveryTimeout time.Duration, successThreshold int, halfOpenPrc int) (*circuitBreaker, error) {
+func newTestCB(name string, FailureThreshold int, recoveryTimeout time.Duration, successThreshold int, halfOpenPrc int) (*CircuitBreaker, error) {
 	config := CircuitBreakerConf{
 		FailureThreshold: FailureThreshold,
 		RecoveryTimeout:  recoveryTimeout,
@@ -152,7 +155,7 @@ func TestCircuitBreaker_ConfigValidation(t *testing.T) {
 		srvName string
 		config  CircuitBreakerConf
 		wantErr bool
-		want    *circuitBreaker
+		want    *CircuitBreaker
 	}{
 		{
 			name:    "empty name",
@@ -170,7 +173,7 @@ func TestCircuitBreaker_ConfigValidation(t *testing.T) {
 				FailureThreshold: -1,
 				RecoveryTimeout:  time.Second,
 			},
-			want: &circuitBreaker{
+			want: &CircuitBreaker{
 				recoveryTimeout: 1 * time.Second,
 			},
 			wantErr: false,
@@ -182,7 +185,7 @@ func TestCircuitBreaker_ConfigValidation(t *testing.T) {
 				FailureThreshold: 3,
 				RecoveryTimeout:  0,
 			},
-			want: &circuitBreaker{
+			want: &CircuitBreaker{
 				recoveryTimeout: 30 * time.Second,
 			},
 			wantErr: false,
@@ -196,7 +199,7 @@ func TestCircuitBreaker_ConfigValidation(t *testing.T) {
 				SuccessThreshold: 2,
 				HalfOpenPrc:      50,
 			},
-			want: &circuitBreaker{
+			want: &CircuitBreaker{
 				recoveryTimeout: 2 * time.Second,
 			},
 			wantErr: false,
@@ -331,3 +334,1711 @@ func TestCircuitBreaker_StatsAccuracy(t *testing.T) {
 		t.Error("last_failure_time seems incorrect")
 	}
 }
+
+// fakeClock - управляемый источник времени для тестов
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestCircuitBreaker_Warmup(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 3,
+		RecoveryTimeout:  time.Second,
+		WarmupPeriod:     10 * time.Second,
+		Clock:            clock,
+	})
+
+	// Во время прогрева ошибки считаются, но CB не размыкается
+	for i := 0; i < 5; i++ {
+		cb.failure()
+	}
+	if cb.curState() != stateClosed {
+		t.Fatalf("Expected Closed state during warmup, got %s", cb.curState())
+	}
+	if cb.failureCount != 5 {
+		t.Errorf("Expected failures to be counted during warmup, got %d", cb.failureCount)
+	}
+
+	// После прогрева те же ошибки размыкают CB
+	clock.Advance(10 * time.Second)
+	cb.failure()
+	if cb.curState() != stateOpen {
+		t.Errorf("Expected Open state after warmup, got %s", cb.curState())
+	}
+}
+
+func TestCircuitBreaker_HalfOpenFailureTolerance(t *testing.T) {
+	newHalfOpen := func(tolerance int) *CircuitBreaker {
+		cb, _ := new("test", CircuitBreakerConf{
+			FailureThreshold:         1,
+			SuccessThreshold:         2,
+			HalfOpenFailureTolerance: tolerance,
+		})
+		cb.mu.Lock()
+		cb.setStateLocked(stateHalfOpen, CauseManual)
+		cb.mu.Unlock()
+		return cb
+	}
+
+	// Жесткий режим: первая же ошибка возвращает в open
+	hard := newHalfOpen(0)
+	hard.success()
+	hard.failure()
+	if hard.curState() != stateOpen {
+		t.Errorf("Expected Open after failure in hard mode, got %s", hard.curState())
+	}
+
+	// Мягкий режим: ошибки в пределах допуска сбрасывают серию успехов
+	soft := newHalfOpen(2)
+	soft.success()
+	soft.failure()
+	if soft.curState() != stateHalfOpen {
+		t.Fatalf("Expected Half-Open after tolerated failure, got %s", soft.curState())
+	}
+	soft.success()
+	if soft.curState() != stateHalfOpen {
+		t.Fatalf("Expected success streak to be reset by failure, got %s", soft.curState())
+	}
+	soft.success()
+	if soft.curState() != stateClosed {
+		t.Errorf("Expected Closed after full success streak, got %s", soft.curState())
+	}
+
+	// Мягкий режим: превышение допуска возвращает в open
+	soft = newHalfOpen(2)
+	soft.failure()
+	soft.failure()
+	if soft.curState() != stateHalfOpen {
+		t.Fatalf("Expected Half-Open within tolerance, got %s", soft.curState())
+	}
+	soft.failure()
+	if soft.curState() != stateOpen {
+		t.Errorf("Expected Open after exceeding tolerance, got %s", soft.curState())
+	}
+}
+
+func TestCircuitBreaker_DeterministicHalfOpen(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{
+		HalfOpenPrc:      25,
+		HalfOpenStrategy: HalfOpenDeterministic,
+	})
+	cb.mu.Lock()
+	cb.setStateLocked(stateHalfOpen, CauseManual)
+	cb.mu.Unlock()
+
+	// Ровно 1 запрос из 4, начиная с первого
+	for i := 0; i < 100; i++ {
+		allowed, _ := cb.allow()
+		if want := i%4 == 0; allowed != want {
+			t.Fatalf("request %d: allowed = %t, want %t", i, allowed, want)
+		}
+	}
+}
+
+func TestCircuitBreaker_HalfOpenStrategyDefault(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{HalfOpenStrategy: "unknown"})
+	if cb.Config().HalfOpenStrategy != HalfOpenRandom {
+		t.Errorf("Expected random strategy by default, got %q", cb.Config().HalfOpenStrategy)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenStabilizeDuration(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		SuccessThreshold:          2,
+		HalfOpenFailureTolerance:  5,
+		HalfOpenStabilizeDuration: 10 * time.Second,
+		Clock:                     clock,
+	})
+	cb.mu.Lock()
+	cb.setStateLocked(stateHalfOpen, CauseManual)
+	cb.mu.Unlock()
+
+	// Порог успехов достигнут, но период стабилизации еще не прошел
+	cb.success()
+	cb.success()
+	if cb.curState() != stateHalfOpen {
+		t.Fatalf("Expected Half-Open before stabilize duration, got %s", cb.curState())
+	}
+
+	// Ошибка сбрасывает таймер стабилизации
+	clock.Advance(8 * time.Second)
+	cb.failure()
+	clock.Advance(8 * time.Second)
+	cb.success()
+	cb.success()
+	if cb.curState() != stateHalfOpen {
+		t.Fatalf("Expected failure to reset stabilize timer, got %s", cb.curState())
+	}
+
+	// После полного периода без ошибок CB замыкается
+	clock.Advance(2 * time.Second)
+	cb.success()
+	if cb.curState() != stateClosed {
+		t.Errorf("Expected Closed after stabilize duration, got %s", cb.curState())
+	}
+}
+
+func TestCircuitBreaker_WarnThreshold(t *testing.T) {
+	var warnings []int
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 5,
+		WarnThreshold:    2,
+		OnWarn: func(name string, count int) {
+			if name != "test" {
+				t.Errorf("OnWarn name = %q, want %q", name, "test")
+			}
+			warnings = append(warnings, count)
+		},
+	})
+
+	// Предупреждение отправляется один раз при пересечении порога
+	cb.failure()
+	if len(warnings) != 0 {
+		t.Fatalf("Expected no warning below threshold, got %v", warnings)
+	}
+	cb.failure()
+	cb.failure()
+	if len(warnings) != 1 || warnings[0] != 2 {
+		t.Fatalf("Expected single warning at count 2, got %v", warnings)
+	}
+
+	// Опускаемся ниже порога и пересекаем его снова
+	cb.success()
+	cb.success()
+	cb.failure()
+	if len(warnings) != 2 {
+		t.Errorf("Expected second warning after re-crossing, got %v", warnings)
+	}
+
+	// Порог предупреждения не меньше FailureThreshold отключает предупреждения
+	cb, _ = new("test", CircuitBreakerConf{FailureThreshold: 2, WarnThreshold: 2})
+	if cb.Config().WarnThreshold != 0 {
+		t.Errorf("Expected WarnThreshold to be disabled, got %d", cb.Config().WarnThreshold)
+	}
+}
+
+func TestCircuitBreaker_RecoveryFromOpenedAt(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  10 * time.Second,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+
+	cb.failure()
+	openedAt := clock.Now()
+
+	// Ошибки, сообщаемые в open, не откладывают восстановление
+	for i := 0; i < 9; i++ {
+		clock.Advance(time.Second)
+		cb.failure()
+		if allowed, _ := cb.allow(); allowed {
+			t.Fatalf("Expected denied request before recovery timeout (step %d)", i)
+		}
+	}
+	if !cb.lastFailureTime.After(openedAt) {
+		t.Error("Expected last_failure_time to track failures reported while open")
+	}
+
+	clock.Advance(time.Second)
+	if _, state := cb.allow(); state != stateHalfOpen {
+		t.Errorf("Expected Half-Open after recovery timeout from open entry, got %s", state)
+	}
+}
+
+func TestCircuitBreaker_FlapDetection(t *testing.T) {
+	clock := newFakeClock()
+	var rates []float64
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		HalfOpenPrc:      100,
+		FlapWindow:       time.Minute,
+		FlapRate:         4,
+		OnFlap: func(name string, rate float64) {
+			rates = append(rates, rate)
+		},
+		Clock: clock,
+	})
+
+	flap := func() {
+		cb.failure() // closed -> open
+		clock.Advance(time.Second)
+		cb.allow()   // open -> half-open
+		cb.success() // half-open -> closed
+	}
+
+	// Один цикл - два перехода, ниже порога
+	flap()
+	if len(rates) != 0 {
+		t.Fatalf("Expected no flap callback below rate, got %v", rates)
+	}
+
+	// Два цикла - четыре перехода в минуту, порог достигнут; колбэк вызывается один раз
+	flap()
+	flap()
+	if len(rates) != 1 || rates[0] < 4 {
+		t.Fatalf("Expected single flap callback with rate >= 4, got %v", rates)
+	}
+
+	if score := cb.stats()["flap_score"].(float64); score != 6 {
+		t.Errorf("flap_score = %v, want 6", score)
+	}
+
+	// Вне окна переходы забываются
+	clock.Advance(2 * time.Minute)
+	if score := cb.stats()["flap_score"].(float64); score != 0 {
+		t.Errorf("flap_score after window = %v, want 0", score)
+	}
+}
+
+func TestCircuitBreaker_TokenBucketHalfOpen(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		HalfOpenStrategy:       HalfOpenTokenBucket,
+		HalfOpenBucketSize:     2,
+		HalfOpenRefillInterval: 100 * time.Millisecond,
+		Clock:                  clock,
+	})
+	cb.mu.Lock()
+	cb.setStateLocked(stateHalfOpen, CauseManual)
+	cb.mu.Unlock()
+
+	admitted := func(n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if allowed, _ := cb.allow(); allowed {
+				count++
+			}
+		}
+		return count
+	}
+
+	// Полное ведро: пропускается не больше емкости независимо от потока
+	if got := admitted(100); got != 2 {
+		t.Fatalf("Expected 2 admissions from full bucket, got %d", got)
+	}
+
+	// За каждый интервал восполняется один токен
+	for step := 0; step < 10; step++ {
+		clock.Advance(100 * time.Millisecond)
+		if got := admitted(50); got != 1 {
+			t.Fatalf("step %d: expected 1 admission per refill interval, got %d", step, got)
+		}
+	}
+
+	// Ведро не наполняется сверх емкости
+	clock.Advance(time.Second)
+	if got := admitted(50); got != 2 {
+		t.Errorf("Expected bucket capped at 2 tokens, got %d", got)
+	}
+}
+
+func TestCircuitBreaker_TokenBucketHalfOpenGrid(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		HalfOpenStrategy:       HalfOpenTokenBucket,
+		HalfOpenBucketSize:     1,
+		HalfOpenRefillInterval: time.Second,
+		MaxRPS:                 1000,
+		Clock:                  clock,
+	})
+	cb.mu.Lock()
+	cb.setStateLocked(stateHalfOpen, CauseManual)
+	cb.mu.Unlock()
+
+	// Токены half-open восполняются по сетке интервалов от перехода в half-open:
+	// остаток интервала, прошедший при полном ведре, не теряется
+	steps := []struct {
+		advance time.Duration
+		want    bool
+	}{
+		{0, true},
+		{1500 * time.Millisecond, true},
+		{500 * time.Millisecond, true},
+		{500 * time.Millisecond, false},
+	}
+	for i, s := range steps {
+		clock.Advance(s.advance)
+		if allowed, _ := cb.allow(); allowed != s.want {
+			t.Errorf("step %d: expected allowed=%v, got %v", i, s.want, allowed)
+		}
+	}
+}
+
+func TestCircuitBreaker_HalfOpenProbeCounters(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 2,
+		SuccessThreshold: 2,
+		RecoveryTimeout:  time.Second,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+
+	toHalfOpen := func() {
+		clock.Advance(time.Second)
+		cb.allow()
+	}
+
+	// Ошибки и успехи в closed не учитываются как пробы
+	cb.success()
+	cb.failure()
+	cb.failure() // closed -> open
+
+	toHalfOpen()
+	cb.success()
+	cb.failure() // half-open -> open
+
+	toHalfOpen()
+	cb.success()
+	cb.success() // half-open -> closed
+
+	cb.success()
+	cb.failure()
+
+	stats := cb.stats()
+	if got := stats["half_open_successes"].(int); got != 3 {
+		t.Errorf("half_open_successes = %d, want 3", got)
+	}
+	if got := stats["half_open_failures"].(int); got != 1 {
+		t.Errorf("half_open_failures = %d, want 1", got)
+	}
+}
+
+func TestCircuitBreaker_TripStrategy(t *testing.T) {
+	t.Run("count", func(t *testing.T) {
+		cb, _ := new("test", CircuitBreakerConf{FailureThreshold: 3, TripStrategy: CountBased})
+		// Чередование не накапливает ошибки до порога
+		for i := 0; i < 10; i++ {
+			cb.failure()
+			cb.success()
+		}
+		if cb.curState() != stateClosed {
+			t.Fatalf("Expected Closed with alternating results, got %s", cb.curState())
+		}
+		cb.failure()
+		cb.failure()
+		cb.failure()
+		if cb.curState() != stateOpen {
+			t.Errorf("Expected Open after 3 consecutive failures, got %s", cb.curState())
+		}
+	})
+
+	t.Run("ratio", func(t *testing.T) {
+		cb, _ := new("test", CircuitBreakerConf{
+			FailureThreshold: 1, // не используется стратегией ratio
+			TripStrategy:     RatioBased,
+			WindowSize:       10,
+			MinRequests:      4,
+			FailureRatio:     0.5,
+		})
+
+		// Пока в окне меньше MinRequests запросов, доля не оценивается
+		cb.failure()
+		cb.failure()
+		cb.failure()
+		if cb.curState() != stateClosed {
+			t.Fatalf("Expected Closed below MinRequests, got %s", cb.curState())
+		}
+
+		// Доля ошибок ниже порога: 3 из 7 (начинаем с чистого окна)
+		cb.window.reset()
+		for i := 0; i < 4; i++ {
+			cb.success()
+		}
+		cb.failure()
+		cb.failure()
+		cb.failure()
+		if cb.curState() != stateClosed {
+			t.Fatalf("Expected Closed with failure ratio below threshold, got %s", cb.curState())
+		}
+
+		// Доля ошибок достигает 50%: 4 из 8
+		cb.failure()
+		if cb.curState() != stateOpen {
+			t.Errorf("Expected Open with failure ratio >= 0.5, got %s (ratio %.2f)", cb.curState(), cb.window.ratio())
+		}
+	})
+}
+
+func BenchmarkCircuitBreaker_OpenRejection(b *testing.B) {
+	cb, _ := new("test", CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+	cb.failure()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if allowed, _ := cb.allow(); allowed {
+				b.Fatal("Expected rejection in open state")
+			}
+		}
+	})
+}
+
+func TestCircuitBreaker_OnRecover(t *testing.T) {
+	clock := newFakeClock()
+	var downtimes []time.Duration
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		RecoveryTimeout:  10 * time.Second,
+		HalfOpenPrc:      100,
+		OnRecover: func(name string, downtime time.Duration) {
+			downtimes = append(downtimes, downtime)
+		},
+		Clock: clock,
+	})
+
+	cb.failure() // closed -> open
+	clock.Advance(10 * time.Second)
+	cb.allow()   // open -> half-open
+	cb.failure() // half-open -> open, инцидент продолжается
+	if len(downtimes) != 0 {
+		t.Fatalf("Expected no recovery callback before close, got %v", downtimes)
+	}
+
+	clock.Advance(10 * time.Second)
+	cb.allow()
+	clock.Advance(time.Second)
+	cb.success() // half-open -> closed
+	if len(downtimes) != 1 || downtimes[0] != 21*time.Second {
+		t.Fatalf("Expected single recovery with downtime 21s, got %v", downtimes)
+	}
+
+	// Успехи в closed не вызывают колбэк повторно
+	cb.success()
+	if len(downtimes) != 1 {
+		t.Errorf("Expected exactly one recovery callback, got %v", downtimes)
+	}
+}
+
+func TestCircuitBreaker_OnRecoverForcedHalfOpen(t *testing.T) {
+	clock := newFakeClock()
+	var downtimes []time.Duration
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		OnRecover: func(name string, downtime time.Duration) {
+			downtimes = append(downtimes, downtime)
+		},
+		Clock: clock,
+	})
+
+	// Инцидент начинается с ручного перевода в half-open, минуя open
+	if err := cb.ForceState(StateHalfOpen); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(5 * time.Second)
+	cb.allow()
+	cb.success()
+	if len(downtimes) != 1 || downtimes[0] != 5*time.Second {
+		t.Errorf("Expected single recovery with downtime 5s, got %v", downtimes)
+	}
+}
+
+func TestCircuitBreaker_ClosedRecoverySuccesses(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:        10,
+		ClosedRecoverySuccesses: 3,
+	})
+
+	for i := 0; i < 5; i++ {
+		cb.failure()
+	}
+	// Два успеха декрементируют счетчик как обычно
+	cb.success()
+	cb.success()
+	if cb.failureCount != 3 {
+		t.Fatalf("Expected failureCount 3 before streak completes, got %d", cb.failureCount)
+	}
+
+	// Ошибка прерывает серию
+	cb.failure()
+	cb.success()
+	cb.success()
+	if cb.failureCount != 2 {
+		t.Fatalf("Expected failure to reset streak, got failureCount %d", cb.failureCount)
+	}
+
+	// Третий успех подряд обнуляет счетчик
+	cb.success()
+	if cb.failureCount != 0 || cb.failureScore != 0 {
+		t.Errorf("Expected failures reset after streak, got count %d score %v", cb.failureCount, cb.failureScore)
+	}
+}
+
+func TestCircuitBreaker_PeriodFailureRatio(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{FailureThreshold: 3, RecoveryTimeout: time.Hour})
+	ratio := func() float64 { return cb.stats()["failure_ratio"].(float64) }
+
+	if r := ratio(); r != 0 {
+		t.Errorf("Expected ratio 0 without results, got %v", r)
+	}
+
+	// 1 неудача из 4
+	cb.success()
+	cb.failure()
+	cb.success()
+	cb.success()
+	if r := ratio(); r != 0.25 {
+		t.Errorf("Expected ratio 0.25, got %v", r)
+	}
+
+	// Переход в open сбрасывает счетчики периода
+	cb.failure()
+	cb.failure()
+	cb.failure()
+	if cb.curState() != stateOpen {
+		t.Fatalf("Expected open, got %s", cb.curState())
+	}
+	if r := ratio(); r != 0 {
+		t.Errorf("Expected ratio reset on transition, got %v", r)
+	}
+	cb.failure()
+	if r := ratio(); r != 1 {
+		t.Errorf("Expected ratio 1 in open period, got %v", r)
+	}
+}
+
+func TestCircuitBreaker_MaxRecoveryAttempts(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:    1,
+		RecoveryTimeout:     time.Second,
+		HalfOpenPrc:         100,
+		MaxRecoveryAttempts: 2,
+		Clock:               clock,
+	})
+
+	cb.failure()
+	// Две неудачные попытки восстановления
+	for i := 0; i < 2; i++ {
+		clock.Advance(time.Second)
+		if allowed, state := cb.allow(); !allowed || state != stateHalfOpen {
+			t.Fatalf("attempt %d: expected probe in half-open, got %v (%s)", i, allowed, state)
+		}
+		cb.failure()
+	}
+
+	// Лимит исчерпан: CB остается в open независимо от времени
+	clock.Advance(time.Hour)
+	if allowed, state := cb.allow(); allowed || state != stateOpen {
+		t.Fatalf("Expected CB to stay open after attempt cap, got %v (%s)", allowed, state)
+	}
+	if state := cb.peek(); state != stateOpen {
+		t.Errorf("Expected peek to report open, got %s", state)
+	}
+
+	// Reset возвращает CB в closed и восстанавливает автоматическое восстановление
+	cb.reset()
+	if cb.curState() != stateClosed {
+		t.Fatalf("Expected closed after reset, got %s", cb.curState())
+	}
+	cb.failure()
+	clock.Advance(time.Second)
+	if allowed, state := cb.allow(); !allowed || state != stateHalfOpen {
+		t.Errorf("Expected auto-recovery after reset, got %v (%s)", allowed, state)
+	}
+}
+
+func TestCircuitBreaker_ResetClosed(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 3,
+		RecoveryTimeout:  time.Minute,
+	})
+
+	// Reset замкнутого CB сбрасывает накопленные ошибки
+	cb.failure()
+	cb.failure()
+	cb.reset()
+	if n := cb.stats()["failure_count"]; n != 0 {
+		t.Fatalf("Expected failure count reset, got %v", n)
+	}
+	cb.failure()
+	cb.failure()
+	if cb.curState() != stateClosed {
+		t.Errorf("Expected closed below threshold after reset, got %s", cb.curState())
+	}
+	cb.failure()
+	if cb.curState() != stateOpen {
+		t.Errorf("Expected open at threshold, got %s", cb.curState())
+	}
+}
+
+func TestCircuitBreaker_MaxRecoveryAttemptsTriggerProbe(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:    1,
+		RecoveryTimeout:     time.Second,
+		SuccessThreshold:    1,
+		HalfOpenPrc:         100,
+		MaxRecoveryAttempts: 1,
+		Clock:               clock,
+	})
+
+	cb.failure()
+	clock.Advance(time.Second)
+	cb.allow()
+	cb.failure()
+
+	// Ручная проба восстанавливает CB и сбрасывает счетчик попыток
+	cb.triggerProbe()
+	cb.success()
+	if cb.curState() != stateClosed {
+		t.Fatalf("Expected closed after successful manual probe, got %s", cb.curState())
+	}
+	if n := cb.stats()["failed_recoveries"].(int); n != 0 {
+		t.Errorf("Expected failed_recoveries reset on close, got %d", n)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenTimeout(t *testing.T) {
+	tests := []struct {
+		policy    HalfOpenTimeoutPolicy
+		wantState State
+		wantAllow bool
+	}{
+		{HalfOpenTimeoutReopen, stateOpen, false},
+		{HalfOpenTimeoutClose, stateClosed, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			clock := newFakeClock()
+			cb, _ := new("test", CircuitBreakerConf{
+				FailureThreshold:      1,
+				RecoveryTimeout:       time.Second,
+				SuccessThreshold:      5,
+				HalfOpenPrc:           100,
+				HalfOpenTimeout:       time.Minute,
+				HalfOpenTimeoutPolicy: tt.policy,
+				Clock:                 clock,
+			})
+
+			cb.failure()
+			clock.Advance(time.Second)
+			cb.allow()
+			cb.success()
+			if cb.curState() != stateHalfOpen {
+				t.Fatalf("Expected half-open, got %s", cb.curState())
+			}
+
+			// Трафика недостаточно для решения, таймаут истекает
+			clock.Advance(time.Minute)
+			if state := cb.peek(); state != tt.wantState {
+				t.Errorf("Expected peek %s, got %s", tt.wantState, state)
+			}
+			if allowed, state := cb.allow(); allowed != tt.wantAllow || state != tt.wantState {
+				t.Errorf("Expected %v (%s) after timeout, got %v (%s)", tt.wantAllow, tt.wantState, allowed, state)
+			}
+			if cb.curState() != tt.wantState {
+				t.Errorf("Expected state %s, got %s", tt.wantState, cb.curState())
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker_LoadSignal(t *testing.T) {
+	tests := []struct {
+		name     string
+		load     float64
+		failures int // количество ошибок, после которого CB размыкается
+	}{
+		{"idle", 0, 8},
+		{"half", 0.5, 5},
+		{"full", 1, 2},
+		{"overload clamped", 3, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, _ := new("test", CircuitBreakerConf{
+				FailureThreshold: 4,
+				LoadSignal:       func() float64 { return tt.load },
+			})
+			for i := 1; i < tt.failures; i++ {
+				cb.failure()
+			}
+			if cb.curState() != stateClosed {
+				t.Fatalf("Expected closed after %d failures, got %s", tt.failures-1, cb.curState())
+			}
+			cb.failure()
+			if cb.curState() != stateOpen {
+				t.Errorf("Expected open after %d failures, got %s", tt.failures, cb.curState())
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker_InitialState(t *testing.T) {
+	clock := newFakeClock()
+	cb, err := new("test", CircuitBreakerConf{
+		InitialState:    stateOpen,
+		RecoveryTimeout: time.Minute,
+		HalfOpenPrc:     100,
+		Clock:           clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if allowed, state := cb.allow(); allowed || state != stateOpen {
+		t.Fatalf("Expected CB to start open, got %v (%s)", allowed, state)
+	}
+	clock.Advance(59 * time.Second)
+	if allowed, _ := cb.allow(); allowed {
+		t.Error("Expected CB to block until RecoveryTimeout elapses")
+	}
+	clock.Advance(time.Second)
+	if allowed, state := cb.allow(); !allowed || state != stateHalfOpen {
+		t.Errorf("Expected probe after RecoveryTimeout, got %v (%s)", allowed, state)
+	}
+
+	for _, s := range []State{stateHalfOpen, notConfigured} {
+		if _, err := new("test", CircuitBreakerConf{InitialState: s}); err == nil {
+			t.Errorf("Expected error for initial state %s", s)
+		}
+	}
+}
+
+func TestCircuitBreaker_RampUpHalfOpen(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:         1,
+		RecoveryTimeout:          time.Second,
+		SuccessThreshold:         1,
+		HalfOpenPrc:              10,
+		HalfOpenStrategy:         HalfOpenRampUp,
+		HalfOpenRampStep:         30,
+		HalfOpenFailureTolerance: 1,
+		Clock:                    clock,
+	})
+	ramp := func() int { return cb.stats()["ramp_prc"].(int) }
+
+	cb.failure()
+	clock.Advance(time.Second)
+	cb.allow()
+	if cb.curState() != stateHalfOpen || ramp() != 10 {
+		t.Fatalf("Expected half-open at 10%%, got %s at %d%%", cb.curState(), ramp())
+	}
+
+	// Успехи увеличивают долю, но CB не замыкается до 100%
+	cb.success()
+	cb.success()
+	if ramp() != 70 || cb.curState() != stateHalfOpen {
+		t.Fatalf("Expected half-open at 70%%, got %s at %d%%", cb.curState(), ramp())
+	}
+
+	// Ошибка в пределах допуска возвращает долю к начальной
+	cb.failure()
+	if ramp() != 10 || cb.curState() != stateHalfOpen {
+		t.Fatalf("Expected ramp to drop to 10%% after failure, got %s at %d%%", cb.curState(), ramp())
+	}
+
+	for i := 0; i < 2; i++ {
+		cb.success()
+	}
+	if cb.curState() != stateHalfOpen {
+		t.Fatalf("Expected half-open below 100%%, got %s", cb.curState())
+	}
+	cb.success()
+	if cb.curState() != stateClosed {
+		t.Errorf("Expected closed at 100%%, got %s at %d%%", cb.curState(), ramp())
+	}
+}
+
+func TestCircuitBreaker_RampUpAdmission(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 100,
+		HalfOpenPrc:      10,
+		HalfOpenStrategy: HalfOpenRampUp,
+		HalfOpenRampStep: 45,
+		Clock:            clock,
+	})
+	cb.failure()
+	clock.Advance(time.Second)
+	cb.allow()
+
+	admitted := func() int {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if allowed, _ := cb.allow(); allowed {
+				n++
+			}
+		}
+		return n
+	}
+
+	low := admitted()
+	cb.success()
+	cb.success() // 100%
+	if high := admitted(); high != 1000 || low > 200 {
+		t.Errorf("Expected admission to climb from ~10%% to 100%%, got %d and %d of 1000", low, high)
+	}
+}
+
+func TestCircuitBreaker_Clone(t *testing.T) {
+	clock := newFakeClock()
+	var rejects []DecisionReason
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 3,
+		RecoveryTimeout:  time.Minute,
+		TripStrategy:     RatioBased,
+		WindowSize:       4,
+		MaxConcurrent:    1,
+		Labels:           map[string]string{"team": "a"},
+		Clock:            clock,
+		OnReject: func(name string, reason DecisionReason) {
+			rejects = append(rejects, reason)
+		},
+	})
+	cb.failure()
+	cb.failureCategory("timeout")
+	cb.relax(2, time.Minute)
+
+	clone := cb.Clone()
+	// Конфигурация содержит колбэки, которые reflect.DeepEqual не сравнивает
+	want, got := cb.stats(), clone.stats()
+	delete(want, "config")
+	delete(got, "config")
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected clone stats to match original:\n%v\n%v", got, want)
+	}
+
+	// Копия соблюдает MaxConcurrent и вызывает колбэки оригинала
+	if allowed, _, reason, _ := clone.admit(PriorityNormal, true); !allowed {
+		t.Fatalf("Expected first request to take a slot, got %s", reason)
+	}
+	if allowed, _, reason, _ := clone.admit(PriorityNormal, true); allowed || reason != ReasonBulkheadFull {
+		t.Errorf("Expected bulkhead_full on clone, got %v/%s", allowed, reason)
+	}
+	clone.inFlight.Add(-1)
+	if len(rejects) != 1 || rejects[0] != ReasonBulkheadFull {
+		t.Errorf("Expected OnReject to be called for clone, got %v", rejects)
+	}
+
+	// Изменения копии не затрагивают оригинал
+	clone.failure()
+	clone.failure()
+	clone.conf.Labels["team"] = "b"
+	if clone.curState() != stateOpen {
+		t.Fatalf("Expected clone to trip, got %s", clone.curState())
+	}
+	if cb.curState() != stateClosed || cb.failureCount != 2 || cb.window.count != 2 {
+		t.Errorf("Expected original untouched, got %s with %d failures (window %d)", cb.curState(), cb.failureCount, cb.window.count)
+	}
+	if cb.failuresByCat[FailureUnspecified] != 1 || cb.conf.Labels["team"] != "a" {
+		t.Errorf("Expected original maps untouched, got %v and %v", cb.failuresByCat, cb.conf.Labels)
+	}
+
+	// И наоборот
+	cb.success()
+	if clone.periodSuccesses != 0 {
+		t.Error("Expected clone to be independent of original")
+	}
+}
+
+func TestCircuitBreaker_CloneHalfOpenProbe(t *testing.T) {
+	for name, conf := range map[string]CircuitBreakerConf{
+		"single_probe":   {HalfOpenSingleProbe: true},
+		"max_concurrent": {HalfOpenMaxConcurrent: 1},
+	} {
+		clock := newFakeClock()
+		conf.FailureThreshold = 1
+		conf.RecoveryTimeout = time.Second
+		conf.HalfOpenPrc = 100
+		conf.Clock = clock
+		cb, _ := new("test", conf)
+		cb.failure()
+		clock.Advance(time.Second)
+
+		// Оригинал пропустил пробу и ждет ее результата
+		if allowed, state := cb.allow(); !allowed || state != stateHalfOpen {
+			t.Fatalf("%s: expected probe in half-open, got %v/%s", name, allowed, state)
+		}
+		if allowed, _ := cb.allow(); allowed {
+			t.Fatalf("%s: expected original to block while probe is in flight", name)
+		}
+
+		// Проба оригинала к копии не относится: копия пропускает свою
+		clone := cb.Clone()
+		if allowed, _, reason, _ := clone.admit(PriorityNormal, false); !allowed {
+			t.Errorf("%s: expected clone to admit its own probe, got %s", name, reason)
+		}
+	}
+}
+
+func TestCircuitBreaker_CloneDeferred(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		MinStateDuration: 10 * time.Second,
+		Clock:            clock,
+	})
+	clock.Advance(10 * time.Second)
+	cb.failure()
+	clock.Advance(time.Second)
+	cb.allow()
+	cb.success()
+
+	// Замыкание отложено MinStateDuration, и копия наследует отложенный переход
+	clone := cb.Clone()
+	if allowed, state := clone.allow(); !allowed || state != stateHalfOpen {
+		t.Fatalf("Expected clone to stay half-open, got %v/%s", allowed, state)
+	}
+	clock.Advance(10 * time.Second)
+	clone.allow()
+	if s := clone.curState(); s != stateClosed {
+		t.Errorf("Expected clone to apply deferred close, got %s", s)
+	}
+}
+
+func TestCircuitBreaker_MinStateDuration(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 2,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		MinStateDuration: 10 * time.Second,
+		Clock:            clock,
+	})
+
+	// Первое размыкание после истечения минимального времени с момента создания
+	clock.Advance(10 * time.Second)
+	cb.failure()
+	cb.failure()
+	if cb.curState() != stateOpen {
+		t.Fatalf("Expected open, got %s", cb.curState())
+	}
+
+	// Переход open -> half-open не откладывается, а half-open -> closed ждет MinStateDuration
+	clock.Advance(time.Second)
+	cb.allow()
+	cb.success()
+	if cb.curState() != stateHalfOpen {
+		t.Fatalf("Expected close to be deferred, got %s", cb.curState())
+	}
+	clock.Advance(10 * time.Second)
+	if allowed, state := cb.allow(); !allowed || state != stateClosed {
+		t.Fatalf("Expected deferred close to apply, got %v (%s)", allowed, state)
+	}
+
+	// Сразу после замыкания CB не размыкается, хотя ошибки учитываются
+	cb.failure()
+	cb.failure()
+	cb.failure()
+	if cb.curState() != stateClosed || cb.failureCount != 3 {
+		t.Fatalf("Expected closed with 3 failures within debounce window, got %s with %d", cb.curState(), cb.failureCount)
+	}
+	if allowed, _ := cb.allow(); !allowed {
+		t.Error("Expected requests to pass within debounce window")
+	}
+
+	// По истечении окна отложенное размыкание применяется
+	clock.Advance(10 * time.Second)
+	if allowed, state := cb.allow(); allowed || state != stateOpen {
+		t.Errorf("Expected deferred open to apply, got %v (%s)", allowed, state)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenMaxConcurrent(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:      1,
+		RecoveryTimeout:       time.Second,
+		SuccessThreshold:      10,
+		HalfOpenPrc:           100,
+		HalfOpenMaxConcurrent: 2,
+		Clock:                 clock,
+	})
+	cb.failure()
+	clock.Advance(time.Second)
+
+	// Пропускаются не более двух запросов без результата
+	for i := 0; i < 2; i++ {
+		if allowed, _, _ := cb.allowDetailed(PriorityNormal); !allowed {
+			t.Fatalf("Expected request %d to be admitted", i)
+		}
+	}
+	if allowed, _, reason := cb.allowDetailed(PriorityNormal); allowed || reason != ReasonHalfOpenBusy {
+		t.Fatalf("Expected half_open_busy, got %v/%s", allowed, reason)
+	}
+
+	// Результат освобождает слот
+	cb.success()
+	if allowed, _, _ := cb.allowDetailed(PriorityNormal); !allowed {
+		t.Error("Expected slot to be released after success")
+	}
+}
+
+func TestCircuitBreaker_ForceState(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{MinStateDuration: time.Hour})
+
+	// MinStateDuration не препятствует принудительному переходу
+	for _, s := range []State{StateOpen, StateHalfOpen, StateClosed} {
+		if err := cb.ForceState(s); err != nil {
+			t.Fatal(err)
+		}
+		if cb.State() != s {
+			t.Errorf("Expected %s, got %s", s, cb.State())
+		}
+	}
+	if err := cb.ForceState(StateNotConfigured); err == nil {
+		t.Error("Expected error for invalid state")
+	}
+}
+
+func TestCircuitBreaker_LoadShedFraction(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{LoadShedStart: 0.3, LoadShedMin: 0.2})
+
+	tests := []struct {
+		ratio, want float64
+	}{
+		{0.1, 0},
+		{0.3, 0.2},
+		{0.65, 0.575},
+		{1, maxShedFraction},
+	}
+	for _, tt := range tests {
+		if got := cb.shedFraction(tt.ratio); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("shedFraction(%v) = %v, want %v", tt.ratio, got, tt.want)
+		}
+	}
+}
+
+func TestCircuitBreaker_LoadShed(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1000,
+		WindowSize:       10,
+		LoadShedStart:    0.3,
+		LoadShedMin:      0.2,
+	})
+	rejected := func() int {
+		n := 0
+		for i := 0; i < 2000; i++ {
+			if allowed, _, reason := cb.allowDetailed(PriorityNormal); !allowed {
+				if reason != ReasonLoadShed {
+					t.Fatalf("Expected load_shed reason, got %s", reason)
+				}
+				n++
+			}
+		}
+		return n
+	}
+	// fill заполняет окно результатами с failures ошибками из 10
+	fill := func(failures int) {
+		for i := 0; i < 10; i++ {
+			if i < failures {
+				cb.failure()
+			} else {
+				cb.success()
+			}
+		}
+	}
+
+	fill(2)
+	if n := rejected(); n != 0 {
+		t.Fatalf("Expected no shedding below start ratio, got %d", n)
+	}
+
+	// Доля отклонений растет вместе с долей ошибок
+	prev := 0
+	for _, failures := range []int{4, 7, 9} {
+		fill(failures)
+		n := rejected()
+		if n <= prev {
+			t.Errorf("Expected shedding to increase at %d0%% failures, got %d after %d", failures, n, prev)
+		}
+		prev = n
+	}
+	if cb.curState() != stateClosed {
+		t.Errorf("Expected CB to stay closed while shedding, got %s", cb.curState())
+	}
+
+	fill(10)
+	if n := rejected(); n == 2000 || n < 1800 {
+		t.Errorf("Expected near-full rejection at 100%% failures, got %d", n)
+	}
+}
+
+func TestCircuitBreaker_LoadShedRecovers(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1000,
+		WindowSize:       10,
+		LoadShedStart:    0.3,
+	})
+	for i := 0; i < 10; i++ {
+		cb.failure()
+	}
+
+	// Даже при 100% ошибок часть запросов доходит до сервера, и их успехи
+	// освобождают окно, так что сброс нагрузки прекращается
+	for i := 0; i < 2000 && cb.window.ratio() >= 0.3; i++ {
+		if allowed, _, _ := cb.allowDetailed(PriorityNormal); allowed {
+			cb.success()
+		}
+	}
+	if ratio := cb.window.ratio(); ratio >= 0.3 {
+		t.Fatalf("Expected window to recover through admitted requests, ratio %v", ratio)
+	}
+	if allowed, _, reason := cb.allowDetailed(PriorityNormal); !allowed {
+		t.Errorf("Expected request to pass after recovery, got %s", reason)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenDeniedCount(t *testing.T) {
+	clock := newFakeClock()
+	var reasons []DecisionReason
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:    1,
+		RecoveryTimeout:     time.Second,
+		SuccessThreshold:    10,
+		HalfOpenSingleProbe: true,
+		Clock:               clock,
+		OnReject: func(name string, reason DecisionReason) {
+			reasons = append(reasons, reason)
+		},
+	})
+	counts := func() (uint64, uint64) {
+		s := cb.stats()
+		return s["total_rejected"].(uint64), s["half_open_denied_count"].(uint64)
+	}
+
+	// Отказ разомкнутого CB не считается отказом half-open
+	cb.failure()
+	cb.allow()
+	if total, denied := counts(); total != 1 || denied != 0 {
+		t.Fatalf("Expected 1 rejection and 0 half-open denials, got %d/%d", total, denied)
+	}
+
+	// Пока одиночная проба не завершена, остальные запросы отклоняются отбором half-open
+	clock.Advance(time.Second)
+	cb.allow()
+	cb.allow()
+	if total, denied := counts(); total != 2 || denied != 1 {
+		t.Errorf("Expected 2 rejections and 1 half-open denial, got %d/%d", total, denied)
+	}
+
+	want := []DecisionReason{ReasonOpen, ReasonProbeInFlight}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Expected OnReject reasons %v, got %v", want, reasons)
+	}
+}
+
+func TestCircuitBreaker_AlwaysOpen(t *testing.T) {
+	clock := newFakeClock()
+	cb, err := new("test", CircuitBreakerConf{
+		RecoveryTimeout: time.Second,
+		HalfOpenPrc:     100,
+		AlwaysOpen:      true,
+		Clock:           clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Запросы отклоняются и после истечения таймаута восстановления
+	for i := 0; i < 3; i++ {
+		if allowed, state, reason := cb.allowDetailed(PriorityNormal); allowed || state != stateOpen || reason != ReasonForcedOpen {
+			t.Fatalf("Expected forced_open rejection, got %v/%s/%s", allowed, state, reason)
+		}
+		clock.Advance(time.Hour)
+	}
+	cb.triggerProbe()
+	if cb.curState() != stateOpen {
+		t.Fatalf("Expected TriggerProbe to be ignored, got %s", cb.curState())
+	}
+
+	// Reset снимает принудительное размыкание
+	cb.reset()
+	if allowed, _ := cb.allow(); !allowed {
+		t.Error("Expected requests to pass after Reset")
+	}
+
+	// Включение через конфигурацию размыкает CB сразу, выключение возвращает восстановление
+	conf := cb.Config()
+	conf.AlwaysOpen = false
+	cb.updateConfig(conf)
+	conf.AlwaysOpen = true
+	cb.updateConfig(conf)
+	if _, _, reason := cb.allowDetailed(PriorityNormal); reason != ReasonForcedOpen {
+		t.Fatalf("Expected forced_open after enabling AlwaysOpen, got %s", reason)
+	}
+	conf.AlwaysOpen = false
+	cb.updateConfig(conf)
+	clock.Advance(time.Second)
+	if allowed, state := cb.allow(); !allowed || state != stateHalfOpen {
+		t.Errorf("Expected normal recovery after disabling AlwaysOpen, got %v/%s", allowed, state)
+	}
+}
+
+func TestCircuitBreaker_FailureThresholdDefault(t *testing.T) {
+	// Обычные значения по-прежнему заменяются на значение по умолчанию
+	for _, threshold := range []int{0, -1} {
+		cb, _ := new("test", CircuitBreakerConf{FailureThreshold: threshold})
+		if cb.Config().FailureThreshold != 5 || cb.curState() != stateClosed {
+			t.Errorf("Expected default threshold 5 in closed for %d, got %d in %s",
+				threshold, cb.Config().FailureThreshold, cb.curState())
+		}
+	}
+}
+
+func TestCircuitBreaker_MaxRPS(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:    1,
+		RecoveryTimeout:     time.Second,
+		HalfOpenPrc:         100,
+		HalfOpenSingleProbe: true,
+		MaxRPS:              5,
+		Clock:               clock,
+	})
+	admit := func(n int) (admitted int) {
+		for i := 0; i < n; i++ {
+			allowed, _, reason := cb.allowDetailed(PriorityNormal)
+			if allowed {
+				admitted++
+			} else if reason != ReasonRateLimited {
+				t.Fatalf("Expected rate_limited, got %s", reason)
+			}
+		}
+		return admitted
+	}
+
+	if n := admit(10); n != 5 {
+		t.Fatalf("Expected 5 admitted in closed, got %d", n)
+	}
+	clock.Advance(200 * time.Millisecond)
+	if n := admit(10); n != 1 {
+		t.Fatalf("Expected 1 token refilled after 200ms, got %d", n)
+	}
+
+	// В half-open лимит не расходует одиночную пробу
+	cb.failure()
+	clock.Advance(time.Second)
+	for i := 0; i < 5; i++ {
+		cb.limiter.take(clock.Now(), 5, 200*time.Millisecond)
+	}
+	if allowed, state, reason := cb.allowDetailed(PriorityNormal); allowed || state != stateHalfOpen || reason != ReasonRateLimited {
+		t.Fatalf("Expected rate-limited probe in half-open, got %v/%s/%s", allowed, state, reason)
+	}
+	clock.Advance(200 * time.Millisecond)
+	if allowed, _, reason := cb.allowDetailed(PriorityNormal); !allowed || reason != ReasonHalfOpenAdmitted {
+		t.Errorf("Expected probe to be admitted after refill, got %v/%s", allowed, reason)
+	}
+}
+
+func TestCircuitBreaker_CountOpenFailures(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		clock := newFakeClock()
+		cb, _ := new("test", CircuitBreakerConf{
+			FailureThreshold:  1,
+			RecoveryTimeout:   time.Minute,
+			CountOpenFailures: enabled,
+			Clock:             clock,
+		})
+		openFailures := func() int { return cb.stats()["open_failures"].(int) }
+
+		cb.failure()
+		for i := 0; i < 3; i++ {
+			cb.failure()
+		}
+		want := 0
+		if enabled {
+			want = 3
+		}
+		if got := openFailures(); got != want || cb.curState() != stateOpen {
+			t.Fatalf("enabled=%v: expected %d open failures in open, got %d in %s", enabled, want, got, cb.curState())
+		}
+
+		// Счетчик сбрасывается при следующем размыкании
+		clock.Advance(time.Minute)
+		cb.allow()
+		cb.failure()
+		if got := openFailures(); got != 0 || cb.curState() != stateOpen {
+			t.Errorf("enabled=%v: expected counter reset on reopen, got %d in %s", enabled, got, cb.curState())
+		}
+	}
+}
+
+func TestCircuitBreaker_OnDecision(t *testing.T) {
+	type decision struct {
+		allowed bool
+		state   State
+		reason  DecisionReason
+	}
+	var got []decision
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:    1,
+		RecoveryTimeout:     time.Second,
+		HalfOpenSingleProbe: true,
+		Clock:               clock,
+		OnDecision: func(name string, allowed bool, state State, reason DecisionReason) {
+			if name != "test" {
+				t.Errorf("Expected name 'test', got %q", name)
+			}
+			got = append(got, decision{allowed, state, reason})
+		},
+	})
+
+	cb.allow()
+	cb.failure()
+	cb.allow()
+	clock.Advance(time.Second)
+	cb.allow()
+	cb.allow()
+
+	want := []decision{
+		{true, stateClosed, ReasonClosed},
+		{false, stateOpen, ReasonOpen},
+		{true, stateHalfOpen, ReasonHalfOpenAdmitted},
+		{false, stateHalfOpen, ReasonProbeInFlight},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected decisions %v, got %v", want, got)
+	}
+}
+
+func BenchmarkCircuitBreaker_Allow(b *testing.B) {
+	for _, tt := range []struct {
+		name string
+		hook func(string, bool, State, DecisionReason)
+	}{
+		{"NoHook", nil},
+		{"OnDecision", func(string, bool, State, DecisionReason) {}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			cb, _ := new("test", CircuitBreakerConf{OnDecision: tt.hook})
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					cb.allow()
+				}
+			})
+		})
+	}
+}
+
+func TestCircuitBreaker_LongHalfOpenCountersBounded(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:          1,
+		RecoveryTimeout:           time.Second,
+		SuccessThreshold:          5,
+		HalfOpenPrc:               100,
+		HalfOpenFailureTolerance:  4,
+		HalfOpenStabilizeDuration: time.Hour,
+		Clock:                     clock,
+	})
+	cb.failure()
+	clock.Advance(time.Second)
+	cb.allow()
+
+	// Длительный half-open: тысячи успехов и редкие ошибки в пределах допуска,
+	// замыкание откладывается HalfOpenStabilizeDuration
+	for i := 0; i < 5000; i++ {
+		if i%1250 == 625 {
+			cb.failure()
+			if cb.successCount != 0 {
+				t.Fatalf("Expected failure to zero success count, got %d", cb.successCount)
+			}
+		} else {
+			cb.success()
+		}
+		if cb.successCount > 5 || cb.successCount < 0 {
+			t.Fatalf("Expected success count within [0, 5], got %d at step %d", cb.successCount, i)
+		}
+		if cb.curState() != stateHalfOpen {
+			t.Fatalf("Expected half-open at step %d, got %s", i, cb.curState())
+		}
+	}
+	if cb.successCount != 5 {
+		t.Fatalf("Expected success count clamped at 5, got %d", cb.successCount)
+	}
+
+	// Очередная ошибка превышает допуск и возвращает CB в open
+	cb.failure()
+	if cb.curState() != stateOpen || cb.successCount != 0 {
+		t.Fatalf("Expected open with zero success count, got %s with %d", cb.curState(), cb.successCount)
+	}
+
+	// В новом half-open после стабилизации CB замыкается на пороге
+	clock.Advance(time.Second)
+	cb.allow()
+	clock.Advance(time.Hour)
+	for i := 0; i < 5; i++ {
+		cb.success()
+	}
+	if cb.curState() != stateClosed {
+		t.Errorf("Expected closed at success threshold, got %s", cb.curState())
+	}
+}
+
+func TestCircuitBreaker_BackoffResetOnProbe(t *testing.T) {
+	for _, tt := range []struct {
+		resetOnProbe bool
+		wantWait     time.Duration
+	}{
+		{false, 4 * time.Second},
+		{true, 2 * time.Second},
+	} {
+		clock := newFakeClock()
+		cb, _ := new("test", CircuitBreakerConf{
+			FailureThreshold:    1,
+			RecoveryTimeout:     time.Second,
+			SuccessThreshold:    3,
+			HalfOpenPrc:         100,
+			RecoveryBackoff:     2,
+			BackoffResetOnProbe: tt.resetOnProbe,
+			Clock:               clock,
+		})
+		wait := func() time.Duration {
+			d, _ := cb.retryAfter()
+			return d
+		}
+
+		// Первая попытка восстановления неудачна: таймаут удваивается
+		cb.failure()
+		clock.Advance(time.Second)
+		cb.allow()
+		cb.failure()
+		if w := wait(); w != 2*time.Second {
+			t.Fatalf("resetOnProbe=%v: expected 2s after failed recovery, got %v", tt.resetOnProbe, w)
+		}
+
+		// Вторая попытка: одна успешная проба, затем ошибка
+		clock.Advance(2 * time.Second)
+		cb.allow()
+		cb.success()
+		cb.failure()
+		if w := wait(); w != tt.wantWait {
+			t.Errorf("resetOnProbe=%v: expected %v after flapping recovery, got %v", tt.resetOnProbe, tt.wantWait, w)
+		}
+
+		// Полное восстановление сбрасывает задержку в обоих режимах
+		clock.Advance(tt.wantWait)
+		cb.allow()
+		for i := 0; i < 3; i++ {
+			cb.success()
+		}
+		cb.failure()
+		if w := wait(); w != time.Second {
+			t.Errorf("resetOnProbe=%v: expected base timeout after close, got %v", tt.resetOnProbe, w)
+		}
+	}
+}
+
+func TestCircuitBreaker_MaxRecoveryTimeout(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:   1,
+		RecoveryTimeout:    time.Second,
+		HalfOpenPrc:        100,
+		RecoveryBackoff:    10,
+		MaxRecoveryTimeout: 30 * time.Second,
+		Clock:              clock,
+	})
+	cb.failure()
+	for i := 0; i < 5; i++ {
+		d, _ := cb.retryAfter()
+		clock.Advance(d)
+		cb.allow()
+		cb.failure()
+	}
+	if d, _ := cb.retryAfter(); d != 30*time.Second {
+		t.Errorf("Expected timeout capped at 30s, got %v", d)
+	}
+}
+
+func TestCircuitBreaker_Probation(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:          4,
+		RecoveryTimeout:           time.Minute,
+		ProbationSuccesses:        3,
+		ProbationFailureThreshold: 2,
+	})
+
+	// На испытательном сроке CB размыкается по строгому порогу
+	cb.failure()
+	cb.failure()
+	if cb.State() != StateOpen {
+		t.Fatalf("Expected open after probation threshold, got %s", cb.State())
+	}
+
+	// После Reset испытательный срок начинается заново; успехи его завершают
+	cb.reset()
+	for i := 0; i < 3; i++ {
+		cb.success()
+	}
+	if left := cb.stats()["probation_left"]; left != 0 {
+		t.Fatalf("Expected probation to end, got %v left", left)
+	}
+	for i := 0; i < 3; i++ {
+		cb.failure()
+	}
+	if cb.State() != StateClosed {
+		t.Fatalf("Expected closed below normal threshold, got %s", cb.State())
+	}
+	cb.failure()
+	if cb.State() != StateOpen {
+		t.Errorf("Expected open at normal threshold, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreaker_ProbationDefaultThreshold(t *testing.T) {
+	cb, _ := new("test", CircuitBreakerConf{FailureThreshold: 6, ProbationSuccesses: 1})
+	if got := cb.stats()["effective_threshold"]; got != float64(3) {
+		t.Errorf("Expected probation threshold 3, got %v", got)
+	}
+	cb.success()
+	if got := cb.stats()["effective_threshold"]; got != float64(6) {
+		t.Errorf("Expected normal threshold 6 after probation, got %v", got)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenPrcClamp(t *testing.T) {
+	// В обычном режиме HalfOpenPrc приводится к диапазону 1..100,
+	// причем незаданный (нулевой) заменяется на 20, а не означает 0%
+	tests := []struct {
+		prc, want int
+	}{
+		{0, 20},
+		{-5, 20},
+		{50, 50},
+		{150, 100},
+	}
+	for _, tt := range tests {
+		cb, err := new("test", CircuitBreakerConf{HalfOpenPrc: tt.prc})
+		if err != nil {
+			t.Fatalf("HalfOpenPrc %d: unexpected error %v", tt.prc, err)
+		}
+		if cb.conf.HalfOpenPrc != tt.want {
+			t.Errorf("HalfOpenPrc %d: expected %d, got %d", tt.prc, tt.want, cb.conf.HalfOpenPrc)
+		}
+	}
+}
+
+func TestCircuitBreaker_StrictValidation(t *testing.T) {
+	for _, prc := range []int{0, -5, 150} {
+		cfg := CircuitBreakerConf{HalfOpenPrc: prc, StrictValidation: true}
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("HalfOpenPrc %d: expected ErrInvalidConfig from Validate, got %v", prc, err)
+		}
+		if _, err := new("test", cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("HalfOpenPrc %d: expected ErrInvalidConfig from new, got %v", prc, err)
+		}
+	}
+
+	cfg := CircuitBreakerConf{HalfOpenPrc: 100, StrictValidation: true}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid strict config, got %v", err)
+	}
+	if err := (CircuitBreakerConf{HalfOpenPrc: 150}).Validate(); err != nil {
+		t.Errorf("Expected lenient config to pass Validate, got %v", err)
+	}
+
+	// UpdateConfig не применяет некорректную строгую конфигурацию
+	m := NewCBManager()
+	m.AddCircuitBreaker("test", cfg)
+	if err := m.UpdateConfig("test", CircuitBreakerConf{HalfOpenPrc: 150, StrictValidation: true}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig from UpdateConfig, got %v", err)
+	}
+	if got, _ := m.GetConfig("test"); got.HalfOpenPrc != 100 {
+		t.Errorf("Expected config to stay unchanged, got HalfOpenPrc %d", got.HalfOpenPrc)
+	}
+}
+
+func TestCircuitBreaker_AdaptiveProbeBudget(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold:         1,
+		RecoveryTimeout:          time.Second,
+		SuccessThreshold:         10,
+		HalfOpenPrc:              100,
+		HalfOpenMaxConcurrent:    1,
+		HalfOpenMaxConcurrentCap: 3,
+		HalfOpenFailureTolerance: 5,
+		Clock:                    clock,
+	})
+	cb.failure()
+	clock.Advance(time.Second)
+
+	// admitAll занимает все свободные слоты и возвращает их количество
+	admitAll := func() int {
+		n := 0
+		for {
+			allowed, _, reason := cb.allowDetailed(PriorityNormal)
+			if !allowed {
+				if reason != ReasonHalfOpenBusy {
+					t.Fatalf("Expected half_open_busy, got %s", reason)
+				}
+				return n
+			}
+			n++
+		}
+	}
+	budget := func() int { return cb.stats()["half_open_budget"].(int) }
+
+	if n := admitAll(); n != 1 {
+		t.Fatalf("Expected initial budget 1, got %d", n)
+	}
+
+	// Каждая успешная проба увеличивает бюджет на единицу, но не выше предела
+	for want := 2; want <= 4; want++ {
+		cb.success()
+		if b := budget(); b != min(want, 3) {
+			t.Fatalf("Expected budget %d, got %d", min(want, 3), b)
+		}
+	}
+	if n := admitAll(); n != 3 {
+		t.Errorf("Expected 3 concurrent probes at cap, got %d", n)
+	}
+
+	// Ошибки уменьшают бюджет, но не ниже одного слота
+	for _, want := range []int{2, 1, 1} {
+		cb.failure()
+		if b := budget(); b != want {
+			t.Errorf("Expected budget %d after failure, got %d", want, b)
+		}
+	}
+
+	// При новом переходе в half-open бюджет сбрасывается
+	cb.ForceState(StateOpen)
+	clock.Advance(time.Second)
+	cb.allow()
+	if b := budget(); b != 1 {
+		t.Errorf("Expected budget reset to 1, got %d", b)
+	}
+}
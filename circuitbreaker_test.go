@@ -1,7 +1,13 @@
 package circuitbreaker
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -34,6 +40,28 @@ func TestInitCircuitBreakers(t *testing.T) {
 	}
 }
 
+func TestInitCircuitBreakersReport(t *testing.T) {
+	m := NewCBManager()
+	m.SetKeyNormalizer(strings.TrimSpace)
+
+	res := m.InitCircuitBreakersReport([]string{"server1", "", "server2", "   "}, CircuitBreakerConf{})
+	if want := []string{"server1", "server2"}; !reflect.DeepEqual(res.Created, want) {
+		t.Errorf("Expected created %v, got %v", want, res.Created)
+	}
+	// Пустое имя после нормализации отклоняется
+	if len(res.Failed) != 1 || res.Failed[""] == nil {
+		t.Errorf("Expected failure for empty name, got %v", res.Failed)
+	}
+	if m.GetCircuitBreaker("server2") == nil {
+		t.Error("Expected valid entries to be registered")
+	}
+
+	// Прежняя сигнатура возвращает ошибку для каждой некорректной записи
+	if errs := m.InitCircuitBreakers([]string{"", "server3", ""}, CircuitBreakerConf{}); len(errs) != 2 {
+		t.Errorf("Expected 2 errors from InitCircuitBreakers, got %v", errs)
+	}
+}
+
 func TestGetCircuitBreaker(t *testing.T) {
 	servers := []string{"test-server"}
 	cfg := CircuitBreakerConf{
@@ -307,3 +335,1150 @@ func TestEdgeCases(t *testing.T) {
 		t.Error("Expected circuit breaker with default config")
 	}
 }
+
+func TestAllowRequestContext(t *testing.T) {
+	clock := newFakeClock()
+	cfg := CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	}
+
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, cfg)
+
+	// Тест: живой контекст, CB закрыт
+	allowed, state := m.AllowRequestContext(context.Background(), "test-server")
+	if !allowed || state != stateClosed {
+		t.Errorf("Expected allowed request in closed state, got %t/%s", allowed, state)
+	}
+
+	// Тест: отмененный контекст отклоняет запрос даже в closed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	allowed, state = m.AllowRequestContext(ctx, "test-server")
+	if allowed || state != stateClosed {
+		t.Errorf("Expected denied request for done context, got %t/%s", allowed, state)
+	}
+
+	// Тест: отмененный контекст не запускает переход open -> half-open
+	m.ReportFailure("test-server")
+	clock.Advance(time.Second)
+	allowed, state = m.AllowRequestContext(ctx, "test-server")
+	if allowed || state != stateOpen {
+		t.Errorf("Expected denied request in open state, got %t/%s", allowed, state)
+	}
+
+	// Тест: несконфигурированный сервер с отмененным контекстом
+	allowed, state = m.AllowRequestContext(ctx, "unknown-server")
+	if allowed || state != notConfigured {
+		t.Errorf("Expected denied request for unknown server, got %t/%s", allowed, state)
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 7,
+		HalfOpenPrc:      150,
+	})
+
+	// Тест: сервер без CB
+	if _, ok := m.GetConfig("unknown-server"); ok {
+		t.Error("Expected no config for unknown server")
+	}
+
+	// Тест: возвращаются значения после применения умолчаний
+	cfg, ok := m.GetConfig("test-server")
+	if !ok {
+		t.Fatal("Expected config for test-server")
+	}
+	want := CircuitBreakerConf{
+		FailureThreshold:      7,
+		RecoveryTimeout:       30 * time.Second,
+		SuccessThreshold:      3,
+		HalfOpenPrc:           100,
+		HalfOpenTimeoutPolicy: HalfOpenTimeoutReopen,
+		TimeoutWeight:         1,
+		HalfOpenRampStep:      10,
+		HalfOpenStrategy:      HalfOpenRandom,
+		FlapWindow:            time.Minute,
+		Clock:                 realClock{},
+		TripStrategy:          CountBased,
+		WindowSize:            20,
+		MinRequests:           20,
+		FailureRatio:          0.5,
+
+		HalfOpenBucketSize:     1,
+		HalfOpenRefillInterval: time.Second,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("GetConfig() = %+v, want %+v", cfg, want)
+	}
+
+	// Тест: конфигурация попадает в статистику
+	stats := m.GetCircuitBreakerStats()["test-server"].(map[string]any)
+	if !reflect.DeepEqual(stats["config"], want) {
+		t.Errorf("stats config = %+v, want %+v", stats["config"], want)
+	}
+}
+
+func TestReportResult(t *testing.T) {
+	errIgnored := errors.New("not found")
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 2,
+		RecoveryTimeout:  time.Minute,
+		IsFailure: func(err error) bool {
+			return !errors.Is(err, errIgnored)
+		},
+	})
+
+	// Ошибки, не признанные классификатором, не размыкают CB
+	m.ReportResult("test-server", errIgnored)
+	m.ReportResult("test-server", errIgnored)
+	m.ReportResult("test-server", nil)
+	if state := m.GetCircuitBreakerState("test-server"); state != "closed" {
+		t.Fatalf("Expected 'closed', got '%s'", state)
+	}
+
+	m.ReportResult("test-server", errors.New("boom"))
+	m.ReportResult("test-server", errors.New("boom"))
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected 'open', got '%s'", state)
+	}
+
+	// Сервер без CB (не должно паниковать)
+	m.ReportResult("unknown-server", errors.New("boom"))
+}
+
+func TestBreaker(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Minute})
+
+	if _, ok := m.Breaker("unknown-server"); ok {
+		t.Error("Expected no breaker for unknown server")
+	}
+
+	cb, ok := m.Breaker("test-server")
+	if !ok || cb != m.GetCircuitBreaker("test-server") {
+		t.Fatal("Expected breaker for test-server")
+	}
+
+	// Вызовы через сохраненный CB отражаются в менеджере
+	if allowed, _ := cb.Allow(); !allowed {
+		t.Error("Expected allowed request for closed CB")
+	}
+	cb.Failure()
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected 'open', got '%s'", state)
+	}
+}
+
+func BenchmarkManagerDispatch(b *testing.B) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"hot-server"}, CircuitBreakerConf{FailureThreshold: 1 << 30})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if allowed, _ := m.AllowRequest("hot-server"); allowed {
+				m.ReportSuccess("hot-server")
+			}
+		}
+	})
+}
+
+func BenchmarkCachedBreakerDispatch(b *testing.B) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"hot-server"}, CircuitBreakerConf{FailureThreshold: 1 << 30})
+	cb, _ := m.Breaker("hot-server")
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if allowed, _ := cb.Allow(); allowed {
+				cb.Success()
+			}
+		}
+	})
+}
+
+func TestAddCircuitBreaker(t *testing.T) {
+	m := NewCBManager()
+
+	if err := m.AddCircuitBreaker("", CircuitBreakerConf{}); err == nil {
+		t.Error("Expected error for empty server name")
+	}
+	if err := m.AddCircuitBreaker("server1", CircuitBreakerConf{}); err != nil {
+		t.Fatalf("AddCircuitBreaker() error = %v", err)
+	}
+	if m.GetCircuitBreaker("server1") == nil {
+		t.Error("Expected circuit breaker for server1")
+	}
+}
+
+func TestMaxBreakers_Eviction(t *testing.T) {
+	cfg := CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Minute}
+	m := NewCBManager()
+	m.SetMaxBreakers(3)
+
+	for _, srv := range []string{"a", "b", "c"} {
+		m.AddCircuitBreaker(srv, cfg)
+	}
+
+	// Обращение к "a" делает самым давним "b"
+	m.AllowRequest("a")
+	m.AddCircuitBreaker("d", cfg)
+	if m.GetCircuitBreaker("b") != nil {
+		t.Error("Expected least recently used breaker 'b' to be evicted")
+	}
+
+	// Разомкнутый CB не вытесняется, пока есть другие кандидаты: "c" - самый давний, но разомкнут
+	m.ReportFailure("c")
+	m.AllowRequest("a")
+	m.AllowRequest("d")
+	m.AddCircuitBreaker("e", cfg)
+	if m.GetCircuitBreaker("c") == nil {
+		t.Error("Expected open breaker 'c' to be kept")
+	}
+	if m.GetCircuitBreaker("a") != nil {
+		t.Error("Expected breaker 'a' to be evicted instead of open 'c'")
+	}
+
+	if n := len(m.GetCircuitBreakerStats()); n != 3 {
+		t.Errorf("Expected 3 breakers under the cap, got %d", n)
+	}
+
+	// Замена существующего CB не вытесняет другие
+	m.AddCircuitBreaker("e", cfg)
+	if n := len(m.GetCircuitBreakerStats()); n != 3 {
+		t.Errorf("Expected 3 breakers after replace, got %d", n)
+	}
+}
+
+func TestAutoCreate(t *testing.T) {
+	m := NewCBManager()
+	m.SetDefaultConfig(CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Minute})
+
+	// AutoCreate выключен: запрос пропускается без создания CB
+	if allowed, state := m.AllowRequest("dynamic"); !allowed || state != notConfigured {
+		t.Errorf("Expected allow-through for unknown server, got %t/%s", allowed, state)
+	}
+	if len(m.GetCircuitBreakerStats()) != 0 {
+		t.Fatal("Expected no breakers with AutoCreate disabled")
+	}
+
+	// AutoCreate включен: CB создается при первом обращении
+	m.SetAutoCreate(true)
+	if allowed, state := m.AllowRequest("dynamic"); !allowed || state != stateClosed {
+		t.Errorf("Expected allowed request in closed state, got %t/%s", allowed, state)
+	}
+	if _, ok := m.GetCircuitBreakerStats()["dynamic"]; !ok {
+		t.Fatal("Expected auto-created breaker in stats")
+	}
+
+	// Создается с конфигурацией по умолчанию
+	m.ReportFailure("dynamic")
+	if state := m.GetCircuitBreakerState("dynamic"); state != "open" {
+		t.Errorf("Expected 'open' with default FailureThreshold 1, got '%s'", state)
+	}
+
+	// Лимит SetMaxBreakers учитывается
+	m.SetMaxBreakers(1)
+	m.AllowRequest("another")
+	if n := len(m.GetCircuitBreakerStats()); n != 1 {
+		t.Errorf("Expected 1 breaker under the cap, got %d", n)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	servers := []string{"server1", "server2", "server3"}
+	m := NewCBManager()
+	m.InitCircuitBreakers(servers, CircuitBreakerConf{FailureThreshold: 5, RecoveryTimeout: 10 * time.Millisecond, HalfOpenPrc: 50})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				srv := servers[i%len(servers)]
+				if allowed, _ := m.AllowRequest(srv); allowed {
+					m.ReportFailure(srv)
+				}
+			}
+		}(i)
+	}
+
+	for iter := 0; iter < 100; iter++ {
+		visited := make(map[string]bool)
+		m.ForEach(func(name string, s Stats) {
+			// Обращение к менеджеру внутри fn не должно блокироваться
+			_ = m.GetCircuitBreakerState(name)
+			if s["name"] != name {
+				t.Errorf("stats name = %v, want %s", s["name"], name)
+			}
+			visited[name] = true
+		})
+		if len(visited) != len(servers) {
+			t.Fatalf("Expected %d visited breakers, got %d", len(servers), len(visited))
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestRetryAfter(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  10 * time.Second,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+
+	// Замкнутый и ненастроенный CB
+	if _, ok := m.RetryAfter("test-server"); ok {
+		t.Error("Expected no retry-after for closed CB")
+	}
+	if _, ok := m.RetryAfter("unknown-server"); ok {
+		t.Error("Expected no retry-after for unknown server")
+	}
+
+	// Для разомкнутого CB время уменьшается по мере ожидания
+	m.ReportFailure("test-server")
+	if d, ok := m.RetryAfter("test-server"); !ok || d != 10*time.Second {
+		t.Errorf("RetryAfter() = %v/%t, want 10s/true", d, ok)
+	}
+	clock.Advance(4 * time.Second)
+	if d, _ := m.RetryAfter("test-server"); d != 6*time.Second {
+		t.Errorf("RetryAfter() = %v, want 6s", d)
+	}
+
+	// По истечении таймаута - ноль, CB готов к half-open
+	clock.Advance(7 * time.Second)
+	if d, ok := m.RetryAfter("test-server"); !ok || d != 0 {
+		t.Errorf("RetryAfter() = %v/%t, want 0/true", d, ok)
+	}
+	m.AllowRequest("test-server")
+	if _, ok := m.RetryAfter("test-server"); ok {
+		t.Error("Expected no retry-after for half-open CB")
+	}
+}
+
+func TestHealthSummary(t *testing.T) {
+	servers := []string{"server1", "server2", "server3", "server4"}
+	m := NewCBManager()
+	m.InitCircuitBreakers(servers, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Minute})
+
+	// Все CB замкнуты
+	h := m.HealthSummary()
+	if !h.Healthy || h.Total != 4 || h.Closed != 4 || h.Open != 0 || h.HalfOpen != 0 {
+		t.Errorf("Unexpected summary for all-closed: %+v", h)
+	}
+
+	// Один разомкнутый CB делает систему нездоровой
+	m.ReportFailure("server1")
+	h = m.HealthSummary()
+	if h.Healthy || h.Open != 1 || h.Closed != 3 {
+		t.Errorf("Unexpected summary with one open: %+v", h)
+	}
+
+	// Допустимая доля разомкнутых CB
+	m.SetMaxOpenFraction(0.25)
+	if h = m.HealthSummary(); !h.Healthy {
+		t.Errorf("Expected healthy with 25%% open and 25%% allowed: %+v", h)
+	}
+	m.ReportFailure("server2")
+	if h = m.HealthSummary(); h.Healthy {
+		t.Errorf("Expected unhealthy with 50%% open and 25%% allowed: %+v", h)
+	}
+
+	// Пустой менеджер здоров
+	if h = NewCBManager().HealthSummary(); !h.Healthy || h.Total != 0 {
+		t.Errorf("Unexpected summary for empty manager: %+v", h)
+	}
+}
+
+func TestStrictServers(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"payments"}, CircuitBreakerConf{})
+
+	// Нестрогий режим: опечатка в имени сервера незаметна
+	if allowed, _ := m.AllowRequest("paymnets"); !allowed {
+		t.Error("Expected allow-through for unknown server in lenient mode")
+	}
+	if err := m.ReportFailure("paymnets"); err != nil {
+		t.Errorf("Expected nil error in lenient mode, got %v", err)
+	}
+
+	// Строгий режим: опечатка обнаруживается
+	m.SetStrictServers(true)
+	if allowed, state := m.AllowRequest("paymnets"); allowed || state != notConfigured {
+		t.Errorf("Expected denied request for unknown server in strict mode, got %t/%s", allowed, state)
+	}
+	if err := m.ReportSuccess("paymnets"); !errors.Is(err, ErrBreakerNotFound) {
+		t.Errorf("ReportSuccess() error = %v, want ErrBreakerNotFound", err)
+	}
+	if err := m.ReportFailure("paymnets"); !errors.Is(err, ErrBreakerNotFound) {
+		t.Errorf("ReportFailure() error = %v, want ErrBreakerNotFound", err)
+	}
+	if err := m.ReportResult("paymnets", nil); !errors.Is(err, ErrBreakerNotFound) {
+		t.Errorf("ReportResult() error = %v, want ErrBreakerNotFound", err)
+	}
+	if _, err := m.Acquire("paymnets"); !errors.Is(err, ErrBreakerNotFound) {
+		t.Errorf("Acquire() error = %v, want ErrBreakerNotFound", err)
+	}
+
+	// Настроенный сервер работает как обычно
+	if allowed, _ := m.AllowRequest("payments"); !allowed {
+		t.Error("Expected allowed request for configured server in strict mode")
+	}
+	if err := m.ReportSuccess("payments"); err != nil {
+		t.Errorf("Expected nil error for configured server, got %v", err)
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	cfg := CircuitBreakerConf{FailureThreshold: 5, RecoveryTimeout: time.Minute}
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, cfg)
+
+	if err := m.UpdateConfig("unknown-server", cfg); !errors.Is(err, ErrBreakerNotFound) {
+		t.Errorf("UpdateConfig() error = %v, want ErrBreakerNotFound", err)
+	}
+
+	// Снижение порога ниже накопленного счетчика сразу размыкает CB
+	m.ReportFailure("test-server")
+	m.ReportFailure("test-server")
+	m.ReportFailure("test-server")
+	cfg.FailureThreshold = 3
+	if err := m.UpdateConfig("test-server", cfg); err != nil {
+		t.Fatalf("UpdateConfig() error = %v", err)
+	}
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Fatalf("Expected 'open' after lowering threshold, got '%s'", state)
+	}
+	if d, ok := m.RetryAfter("test-server"); !ok || d <= 0 {
+		t.Errorf("Expected opened_at to be set on trip, got %v/%t", d, ok)
+	}
+
+	// Повышение порога не замыкает разомкнутый CB
+	cfg.FailureThreshold = 10
+	m.UpdateConfig("test-server", cfg)
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected 'open' after raising threshold, got '%s'", state)
+	}
+	if got, _ := m.GetConfig("test-server"); got.FailureThreshold != 10 {
+		t.Errorf("Expected FailureThreshold 10, got %d", got.FailureThreshold)
+	}
+}
+
+func TestReportFailureWeighted(t *testing.T) {
+	cfg := CircuitBreakerConf{FailureThreshold: 5, RecoveryTimeout: time.Minute}
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"light", "heavy"}, cfg)
+
+	// Легкая неудача не размыкает CB
+	m.ReportFailureWeighted("light", 0.5)
+	if state := m.GetCircuitBreakerState("light"); state != "closed" {
+		t.Errorf("Expected 'closed' after light failure, got '%s'", state)
+	}
+
+	// Одна тяжелая неудача размыкает CB
+	m.ReportFailureWeighted("heavy", 5)
+	if state := m.GetCircuitBreakerState("heavy"); state != "open" {
+		t.Errorf("Expected 'open' after heavy failure, got '%s'", state)
+	}
+
+	// Обычные неудачи имеют вес 1
+	for i := 0; i < 4; i++ {
+		m.ReportFailure("light")
+	}
+	if state := m.GetCircuitBreakerState("light"); state != "closed" {
+		t.Errorf("Expected 'closed' with score 4.5, got '%s'", state)
+	}
+	m.ReportFailureWeighted("light", 0.5)
+	if state := m.GetCircuitBreakerState("light"); state != "open" {
+		t.Errorf("Expected 'open' with score 5, got '%s'", state)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold:    1,
+		RecoveryTimeout:     time.Second,
+		HalfOpenSingleProbe: true,
+		Clock:               clock,
+	})
+
+	if state := m.Peek("unknown-server"); state != notConfigured {
+		t.Errorf("Expected notConfigured for unknown server, got %s", state)
+	}
+	if state := m.Peek("test-server"); state != stateClosed {
+		t.Errorf("Expected closed, got %s", state)
+	}
+
+	m.ReportFailure("test-server")
+	if state := m.Peek("test-server"); state != stateOpen {
+		t.Errorf("Expected open, got %s", state)
+	}
+
+	// После таймаута Peek сообщает half-open, но не выполняет переход
+	clock.Advance(time.Second)
+	for i := 0; i < 10; i++ {
+		if state := m.Peek("test-server"); state != stateHalfOpen {
+			t.Fatalf("Expected half-open from Peek, got %s", state)
+		}
+	}
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected Peek not to change state, got '%s'", state)
+	}
+
+	// Единственная проба не израсходована
+	if allowed, _ := m.AllowRequest("test-server"); !allowed {
+		t.Error("Expected probe to be available after Peek")
+	}
+}
+
+func TestSetKeyNormalizer(t *testing.T) {
+	m := NewCBManager()
+	m.SetKeyNormalizer(func(s string) string { return strings.TrimRight(s, "/") })
+	m.InitCircuitBreakers([]string{"http://x/"}, CircuitBreakerConf{FailureThreshold: 2})
+
+	// Разные записи одного URL попадают в один CB
+	m.ReportFailure("http://x")
+	m.ReportFailure("http://x//")
+	if state := m.GetCircuitBreakerState("http://x/"); state != "open" {
+		t.Errorf("Expected shared CB to be 'open', got '%s'", state)
+	}
+	if allowed, _ := m.AllowRequest("http://x"); allowed {
+		t.Error("Expected request to be denied via normalized key")
+	}
+	if stats := m.GetCircuitBreakerStats(); len(stats) != 1 || stats["http://x"] == nil {
+		t.Errorf("Expected single CB under normalized key, got %v", stats)
+	}
+
+	if err := m.AddCircuitBreaker("http://x///", CircuitBreakerConf{}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := m.GetCircuitBreakerStats(); len(stats) != 1 {
+		t.Errorf("Expected AddCircuitBreaker to replace normalized CB, got %d breakers", len(stats))
+	}
+}
+
+func TestSetKeyNormalizer_DefaultIdentity(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"http://x", "http://x/"}, CircuitBreakerConf{})
+	if stats := m.GetCircuitBreakerStats(); len(stats) != 2 {
+		t.Errorf("Expected keys to be left unchanged by default, got %d breakers", len(stats))
+	}
+}
+
+func TestReportFailureCategory(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 100})
+
+	m.ReportFailureCategory("test-server", "timeout")
+	m.ReportFailureCategory("test-server", "timeout")
+	m.ReportFailureCategory("test-server", "5xx")
+	m.ReportFailure("test-server")
+	m.ReportFailureCategory("test-server", "")
+
+	stats := m.GetCircuitBreakerStats()["test-server"].(map[string]any)
+	got := stats["failures_by_category"].(map[string]uint64)
+	want := map[string]uint64{"timeout": 2, "5xx": 1, FailureUnspecified: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected failures_by_category %v, got %v", want, got)
+	}
+	// Категоризированные неудачи учитываются наравне с обычными
+	if count := stats["failure_count"].(int); count != 5 {
+		t.Errorf("Expected failure_count 5, got %d", count)
+	}
+
+	if err := m.ReportFailureCategory("unknown-server", "timeout"); err != nil {
+		t.Errorf("Expected nil error for unknown server in non-strict mode, got %v", err)
+	}
+}
+
+func TestDisableAll(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Hour,
+	})
+	m.ReportFailure("test-server")
+
+	m.DisableAll()
+	for i := 0; i < 10; i++ {
+		if allowed, state := m.AllowRequest("test-server"); !allowed || state != stateOpen {
+			t.Fatalf("Expected disabled manager to allow request to open CB, got %v (%s)", allowed, state)
+		}
+	}
+
+	// Учет продолжается: ошибки по-прежнему фиксируются
+	m.ReportFailure("test-server")
+	stats := m.GetCircuitBreakerStats()["test-server"].(map[string]any)
+	if stats["failures_by_category"].(map[string]uint64)[FailureUnspecified] != 2 {
+		t.Errorf("Expected failures to be accounted while disabled, got %v", stats["failures_by_category"])
+	}
+
+	m.EnableAll()
+	if allowed, _ := m.AllowRequest("test-server"); allowed {
+		t.Error("Expected re-enabled manager to block open CB")
+	}
+}
+
+func TestTriggerProbe(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Hour,
+		HalfOpenPrc:      100,
+	})
+
+	// Для closed ничего не меняется
+	m.TriggerProbe("test-server")
+	if state := m.GetCircuitBreakerState("test-server"); state != "closed" {
+		t.Fatalf("Expected TriggerProbe to be no-op for closed, got '%s'", state)
+	}
+
+	m.ReportFailure("test-server")
+	if allowed, _ := m.AllowRequest("test-server"); allowed {
+		t.Fatal("Expected open CB to deny request")
+	}
+
+	// Проба разрешается задолго до истечения RecoveryTimeout
+	if err := m.TriggerProbe("test-server"); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, state := m.AllowRequest("test-server"); !allowed || state != stateHalfOpen {
+		t.Errorf("Expected probe to be admitted in half-open, got %v (%s)", allowed, state)
+	}
+
+	if err := m.TriggerProbe("unknown-server"); !errors.Is(err, ErrBreakerNotFound) {
+		t.Errorf("Expected ErrBreakerNotFound, got %v", err)
+	}
+}
+
+func TestNewCBManagerWithParent(t *testing.T) {
+	parent := NewCBManager()
+	parent.InitCircuitBreakers([]string{"shared", "overridden"}, CircuitBreakerConf{FailureThreshold: 1})
+
+	child := NewCBManagerWithParent(parent)
+	child.InitCircuitBreakers([]string{"overridden"}, CircuitBreakerConf{FailureThreshold: 5})
+
+	// Промах в дочернем менеджере разрешается через родителя
+	child.ReportFailure("shared")
+	if state := parent.GetCircuitBreakerState("shared"); state != "open" {
+		t.Errorf("Expected parent CB to be tripped via child, got '%s'", state)
+	}
+	if allowed, _ := child.AllowRequest("shared"); allowed {
+		t.Error("Expected child to deny request via open parent CB")
+	}
+
+	// CB дочернего менеджера перекрывает родительский
+	child.ReportFailure("overridden")
+	if state := child.GetCircuitBreakerState("overridden"); state != "closed" {
+		t.Errorf("Expected child override to stay closed, got '%s'", state)
+	}
+	if state := parent.GetCircuitBreakerState("overridden"); state != "closed" {
+		t.Errorf("Expected parent CB to be untouched by child override, got '%s'", state)
+	}
+
+	// Неизвестный обоим сервер
+	if allowed, state := child.AllowRequest("unknown"); !allowed || state != notConfigured {
+		t.Errorf("Expected unknown server to be allowed, got %v (%s)", allowed, state)
+	}
+}
+
+func TestDump(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"b-server", "a-server"}, CircuitBreakerConf{FailureThreshold: 1, Clock: clock})
+	m.ReportFailure("b-server")
+
+	cb := m.GetCircuitBreaker("b-server")
+	s := cb.StatsString()
+	// Ключи упорядочены по алфавиту
+	prev := -1
+	for _, key := range []string{"draining=false", "failure_count=1", "name=b-server", "state=open", "transaction=1"} {
+		i := strings.Index(s, key)
+		if i < 0 {
+			t.Fatalf("Expected %q in %q", key, s)
+		}
+		if i < prev {
+			t.Errorf("Expected %q to follow previous keys in %q", key, s)
+		}
+		prev = i
+	}
+	if strings.Contains(s, "config=") {
+		t.Errorf("Expected config to be omitted, got %q", s)
+	}
+
+	dump := m.Dump()
+	lines := strings.Split(strings.TrimSuffix(dump, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %q", len(lines), dump)
+	}
+	if !strings.HasPrefix(lines[0], "a-server: ") || lines[1] != "b-server: "+s {
+		t.Errorf("Expected sorted dump, got %q", dump)
+	}
+	if again := m.Dump(); again != dump {
+		t.Errorf("Expected stable dump, got %q and %q", dump, again)
+	}
+}
+
+func TestInitSharedBreaker(t *testing.T) {
+	m := NewCBManager()
+	replicas := []string{"http://replica-1", "http://replica-2"}
+	if err := m.InitSharedBreaker(replicas, "service", CircuitBreakerConf{FailureThreshold: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Неудачи на разных репликах учитываются в общем CB
+	m.ReportFailure("http://replica-1")
+	m.ReportFailure("http://replica-2")
+	if allowed, _ := m.AllowRequest("http://replica-1"); allowed {
+		t.Error("Expected shared CB to deny request via replica-1")
+	}
+	if allowed, _ := m.AllowRequest("http://replica-2"); allowed {
+		t.Error("Expected shared CB to deny request via replica-2")
+	}
+	if m.GetCircuitBreaker("http://replica-1") != m.GetCircuitBreaker("http://replica-2") {
+		t.Error("Expected replicas to share one CB instance")
+	}
+
+	if err := m.InitSharedBreaker(nil, "service", CircuitBreakerConf{}); err == nil {
+		t.Error("Expected error for empty names")
+	}
+	if err := m.InitSharedBreaker(replicas, "", CircuitBreakerConf{}); err == nil {
+		t.Error("Expected error for empty key")
+	}
+}
+
+func TestFastStats(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 2, RecoveryTimeout: time.Hour})
+
+	m.ReportFailure("test-server")
+	m.ReportFailure("test-server")
+	m.AllowRequest("test-server")
+
+	fast := m.FastStats()["test-server"].(Stats)
+	full := m.GetCircuitBreakerStats()["test-server"].(Stats)
+	for _, key := range []string{"name", "state", "failure_count", "success_count", "transaction", "total_rejected", "in_flight"} {
+		if fast[key] != full[key] {
+			t.Errorf("FastStats[%s] = %v, want %v", key, fast[key], full[key])
+		}
+	}
+}
+
+// benchmarkStatsPolling измеряет пропускную способность запросов при постоянном опросе статистики
+func benchmarkStatsPolling(b *testing.B, poll func(m *CBManager)) {
+	servers := make([]string, 100)
+	for i := range servers {
+		servers[i] = fmt.Sprintf("server-%d", i)
+	}
+	m := NewCBManager()
+	m.InitCircuitBreakers(servers, CircuitBreakerConf{FailureThreshold: 1 << 30})
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				poll(m)
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			srv := servers[i%len(servers)]
+			if allowed, _ := m.AllowRequest(srv); allowed {
+				m.ReportSuccess(srv)
+			}
+			i++
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+func BenchmarkTrafficWithStatsPolling(b *testing.B) {
+	benchmarkStatsPolling(b, func(m *CBManager) { m.GetCircuitBreakerStats() })
+}
+
+func BenchmarkTrafficWithFastStatsPolling(b *testing.B) {
+	benchmarkStatsPolling(b, func(m *CBManager) { m.FastStats() })
+}
+
+func TestReportTimeout(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 10,
+		TimeoutThreshold: 3,
+	})
+	stats := func() map[string]any { return m.GetCircuitBreakerStats()["test-server"].(map[string]any) }
+
+	// Обычные ошибки не влияют на счетчик таймаутов
+	m.ReportFailure("test-server")
+	m.ReportTimeout("test-server")
+	m.ReportTimeout("test-server")
+	s := stats()
+	if s["timeout_count"].(int) != 2 || s["failure_count"].(int) != 3 {
+		t.Errorf("Expected timeout_count 2 and failure_count 3, got %v and %v", s["timeout_count"], s["failure_count"])
+	}
+	if s["failures_by_category"].(map[string]uint64)[FailureTimeout] != 2 {
+		t.Errorf("Expected 2 timeouts by category, got %v", s["failures_by_category"])
+	}
+
+	// Порог таймаутов размыкает CB раньше порога ошибок
+	m.ReportTimeout("test-server")
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected 'open' after timeout threshold, got '%s'", state)
+	}
+}
+
+func TestReportTimeout_Weight(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 4,
+		TimeoutWeight:    2,
+	})
+
+	// Без TimeoutThreshold таймауты учитываются с весом среди обычных ошибок
+	m.ReportTimeout("test-server")
+	if state := m.GetCircuitBreakerState("test-server"); state != "closed" {
+		t.Fatalf("Expected 'closed', got '%s'", state)
+	}
+	m.ReportTimeout("test-server")
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected weighted timeouts to trip CB, got '%s'", state)
+	}
+}
+
+func TestRelaxFor(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 2, Clock: clock})
+
+	if err := m.RelaxFor("test-server", 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Во время ослабления порог равен 6
+	for i := 0; i < 5; i++ {
+		m.ReportFailure("test-server")
+	}
+	if state := m.GetCircuitBreakerState("test-server"); state != "closed" {
+		t.Fatalf("Expected relaxed CB to stay closed, got '%s'", state)
+	}
+	if cfg, _ := m.GetConfig("test-server"); cfg.FailureThreshold != 2 {
+		t.Errorf("Expected configured threshold to stay 2, got %d", cfg.FailureThreshold)
+	}
+
+	// После окончания окна действует исходный порог
+	clock.Advance(time.Minute)
+	m.ReportFailure("test-server")
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected threshold restored after window, got '%s'", state)
+	}
+
+	if err := m.RelaxFor("test-server", 0, time.Minute); err == nil {
+		t.Error("Expected error for non-positive multiplier")
+	}
+	if err := m.RelaxFor("unknown", 2, time.Minute); !errors.Is(err, ErrBreakerNotFound) {
+		t.Errorf("Expected ErrBreakerNotFound, got %v", err)
+	}
+}
+
+func TestAllowRequestPriority(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold:      1,
+		RecoveryTimeout:       time.Second,
+		SuccessThreshold:      10,
+		HalfOpenPrc:           100,
+		HalfOpenMaxConcurrent: 2,
+		Clock:                 clock,
+	})
+	m.ReportFailure("test-server")
+	clock.Advance(time.Second)
+
+	// Фоновым запросам доступна только половина бюджета проб
+	if allowed, state := m.AllowRequestPriority("test-server", PriorityLow); !allowed || state != StateHalfOpen {
+		t.Fatalf("Expected first low-priority request admitted in half-open, got %v/%s", allowed, state)
+	}
+	if allowed, _ := m.AllowRequestPriority("test-server", PriorityLow); allowed {
+		t.Error("Expected low-priority request to be denied under tight budget")
+	}
+
+	// Оставшийся слот достается важному запросу
+	if allowed, _ := m.AllowRequestPriority("test-server", PriorityNormal+1); !allowed {
+		t.Error("Expected high-priority request to be admitted")
+	}
+	if allowed, _ := m.AllowRequestPriority("test-server", PriorityNormal+1); allowed {
+		t.Error("Expected request to be denied when all slots are busy")
+	}
+}
+
+func TestAllowRequestPriority_SingleSlot(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold:      1,
+		RecoveryTimeout:       time.Second,
+		SuccessThreshold:      1,
+		HalfOpenPrc:           100,
+		HalfOpenMaxConcurrent: 1,
+		Clock:                 clock,
+	})
+	m.ReportFailure("test-server")
+	clock.Advance(time.Second)
+
+	// При единственном слоте фоновый запрос все равно может стать пробой
+	if allowed, state := m.AllowRequestPriority("test-server", PriorityLow); !allowed || state != StateHalfOpen {
+		t.Fatalf("Expected low-priority probe with a single slot, got %v/%s", allowed, state)
+	}
+	if allowed, _ := m.AllowRequestPriority("test-server", PriorityNormal); allowed {
+		t.Error("Expected request to be denied while the slot is busy")
+	}
+	m.ReportSuccess("test-server")
+	if state := m.Peek("test-server"); state != StateClosed {
+		t.Errorf("Expected closed after low-priority probe succeeded, got %s", state)
+	}
+}
+
+func TestSetOnBreakerCreated(t *testing.T) {
+	m := NewCBManager()
+	m.SetAutoCreate(true)
+
+	var calls atomic.Int32
+	m.SetOnBreakerCreated(func(serverURL string) {
+		if serverURL != "dynamic" {
+			t.Errorf("Expected 'dynamic', got %q", serverURL)
+		}
+		// Обработчик вызывается вне блокировки менеджера
+		if m.GetCircuitBreaker(serverURL) == nil {
+			t.Error("Expected breaker to be registered before callback")
+		}
+		calls.Add(1)
+	})
+
+	// Конкурентное первое обращение создает CB ровно один раз
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.AllowRequest("dynamic")
+		}()
+	}
+	wg.Wait()
+	m.AllowRequest("dynamic")
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected callback to fire once, got %d", n)
+	}
+}
+
+func TestOpenBreakersReport(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"a", "b", "c"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Hour,
+		Clock:            clock,
+	})
+
+	if report := m.OpenBreakersReport(); len(report) != 0 {
+		t.Fatalf("Expected empty report, got %v", report)
+	}
+
+	start := clock.Now()
+	m.ReportFailure("c")
+	clock.Advance(time.Minute)
+	m.ReportFailure("a")
+	clock.Advance(time.Minute)
+
+	want := []OpenBreaker{
+		{Name: "a", OpenSince: start.Add(time.Minute), Downtime: time.Minute},
+		{Name: "c", OpenSince: start, Downtime: 2 * time.Minute},
+	}
+	if report := m.OpenBreakersReport(); !reflect.DeepEqual(report, want) {
+		t.Errorf("Expected %v, got %v", want, report)
+	}
+}
+
+func TestGetCircuitBreakerStateDetailed(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  30 * time.Second,
+		Clock:            clock,
+	})
+
+	if state, wait := m.GetCircuitBreakerStateDetailed("test-server"); state != "closed" || wait != 0 {
+		t.Errorf("Expected closed with no wait, got %s/%v", state, wait)
+	}
+
+	m.ReportFailure("test-server")
+	clock.Advance(18 * time.Second)
+	if state, wait := m.GetCircuitBreakerStateDetailed("test-server"); state != "open" || wait != 12*time.Second {
+		t.Errorf("Expected open with 12s remaining, got %s/%v", state, wait)
+	}
+
+	// После истечения таймаута ожидание не становится отрицательным
+	clock.Advance(time.Minute)
+	if state, wait := m.GetCircuitBreakerStateDetailed("test-server"); state != "open" || wait != 0 {
+		t.Errorf("Expected open with zero wait, got %s/%v", state, wait)
+	}
+
+	if state, wait := m.GetCircuitBreakerStateDetailed("unknown"); state != "disabled" || wait != 0 {
+		t.Errorf("Expected disabled for unknown server, got %s/%v", state, wait)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"busy"}, CircuitBreakerConf{})
+	ticket, _ := m.Acquire("busy")
+	defer ticket.Success()
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"add empty name", m.AddCircuitBreaker("", CircuitBreakerConf{}), ErrInvalidConfig},
+		{"add invalid initial state", m.AddCircuitBreaker("srv", CircuitBreakerConf{InitialState: StateHalfOpen}), ErrInvalidConfig},
+		{"shared without names", m.InitSharedBreaker(nil, "key", CircuitBreakerConf{}), ErrInvalidConfig},
+		{"update unknown", m.UpdateConfig("unknown", CircuitBreakerConf{}), ErrBreakerNotFound},
+		{"remove unknown", m.RemoveCircuitBreaker("unknown"), ErrBreakerNotFound},
+		{"remove busy", m.RemoveCircuitBreaker("busy"), ErrBreakerBusy},
+		{"relax invalid multiplier", m.RelaxFor("busy", -1, time.Second), ErrInvalidConfig},
+		{"link to itself", m.Link("busy", "busy"), ErrInvalidConfig},
+		{"empty group", m.NewGroup("g", nil), ErrInvalidConfig},
+		{"unknown group", m.SetGroupConf("unknown", GroupConf{}), ErrGroupNotFound},
+		{"force invalid state", m.GetCircuitBreaker("busy").ForceState(StateNotConfigured), ErrInvalidConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, tt.err)
+			}
+		})
+	}
+
+	// Ошибки конструктора в InitCircuitBreakers также типизированы
+	if errs := m.InitCircuitBreakers([]string{""}, CircuitBreakerConf{}); len(errs) != 1 || !errors.Is(errs[0], ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig from InitCircuitBreakers, got %v", errs)
+	}
+}
+
+func TestStateSnapshotDiff(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"a", "b", "c", "d"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Minute,
+		Clock:            clock,
+	})
+	m.ReportFailure("c")
+
+	prev := m.StateSnapshot()
+	want := map[string]State{"a": StateClosed, "b": StateClosed, "c": StateOpen, "d": StateClosed}
+	if !reflect.DeepEqual(prev, want) {
+		t.Fatalf("StateSnapshot() = %v, want %v", prev, want)
+	}
+	if diff := m.Diff(prev); len(diff) != 0 {
+		t.Errorf("Expected empty diff without changes, got %v", diff)
+	}
+
+	m.ReportFailure("a")
+	m.Reset("c")
+	m.RemoveCircuitBreaker("d")
+	m.AddCircuitBreaker("e", CircuitBreakerConf{Clock: clock})
+
+	wantDiff := map[string][2]State{
+		"a": {StateClosed, StateOpen},
+		"c": {StateOpen, StateClosed},
+		"d": {StateClosed, StateNotConfigured},
+		"e": {StateNotConfigured, StateClosed},
+	}
+	if diff := m.Diff(prev); !reflect.DeepEqual(diff, wantDiff) {
+		t.Errorf("Diff() = %v, want %v", diff, wantDiff)
+	}
+}
+
+func TestAddCircuitBreakerWithPolicy(t *testing.T) {
+	cfg := CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour}
+	newManager := func() (*CBManager, *CircuitBreaker) {
+		m := NewCBManager()
+		m.AddCircuitBreaker("srv", cfg)
+		m.ReportFailure("srv")
+		return m, m.GetCircuitBreaker("srv")
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		m, old := newManager()
+		if _, err := m.AddCircuitBreakerWithPolicy("srv", cfg, CollisionFail); !errors.Is(err, ErrBreakerExists) {
+			t.Errorf("Expected ErrBreakerExists, got %v", err)
+		}
+		if m.GetCircuitBreaker("srv") != old {
+			t.Error("Expected existing breaker to stay registered")
+		}
+		if _, err := m.AddCircuitBreakerWithPolicy("other", cfg, CollisionFail); err != nil {
+			t.Errorf("Expected new key to be added, got %v", err)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		m, old := newManager()
+		cb, err := m.AddCircuitBreakerWithPolicy("srv", cfg, CollisionReplace)
+		if err != nil || cb == old || m.GetCircuitBreaker("srv") != cb {
+			t.Fatalf("Expected breaker to be replaced, got %v", err)
+		}
+		if state := m.Peek("srv"); state != StateClosed {
+			t.Errorf("Expected fresh closed breaker, got %s", state)
+		}
+	})
+
+	t.Run("keep", func(t *testing.T) {
+		m, old := newManager()
+		cb, err := m.AddCircuitBreakerWithPolicy("srv", CircuitBreakerConf{FailureThreshold: 10}, CollisionKeep)
+		if err != nil || cb != old {
+			t.Fatalf("Expected existing breaker to be returned, got %v", err)
+		}
+		// Состояние и конфигурация существующего CB сохраняются
+		if state := m.Peek("srv"); state != StateOpen {
+			t.Errorf("Expected state to be preserved, got %s", state)
+		}
+		if got, _ := m.GetConfig("srv"); got.FailureThreshold != 1 {
+			t.Errorf("Expected config to be preserved, got FailureThreshold %d", got.FailureThreshold)
+		}
+	})
+}
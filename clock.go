@@ -0,0 +1,52 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoarseClock - Clock с кэшированным временем, которое фоновая горутина обновляет раз
+// в resolution. Экономит вызовы time.Now() на горячем пути (проверка таймаута
+// восстановления в open) ценой погрешности до resolution. После использования часы
+// нужно остановить через Stop; остановленные часы возвращают время последнего обновления.
+type CoarseClock struct {
+	now  atomic.Pointer[time.Time]
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewCoarseClock создает и запускает кэшированные часы с шагом обновления resolution
+// (при resolution <= 0 - 10 мс)
+func NewCoarseClock(resolution time.Duration) *CoarseClock {
+	if resolution <= 0 {
+		resolution = 10 * time.Millisecond
+	}
+	c := &CoarseClock{stop: make(chan struct{})}
+	now := time.Now()
+	c.now.Store(&now)
+
+	go func() {
+		ticker := time.NewTicker(resolution)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case t := <-ticker.C:
+				c.now.Store(&t)
+			}
+		}
+	}()
+	return c
+}
+
+// Now возвращает кэшированное время
+func (c *CoarseClock) Now() time.Time {
+	return *c.now.Load()
+}
+
+// Stop останавливает обновление времени. Повторные вызовы ничего не делают.
+func (c *CoarseClock) Stop() {
+	c.once.Do(func() { close(c.stop) })
+}
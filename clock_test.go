@@ -0,0 +1,82 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoarseClock(t *testing.T) {
+	clock := NewCoarseClock(time.Millisecond)
+	defer clock.Stop()
+
+	start := clock.Now()
+	deadline := time.Now().Add(time.Second)
+	for !clock.Now().After(start) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected coarse clock to advance")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Остановленные часы больше не обновляются
+	clock.Stop()
+	clock.Stop()
+	time.Sleep(5 * time.Millisecond)
+	frozen := clock.Now()
+	time.Sleep(5 * time.Millisecond)
+	if now := clock.Now(); !now.Equal(frozen) {
+		t.Errorf("Expected stopped clock to stay at %v, got %v", frozen, now)
+	}
+}
+
+func TestCoarseClock_Recovery(t *testing.T) {
+	clock := NewCoarseClock(time.Millisecond)
+	defer clock.Stop()
+
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  20 * time.Millisecond,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+	cb.failure()
+	if allowed, _ := cb.allow(); allowed {
+		t.Fatal("Expected request to be rejected in open state")
+	}
+
+	// Таймаут восстановления отсчитывается по кэшированному времени
+	time.Sleep(50 * time.Millisecond)
+	if allowed, state := cb.allow(); !allowed || state != StateHalfOpen {
+		t.Errorf("Expected probe in half-open, got %v/%s", allowed, state)
+	}
+}
+
+// Быстрый путь open: сравнение с таймаутом восстановления на каждом запросе
+func BenchmarkOpenFastPath(b *testing.B) {
+	coarse := NewCoarseClock(10 * time.Millisecond)
+	defer coarse.Stop()
+
+	for _, tt := range []struct {
+		name  string
+		clock Clock
+	}{
+		{"Precise", realClock{}},
+		{"Coarse", coarse},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			cb, _ := new("test", CircuitBreakerConf{
+				FailureThreshold: 1,
+				RecoveryTimeout:  time.Hour,
+				Clock:            tt.clock,
+			})
+			cb.failure()
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					cb.allow()
+				}
+			})
+		})
+	}
+}
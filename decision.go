@@ -0,0 +1,63 @@
+package circuitbreaker
+
+// DecisionReason объясняет решение AllowRequestDetailed
+type DecisionReason int
+
+const (
+	ReasonClosed              DecisionReason = iota // пропущен: CB замкнут
+	ReasonHalfOpenAdmitted                          // пропущен как проба в half-open
+	ReasonNotConfigured                             // пропущен: CB для сервера не настроен
+	ReasonDisabled                                  // пропущен: блокировка отключена DisableAll
+	ReasonOpen                                      // отклонен: CB разомкнут
+	ReasonRecoveryExhausted                         // отклонен: CB разомкнут до Reset (исчерпан MaxRecoveryAttempts)
+	ReasonHalfOpenDenied                            // отклонен: не отобран в half-open
+	ReasonProbeInFlight                             // отклонен: ожидается результат одиночной пробы
+	ReasonDraining                                  // отклонен: CB выводится из эксплуатации (Drain)
+	ReasonGroupOpen                                 // отклонен: разомкнута группа, в которую входит CB
+	ReasonStrictNotConfigured                       // отклонен: CB не настроен, включен строгий режим
+	ReasonHalfOpenBusy                              // отклонен: заняты слоты half-open (HalfOpenMaxConcurrent)
+	ReasonLoadShed                                  // отклонен: адаптивный сброс нагрузки в closed (LoadShedStart)
+	ReasonForcedOpen                                // отклонен: CB разомкнут до Reset (AlwaysOpen)
+	ReasonRateLimited                               // отклонен: превышен MaxRPS
+	ReasonBulkheadFull                              // отклонен: достигнут лимит MaxConcurrent
+)
+
+// String возвращает текстовое представление причины для логов
+func (r DecisionReason) String() string {
+	switch r {
+	case ReasonClosed:
+		return "closed"
+	case ReasonHalfOpenAdmitted:
+		return "half_open_admitted"
+	case ReasonNotConfigured:
+		return "not_configured"
+	case ReasonDisabled:
+		return "disabled"
+	case ReasonOpen:
+		return "open"
+	case ReasonRecoveryExhausted:
+		return "recovery_exhausted"
+	case ReasonHalfOpenDenied:
+		return "half_open_denied"
+	case ReasonProbeInFlight:
+		return "probe_in_flight"
+	case ReasonDraining:
+		return "draining"
+	case ReasonGroupOpen:
+		return "group_open"
+	case ReasonStrictNotConfigured:
+		return "strict_not_configured"
+	case ReasonHalfOpenBusy:
+		return "half_open_busy"
+	case ReasonLoadShed:
+		return "load_shed"
+	case ReasonForcedOpen:
+		return "forced_open"
+	case ReasonRateLimited:
+		return "rate_limited"
+	case ReasonBulkheadFull:
+		return "bulkhead_full"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,152 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowRequestDetailed(t *testing.T) {
+	newManager := func(cfg CircuitBreakerConf) *CBManager {
+		m := NewCBManager()
+		m.InitCircuitBreakers([]string{"test-server"}, cfg)
+		return m
+	}
+	trip := func(m *CBManager) { m.ReportFailure("test-server") }
+
+	tests := []struct {
+		name        string
+		setup       func() *CBManager
+		wantAllowed bool
+		wantReason  DecisionReason
+	}{
+		{
+			name:        "closed",
+			setup:       func() *CBManager { return newManager(CircuitBreakerConf{}) },
+			wantAllowed: true,
+			wantReason:  ReasonClosed,
+		},
+		{
+			name:        "not configured",
+			setup:       NewCBManager,
+			wantAllowed: true,
+			wantReason:  ReasonNotConfigured,
+		},
+		{
+			name: "strict not configured",
+			setup: func() *CBManager {
+				m := NewCBManager()
+				m.SetStrictServers(true)
+				return m
+			},
+			wantReason: ReasonStrictNotConfigured,
+		},
+		{
+			name: "open",
+			setup: func() *CBManager {
+				m := newManager(CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+				trip(m)
+				return m
+			},
+			wantReason: ReasonOpen,
+		},
+		{
+			name: "disabled",
+			setup: func() *CBManager {
+				m := newManager(CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+				trip(m)
+				m.DisableAll()
+				return m
+			},
+			wantAllowed: true,
+			wantReason:  ReasonDisabled,
+		},
+		{
+			name: "half-open admitted",
+			setup: func() *CBManager {
+				m := newManager(CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour, HalfOpenPrc: 100})
+				trip(m)
+				m.TriggerProbe("test-server")
+				return m
+			},
+			wantAllowed: true,
+			wantReason:  ReasonHalfOpenAdmitted,
+		},
+		{
+			name: "half-open denied",
+			setup: func() *CBManager {
+				m := newManager(CircuitBreakerConf{
+					FailureThreshold: 1,
+					RecoveryTimeout:  time.Hour,
+					HalfOpenStrategy: HalfOpenTokenBucket,
+				})
+				trip(m)
+				m.TriggerProbe("test-server")
+				m.AllowRequest("test-server") // расходует единственный токен
+				return m
+			},
+			wantReason: ReasonHalfOpenDenied,
+		},
+		{
+			name: "probe in flight",
+			setup: func() *CBManager {
+				m := newManager(CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour, HalfOpenSingleProbe: true})
+				trip(m)
+				m.TriggerProbe("test-server")
+				m.AllowRequest("test-server")
+				return m
+			},
+			wantReason: ReasonProbeInFlight,
+		},
+		{
+			name: "recovery exhausted",
+			setup: func() *CBManager {
+				m := newManager(CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour, HalfOpenPrc: 100, MaxRecoveryAttempts: 1})
+				trip(m)
+				m.TriggerProbe("test-server")
+				trip(m)
+				return m
+			},
+			wantReason: ReasonRecoveryExhausted,
+		},
+		{
+			name: "draining",
+			setup: func() *CBManager {
+				m := newManager(CircuitBreakerConf{})
+				m.Drain("test-server")
+				return m
+			},
+			wantReason: ReasonDraining,
+		},
+		{
+			name: "group open",
+			setup: func() *CBManager {
+				m := NewCBManager()
+				m.InitCircuitBreakers([]string{"test-server", "peer"}, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+				m.NewGroup("g", []string{"test-server", "peer"})
+				m.SetGroupConf("g", GroupConf{OpenFraction: 0.5, GateMembers: true})
+				m.ReportFailure("peer")
+				return m
+			},
+			wantReason: ReasonGroupOpen,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.setup()
+			allowed, _, reason := m.AllowRequestDetailed("test-server")
+			if allowed != tt.wantAllowed || reason != tt.wantReason {
+				t.Errorf("Expected %v (%s), got %v (%s)", tt.wantAllowed, tt.wantReason, allowed, reason)
+			}
+		})
+	}
+}
+
+func TestDecisionReason_String(t *testing.T) {
+	if s := ReasonProbeInFlight.String(); s != "probe_in_flight" {
+		t.Errorf("Expected 'probe_in_flight', got %q", s)
+	}
+	if s := DecisionReason(-1).String(); s != "unknown" {
+		t.Errorf("Expected 'unknown', got %q", s)
+	}
+}
@@ -0,0 +1,187 @@
+package circuitbreaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventBufferSize - размер буфера канала событий подписчика
+const eventBufferSize = 128
+
+// Event описывает переход Circuit Breaker из одного состояния в другое
+type Event struct {
+	Name  string          // имя (сервер) CB
+	From  State           // предыдущее состояние
+	To    State           // новое состояние
+	Time  time.Time       // момент перехода
+	Cause TransitionCause // причина перехода
+}
+
+// TransitionCause объясняет, чем вызван переход CB
+type TransitionCause int
+
+const (
+	CauseManual          TransitionCause = iota // ручной переход: ForceState, Reset, AlwaysOpen
+	CauseTripped                                // closed -> open: достигнут порог ошибок
+	CauseRecoveryTimeout                        // open -> half-open: истек таймаут восстановления
+	CauseProbeTriggered                         // open -> half-open: TriggerProbe или восстановление связанного CB
+	CauseRecovered                              // half-open -> closed: пробы успешны
+	CauseProbeFailed                            // half-open -> open: проба неудачна
+	CauseHalfOpenTimeout                        // выход из half-open по HalfOpenTimeout
+	CauseRemote                                 // переход, опубликованный другим экземпляром (StateStore)
+)
+
+// String возвращает текстовое представление причины для логов
+func (c TransitionCause) String() string {
+	switch c {
+	case CauseManual:
+		return "manual"
+	case CauseTripped:
+		return "tripped"
+	case CauseRecoveryTimeout:
+		return "recovery_timeout"
+	case CauseProbeTriggered:
+		return "probe_triggered"
+	case CauseRecovered:
+		return "recovered"
+	case CauseProbeFailed:
+		return "probe_failed"
+	case CauseHalfOpenTimeout:
+		return "half_open_timeout"
+	case CauseRemote:
+		return "remote"
+	default:
+		return "unknown"
+	}
+}
+
+// eventHub рассылает события переходов подписчикам без блокировки CB
+type eventHub struct {
+	mu      sync.RWMutex
+	subs    map[chan Event]*atomic.Uint64 // подписчик -> собственный счетчик потерь (nil - общий dropped)
+	wakers  map[chan struct{}]struct{}    // уведомления о любом переходе без содержимого события
+	dropped atomic.Uint64
+
+	once   sync.Once
+	public chan Event // канал, возвращаемый Events()
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subs:   make(map[chan Event]*atomic.Uint64),
+		wakers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// publish отправляет событие всем подписчикам. Если буфер подписчика заполнен,
+// событие для него отбрасывается и увеличивается счетчик потерь подписчика
+// (для подписчиков без собственного счетчика - общий счетчик DroppedEvents).
+func (h *eventHub) publish(ev Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for ch, dropped := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			if dropped != nil {
+				dropped.Add(1)
+			} else {
+				h.dropped.Add(1)
+			}
+		}
+	}
+	for ch := range h.wakers {
+		// Непрочитанное уведомление уже ожидает получателя, повторное не нужно
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// subscribe регистрирует нового подписчика с буфером size
+func (h *eventHub) subscribe(size int) chan Event {
+	return h.subscribeCounted(size, nil)
+}
+
+// subscribeCounted регистрирует подписчика, потери которого считаются в dropped, а не в общем счетчике
+func (h *eventHub) subscribeCounted(size int, dropped *atomic.Uint64) chan Event {
+	ch := make(chan Event, size)
+	h.mu.Lock()
+	h.subs[ch] = dropped
+	h.mu.Unlock()
+	return ch
+}
+
+// unsubscribe удаляет подписчика. Канал не закрывается.
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// subscribeWake регистрирует получателя уведомлений о переходах. Уведомления
+// схлопываются: пока предыдущее не прочитано, новые не добавляются и не считаются потерянными.
+func (h *eventHub) subscribeWake() chan struct{} {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	h.wakers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+// unsubscribeWake удаляет получателя уведомлений
+func (h *eventHub) unsubscribeWake(ch chan struct{}) {
+	h.mu.Lock()
+	delete(h.wakers, ch)
+	h.mu.Unlock()
+}
+
+// Events возвращает канал событий переходов всех CB менеджера.
+// Канал буферизован (128 событий) и создается при первом вызове; последующие вызовы
+// возвращают тот же канал. Переходы, произошедшие до первого вызова, не доставляются.
+// Отправка никогда не блокирует CB: если потребитель не успевает читать и буфер заполнен,
+// событие отбрасывается, а счетчик DroppedEvents увеличивается.
+func (m *CBManager) Events() <-chan Event {
+	m.events.once.Do(func() {
+		m.events.public = m.events.subscribe(eventBufferSize)
+	})
+	return m.events.public
+}
+
+// DroppedEvents возвращает количество событий, отброшенных из-за переполненных буферов подписчиков
+func (m *CBManager) DroppedEvents() uint64 {
+	return m.events.dropped.Load()
+}
+
+// WaitTransition блокируется до следующего перехода CB сервера и возвращает его состояния
+// from и to. Переходы, произошедшие до вызова, не учитываются. Ожидаются только CB,
+// зарегистрированные в этом менеджере (для остальных возвращается ErrBreakerNotFound).
+// При завершении ctx возвращается ctx.Err().
+func (m *CBManager) WaitTransition(ctx context.Context, serverURL string) (from, to State, err error) {
+	m.mu.RLock()
+	serverURL = m.keyLocked(serverURL)
+	_, ok := m.breakers[serverURL]
+	m.mu.RUnlock()
+	if !ok {
+		return notConfigured, notConfigured, fmt.Errorf("%w: %s", ErrBreakerNotFound, serverURL)
+	}
+
+	ch := m.events.subscribe(eventBufferSize)
+	defer m.events.unsubscribe(ch)
+
+	for {
+		select {
+		case ev := <-ch:
+			if ev.Name == serverURL {
+				return ev.From, ev.To, nil
+			}
+		case <-ctx.Done():
+			return notConfigured, notConfigured, ctx.Err()
+		}
+	}
+}
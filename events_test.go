@@ -0,0 +1,133 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+
+	events := m.Events()
+	if m.Events() != events {
+		t.Error("Expected Events() to return the same channel")
+	}
+
+	m.ReportFailure("test-server")
+	clock.Advance(time.Second)
+	m.AllowRequest("test-server")
+	m.ReportSuccess("test-server")
+
+	want := []struct{ from, to State }{
+		{stateClosed, stateOpen},
+		{stateOpen, stateHalfOpen},
+		{stateHalfOpen, stateClosed},
+	}
+	for i, w := range want {
+		select {
+		case ev := <-events:
+			if ev.Name != "test-server" || ev.From != w.from || ev.To != w.to {
+				t.Errorf("event %d = %+v, want %s -> %s", i, ev, w.from, w.to)
+			}
+			if ev.Time.IsZero() {
+				t.Errorf("event %d has zero timestamp", i)
+			}
+		default:
+			t.Fatalf("event %d not delivered", i)
+		}
+	}
+}
+
+func TestEvents_SlowConsumer(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+
+	// Подписываемся, но не читаем события
+	_ = m.Events()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.ReportFailure("test-server")
+		for i := 0; i < eventBufferSize; i++ {
+			clock.Advance(time.Second)
+			m.AllowRequest("test-server")  // open -> half-open
+			m.ReportFailure("test-server") // half-open -> open
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow consumer stalled AllowRequest")
+	}
+
+	if dropped := m.DroppedEvents(); dropped == 0 {
+		t.Error("Expected dropped events for slow consumer")
+	}
+}
+
+func TestWaitTransition(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"a", "b"}, CircuitBreakerConf{FailureThreshold: 1})
+
+	type result struct {
+		from, to State
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		from, to, err := m.WaitTransition(context.Background(), "a")
+		done <- result{from, to, err}
+	}()
+
+	// Дожидаемся подписки ожидающего, затем переключаем CB из другой горутины
+	for {
+		m.events.mu.RLock()
+		n := len(m.events.subs)
+		m.events.mu.RUnlock()
+		if n > 0 {
+			break
+		}
+		runtime.Gosched()
+	}
+	go func() {
+		m.ReportFailure("b") // переход другого CB не учитывается
+		m.ReportFailure("a")
+	}()
+
+	if r := <-done; r.err != nil || r.from != stateClosed || r.to != stateOpen {
+		t.Errorf("Expected closed -> open, got %s -> %s (%v)", r.from, r.to, r.err)
+	}
+}
+
+func TestWaitTransition_Context(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"a"}, CircuitBreakerConf{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	if _, _, err := m.WaitTransition(ctx, "a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context canceled, got %v", err)
+	}
+	if _, _, err := m.WaitTransition(ctx, "unknown"); !errors.Is(err, ErrBreakerNotFound) {
+		t.Errorf("Expected ErrBreakerNotFound, got %v", err)
+	}
+}
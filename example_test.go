@@ -0,0 +1,38 @@
+package circuitbreaker_test
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/a3ak/circuitbreaker"
+)
+
+// alwaysOpen - заглушка Breaker, которая никогда не пропускает запросы
+type alwaysOpen struct{}
+
+func (alwaysOpen) Allow() (bool, circuitbreaker.State) { return false, circuitbreaker.StateOpen }
+func (alwaysOpen) Success()                            {}
+func (alwaysOpen) Failure()                            {}
+func (alwaysOpen) State() circuitbreaker.State         { return circuitbreaker.StateOpen }
+func (alwaysOpen) Stats() circuitbreaker.Stats         { return circuitbreaker.Stats{"state": "open"} }
+
+// fetch - код приложения, зависящий от интерфейса Breaker
+func fetch(b circuitbreaker.Breaker, call func() error) error {
+	if allowed, state := b.Allow(); !allowed {
+		return fmt.Errorf("%w (%s)", circuitbreaker.ErrCircuitOpen, state)
+	}
+	if err := call(); err != nil {
+		b.Failure()
+		return err
+	}
+	b.Success()
+	return nil
+}
+
+func ExampleBreaker() {
+	err := fetch(alwaysOpen{}, func() error {
+		panic("must not be called")
+	})
+	fmt.Println(errors.Is(err, circuitbreaker.ErrCircuitOpen), err)
+	// Output: true circuit breaker is open (open)
+}
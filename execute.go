@@ -0,0 +1,173 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrPanic возвращается Execute, если fn запаниковала, а в конфигурации CB включен RecoverPanics
+var ErrPanic = errors.New("panic in protected call")
+
+// Ticket - разрешение на выполнение одного запроса, полученное через Acquire.
+// По завершении запроса нужно вызвать Success или Failure; учитывается только первый
+// из вызовов, повторные (в том числе из отложенных обработчиков) ничего не делают.
+// Билет, выданный в half-open, является пробой: если к моменту отчета состояние CB
+// уже изменилось (например, CB снова разомкнулся или замкнулся), результат пробы
+// отбрасывается и учитывается только в счетчике stale_results.
+type Ticket struct {
+	cb    *CircuitBreaker
+	state State  // состояние CB в момент выдачи
+	gen   uint64 // период состояния CB в момент выдачи
+	src   string // источник запроса (см. AcquireFrom)
+	done  atomic.Bool
+}
+
+// Acquire запрашивает разрешение на выполнение запроса к серверу.
+// Если CB не пропускает запрос, возвращается ошибка, обернутая в ErrCircuitOpen.
+// Для сервера без CB возвращается билет, результат которого ни на что не влияет
+// (в строгом режиме - ошибка, обернутая в ErrBreakerNotFound).
+// Пока билет не закрыт, запрос учитывается в счетчике in_flight и занимает слот MaxConcurrent.
+func (m *CBManager) Acquire(serverURL string) (*Ticket, error) {
+	return m.AcquireFrom(serverURL, "")
+}
+
+// AcquireFrom работает как Acquire, но помечает запрос ключом источника source
+// (например, идентификатором клиента шлюза). При HalfOpenDistinctSources успехи
+// в half-open засчитываются не больше одного раза на источник.
+func (m *CBManager) AcquireFrom(serverURL, source string) (*Ticket, error) {
+	a := m.allowRequest(serverURL, PriorityNormal, true)
+	if !a.allowed && a.state == notConfigured {
+		return nil, fmt.Errorf("%w: %s", ErrBreakerNotFound, serverURL)
+	}
+	if !a.allowed {
+		return nil, fmt.Errorf("%w: %s (%s)", ErrCircuitOpen, serverURL, a.state)
+	}
+
+	// Билет связан с CB и периодом из решения о пропуске: слот in_flight освобождается
+	// на том же CB, даже если его успели заменить в менеджере, а переход после решения
+	// делает билет устаревшим
+	return &Ticket{cb: a.cb, state: a.state, gen: a.gen, src: source}, nil
+}
+
+// State возвращает состояние CB в момент выдачи билета
+func (t *Ticket) State() State {
+	return t.state
+}
+
+// Success отмечает успешное завершение запроса
+func (t *Ticket) Success() {
+	if !t.close() {
+		return
+	}
+	t.cb.inFlight.Add(-1)
+	t.cb.resolve(t.gen, t.state, false, t.src)
+}
+
+// Failure отмечает неудачное завершение запроса
+func (t *Ticket) Failure() {
+	if !t.close() {
+		return
+	}
+	t.cb.inFlight.Add(-1)
+	t.cb.resolve(t.gen, t.state, true, t.src)
+}
+
+// close отмечает билет закрытым. Возвращает false, если билет уже закрыт
+// или не связан с CB, и результат учитывать не нужно.
+func (t *Ticket) close() bool {
+	return t.done.CompareAndSwap(false, true) && t.cb != nil
+}
+
+// result закрывает билет по ошибке с учетом классификатора IsFailure
+func (t *Ticket) result(err error) {
+	if !t.close() {
+		return
+	}
+	t.cb.inFlight.Add(-1)
+	t.cb.resolve(t.gen, t.state, t.cb.isFailure(err), t.src)
+}
+
+// Execute выполняет fn, если CB сервера пропускает запрос, и отмечает результат:
+// nil - успех, иначе неудача (с учетом классификатора IsFailure). Если запрос не пропущен,
+// fn не вызывается и возвращается ошибка, обернутая в ErrCircuitOpen.
+// Паника в fn учитывается как неудача (см. CircuitBreakerConf.RecoverPanics).
+func (m *CBManager) Execute(serverURL string, fn func() error) error {
+	return m.ExecuteFrom(serverURL, "", fn)
+}
+
+// ExecuteFrom работает как Execute для запроса от источника source (см. AcquireFrom)
+func (m *CBManager) ExecuteFrom(serverURL, source string, fn func() error) error {
+	t, err := m.AcquireFrom(serverURL, source)
+	if err != nil {
+		return err
+	}
+
+	return t.run(fn)
+}
+
+// Protect возвращает защищенную версию fn: каждый вызов результата выполняет fn через
+// Execute для сервера serverURL. Позволяет один раз собрать защищенный клиент при
+// инициализации, не передавая менеджер и ключ сервера по всему коду.
+func (m *CBManager) Protect(serverURL string, fn func() error) func() error {
+	return func() error {
+		return m.Execute(serverURL, fn)
+	}
+}
+
+// ProtectFunc - обобщенный вариант Protect для функций, возвращающих значение.
+// Если запрос не пропущен, возвращается нулевое значение T и ошибка, обернутая
+// в ErrCircuitOpen; иначе - результат fn.
+func ProtectFunc[T any](m *CBManager, serverURL string, fn func() (T, error)) func() (T, error) {
+	return func() (T, error) {
+		var v T
+		err := m.Execute(serverURL, func() error {
+			var err error
+			v, err = fn()
+			return err
+		})
+		return v, err
+	}
+}
+
+// run выполняет fn и закрывает билет по ее результату. Паника в fn учитывается как неудача
+// (билет закрывается, in_flight уменьшается), после чего возбуждается повторно
+// либо, при RecoverPanics, возвращается в виде ошибки ErrPanic.
+func (t *Ticket) run(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Failure()
+			if t.cb == nil || !t.cb.Config().RecoverPanics {
+				panic(r)
+			}
+			err = fmt.Errorf("%w: %v", ErrPanic, r)
+		}
+	}()
+
+	err = fn()
+	t.result(err)
+	return err
+}
+
+// ExecuteAsync выполняет fn в отдельной горутине и возвращает канал с ее результатом.
+// Решение о пропуске запроса принимается синхронно: если CB не пропускает запрос,
+// возвращается уже закрытый канал с ошибкой, обернутой в ErrCircuitOpen, и fn не вызывается.
+// Иначе на каждый вызов запускается горутина, результат fn отмечается в CB и отправляется
+// в канал (буферизованный, читать его не обязательно), после чего канал закрывается.
+// Отмену по контексту fn должна обрабатывать сама, например, замкнув ctx.
+func (m *CBManager) ExecuteAsync(serverURL string, fn func() error) <-chan error {
+	ch := make(chan error, 1)
+
+	t, err := m.Acquire(serverURL)
+	if err != nil {
+		ch <- err
+		close(ch)
+		return ch
+	}
+
+	go func() {
+		defer close(ch)
+		ch <- t.run(fn)
+	}()
+	return ch
+}
@@ -0,0 +1,616 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecute(t *testing.T) {
+	cfg := CircuitBreakerConf{
+		FailureThreshold: 2,
+		RecoveryTimeout:  time.Minute,
+	}
+
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, cfg)
+
+	errBackend := errors.New("backend error")
+
+	// Ошибки fn возвращаются вызывающему и размыкают CB
+	for i := 0; i < cfg.FailureThreshold; i++ {
+		if err := m.Execute("test-server", func() error { return errBackend }); !errors.Is(err, errBackend) {
+			t.Fatalf("Expected backend error, got %v", err)
+		}
+	}
+
+	// В open fn не вызывается
+	called := false
+	err := m.Execute("test-server", func() error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if called {
+		t.Error("Expected fn not to be called for open CB")
+	}
+
+	// Сервер без CB: fn выполняется
+	if err := m.Execute("unknown-server", func() error { return nil }); err != nil {
+		t.Errorf("Expected nil error for unknown server, got %v", err)
+	}
+}
+
+func TestExecute_InFlight(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 1000})
+
+	inFlight := func() int64 {
+		return m.GetCircuitBreakerStats()["test-server"].(map[string]any)["in_flight"].(int64)
+	}
+
+	// Запрос учитывается, пока выполняется fn
+	m.Execute("test-server", func() error {
+		if n := inFlight(); n != 1 {
+			t.Errorf("Expected in_flight 1 during execution, got %d", n)
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				m.Execute("test-server", func() error {
+					time.Sleep(time.Microsecond)
+					if (i+j)%3 == 0 {
+						return errors.New("fail")
+					}
+					return nil
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := inFlight(); n != 0 {
+		t.Errorf("Expected in_flight 0 after all requests finished, got %d", n)
+	}
+}
+
+func TestExecute_SingleProbe(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold:    1,
+		SuccessThreshold:    2,
+		RecoveryTimeout:     time.Second,
+		HalfOpenPrc:         100,
+		HalfOpenSingleProbe: true,
+		Clock:               clock,
+	})
+
+	m.ReportFailure("test-server")
+	clock.Advance(time.Second)
+
+	// Пропускается только одна проба, пока не получен ее результат
+	probe, err := m.Acquire("test-server")
+	if err != nil {
+		t.Fatalf("Expected first probe to be admitted, got %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := m.Acquire("test-server"); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Expected other requests to be blocked while probe is pending, got %v", err)
+		}
+	}
+
+	// Успешная проба открывает обычную логику half-open
+	probe.Success()
+	if state := m.GetCircuitBreakerState("test-server"); state != "half-open" {
+		t.Fatalf("Expected 'half-open' after successful probe, got '%s'", state)
+	}
+	if allowed, _ := m.AllowRequest("test-server"); !allowed {
+		t.Error("Expected requests to be admitted after successful probe")
+	}
+
+	// Ошибка первой пробы сразу возвращает в open
+	m.ReportFailure("test-server")
+	clock.Advance(time.Second)
+	probe, err = m.Acquire("test-server")
+	if err != nil {
+		t.Fatalf("Expected probe to be admitted after recovery timeout, got %v", err)
+	}
+	probe.Failure()
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected 'open' after failed probe, got '%s'", state)
+	}
+}
+
+func TestExecuteAsync(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 3, RecoveryTimeout: time.Minute})
+
+	errBackend := errors.New("backend error")
+	var results []<-chan error
+	for i := 0; i < 5; i++ {
+		results = append(results, m.ExecuteAsync("test-server", func() error {
+			time.Sleep(time.Millisecond)
+			if i%2 == 0 {
+				return nil
+			}
+			return errBackend
+		}))
+	}
+
+	var ok, failed int
+	for _, ch := range results {
+		switch err := <-ch; {
+		case err == nil:
+			ok++
+		case errors.Is(err, errBackend):
+			failed++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+		// Канал закрывается после отправки результата
+		if _, open := <-ch; open {
+			t.Error("Expected result channel to be closed")
+		}
+	}
+	if ok != 3 || failed != 2 {
+		t.Errorf("Expected 3 successes and 2 failures, got %d/%d", ok, failed)
+	}
+
+	// Разомкнутый CB отклоняет запрос сразу, fn не вызывается
+	m.ReportFailure("test-server")
+	m.ReportFailure("test-server")
+	m.ReportFailure("test-server")
+	called := false
+	ch := m.ExecuteAsync("test-server", func() error {
+		called = true
+		return nil
+	})
+	if err := <-ch; !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if called {
+		t.Error("Expected fn not to be called for open CB")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{})
+
+	ticket, err := m.Acquire("test-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Drain("test-server"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Новые запросы отклоняются
+	if allowed, _ := m.AllowRequest("test-server"); allowed {
+		t.Error("Expected draining CB to deny new requests")
+	}
+	if _, err := m.Acquire("test-server"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen while draining, got %v", err)
+	}
+
+	// Пока запрос выполняется, CB не удаляется
+	if err := m.RemoveCircuitBreaker("test-server"); !errors.Is(err, ErrBreakerBusy) {
+		t.Fatalf("Expected ErrBreakerBusy with in-flight request, got %v", err)
+	}
+
+	// Выполняющийся запрос завершается и сообщает результат
+	ticket.Success()
+	if err := m.ReportSuccess("test-server"); err != nil {
+		t.Errorf("Expected Report* to work while draining, got %v", err)
+	}
+	if err := m.RemoveCircuitBreaker("test-server"); err != nil {
+		t.Fatalf("Expected removal after in-flight reached zero, got %v", err)
+	}
+	if m.GetCircuitBreaker("test-server") != nil {
+		t.Error("Expected CB to be removed")
+	}
+	if err := m.RemoveCircuitBreaker("test-server"); !errors.Is(err, ErrBreakerNotFound) {
+		t.Errorf("Expected ErrBreakerNotFound for removed CB, got %v", err)
+	}
+}
+
+func TestTicket_StaleProbeResult(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+
+	m.ReportFailure("test-server")
+	clock.Advance(time.Second)
+
+	// Две пробы в half-open
+	slow, err := m.Acquire("test-server")
+	if err != nil || slow.State() != stateHalfOpen {
+		t.Fatalf("Expected half-open probe, got %v (%v)", slow, err)
+	}
+	fast, err := m.Acquire("test-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Быстрая проба неудачна: CB снова разомкнут
+	fast.Failure()
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Fatalf("Expected 'open', got '%s'", state)
+	}
+
+	// Запоздавший успех медленной пробы не замыкает CB
+	slow.Success()
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected late probe success to be ignored, got '%s'", state)
+	}
+
+	// Запоздавшая проба из прошлого периода не влияет и на новый half-open
+	clock.Advance(time.Minute)
+	old, _ := m.Acquire("test-server")
+	stale, _ := m.Acquire("test-server")
+	old.Failure()
+	clock.Advance(time.Minute)
+	m.AllowRequest("test-server") // open -> half-open
+	stale.Failure()
+	if state := m.GetCircuitBreakerState("test-server"); state != "half-open" {
+		t.Errorf("Expected stale probe failure not to reopen new half-open, got '%s'", state)
+	}
+
+	stats := m.GetCircuitBreakerStats()["test-server"].(map[string]any)
+	if n := stats["stale_results"].(uint64); n != 2 {
+		t.Errorf("Expected 2 stale results, got %d", n)
+	}
+	if n := stats["in_flight"].(int64); n != 0 {
+		t.Errorf("Expected in_flight 0, got %d", n)
+	}
+}
+
+func TestTicket_TransitionAfterAdmission(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	var once sync.Once
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+		OnDecision: func(name string, allowed bool, state State, reason DecisionReason) {
+			// Сразу после пропуска пробы CB снова размыкается и начинает новый half-open
+			if allowed && state == stateHalfOpen {
+				once.Do(func() {
+					cb := m.GetCircuitBreaker(name)
+					cb.ForceState(StateOpen)
+					cb.ForceState(StateHalfOpen)
+				})
+			}
+		},
+	})
+	m.ReportFailure("test-server")
+	clock.Advance(time.Second)
+
+	ticket, err := m.Acquire("test-server")
+	if err != nil || ticket.State() != stateHalfOpen {
+		t.Fatalf("Expected half-open probe, got %v (%v)", ticket, err)
+	}
+
+	// Результат относится к периоду, в котором билет выдан, и в новом half-open не учитывается
+	ticket.Success()
+	if state := m.Peek("test-server"); state != stateHalfOpen {
+		t.Errorf("Expected probe from previous period to be ignored, got %s", state)
+	}
+	stats := m.GetCircuitBreakerStats()["test-server"].(map[string]any)
+	if n := stats["stale_results"].(uint64); n != 1 {
+		t.Errorf("Expected 1 stale result, got %d", n)
+	}
+}
+
+func TestTicket_BreakerReplacedAfterAdmission(t *testing.T) {
+	m := NewCBManager()
+	var once sync.Once
+	cfg := CircuitBreakerConf{FailureThreshold: 5, MaxConcurrent: 1}
+	cfg.OnDecision = func(name string, allowed bool, state State, reason DecisionReason) {
+		// CB заменяется между решением о пропуске и выдачей билета
+		once.Do(func() {
+			m.AddCircuitBreakerWithPolicy(name, CircuitBreakerConf{FailureThreshold: 5}, CollisionReplace)
+		})
+	}
+	m.InitCircuitBreakers([]string{"test-server"}, cfg)
+	old := m.GetCircuitBreaker("test-server")
+
+	ticket, err := m.Acquire("test-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced := m.GetCircuitBreaker("test-server")
+	if replaced == old {
+		t.Fatal("Expected breaker to be replaced")
+	}
+	ticket.Success()
+
+	// Слот освобождается на CB, который его занял
+	if n := old.inFlight.Load(); n != 0 {
+		t.Errorf("Expected admitting breaker to release its slot, got in_flight %d", n)
+	}
+	if n := replaced.inFlight.Load(); n != 0 {
+		t.Errorf("Expected replacement breaker untouched, got in_flight %d", n)
+	}
+}
+
+func TestTicket_ResolveOnce(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 1000})
+	stats := func() map[string]any {
+		return m.GetCircuitBreakerStats()["test-server"].(map[string]any)
+	}
+
+	// Повторные отчеты по одному билету учитываются один раз
+	tk, err := m.Acquire("test-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.Failure()
+	tk.Success()
+	tk.Failure()
+
+	var wg sync.WaitGroup
+	tk, _ = m.Acquire("test-server")
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tk.Success()
+		}()
+	}
+	wg.Wait()
+
+	s := stats()
+	if n := s["total_failures"].(uint64); n != 1 {
+		t.Errorf("Expected 1 failure, got %d", n)
+	}
+	if n := s["total_successes"].(uint64); n != 1 {
+		t.Errorf("Expected 1 success, got %d", n)
+	}
+	if n := s["in_flight"].(int64); n != 0 {
+		t.Errorf("Expected in_flight 0, got %d", n)
+	}
+}
+
+func TestExecute_Panic(t *testing.T) {
+	stats := func(m *CBManager) map[string]any {
+		return m.GetCircuitBreakerStats()["test-server"].(map[string]any)
+	}
+
+	// По умолчанию паника возбуждается повторно после учета неудачи
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 10})
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("Expected re-panic with 'boom', got %v", r)
+			}
+		}()
+		m.Execute("test-server", func() error { panic("boom") })
+	}()
+	if s := stats(m); s["failure_count"].(int) != 1 || s["in_flight"].(int64) != 0 {
+		t.Errorf("Expected 1 failure and in_flight 0, got %v / %v", s["failure_count"], s["in_flight"])
+	}
+
+	// С RecoverPanics возвращается ErrPanic, одиночная проба half-open освобождается
+	clock := newFakeClock()
+	m = NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold:    1,
+		RecoveryTimeout:     time.Second,
+		HalfOpenSingleProbe: true,
+		RecoverPanics:       true,
+		Clock:               clock,
+	})
+	m.ReportFailure("test-server")
+	clock.Advance(time.Second)
+
+	err := m.Execute("test-server", func() error { panic("boom") })
+	if !errors.Is(err, ErrPanic) {
+		t.Errorf("Expected ErrPanic, got %v", err)
+	}
+	if s := stats(m); s["state"] != "open" || s["in_flight"].(int64) != 0 {
+		t.Errorf("Expected panicking probe to reopen CB with in_flight 0, got %v / %v", s["state"], s["in_flight"])
+	}
+
+	if err := <-m.ExecuteAsync("test-server", func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen after failed probe, got %v", err)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 2,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+
+	var calls int
+	var fail bool
+	errBackend := errors.New("backend down")
+	call := m.Protect("test-server", func() error {
+		calls++
+		if fail {
+			return errBackend
+		}
+		return nil
+	})
+
+	// Ошибки размыкают CB, после чего fn не вызывается
+	fail = true
+	for i := 0; i < 2; i++ {
+		if err := call(); !errors.Is(err, errBackend) {
+			t.Fatalf("Expected backend error, got %v", err)
+		}
+	}
+	if err := call(); !errors.Is(err, ErrCircuitOpen) || calls != 2 {
+		t.Fatalf("Expected ErrCircuitOpen without calling fn, got %v after %d calls", err, calls)
+	}
+
+	// После таймаута успешная проба замыкает CB
+	fail = false
+	clock.Advance(time.Second)
+	if err := call(); err != nil {
+		t.Fatalf("Expected successful probe, got %v", err)
+	}
+	if state := m.GetCircuitBreakerState("test-server"); state != "closed" {
+		t.Errorf("Expected closed after recovery, got '%s'", state)
+	}
+}
+
+func TestProtectFunc(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+
+	next := 0
+	get := ProtectFunc(m, "test-server", func() (int, error) {
+		next++
+		if next > 1 {
+			return -1, errors.New("failed")
+		}
+		return next, nil
+	})
+
+	if v, err := get(); v != 1 || err != nil {
+		t.Fatalf("Expected 1, got %d (%v)", v, err)
+	}
+	if _, err := get(); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected fn error, got %v", err)
+	}
+	if v, err := get(); v != 0 || !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected zero value with ErrCircuitOpen, got %d (%v)", v, err)
+	}
+}
+
+func TestAcquire_MaxConcurrent(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 1000, MaxConcurrent: 3})
+
+	// Одновременно допускается не больше MaxConcurrent запросов
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		tickets []*Ticket
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tk, err := m.Acquire("test-server"); err == nil {
+				mu.Lock()
+				tickets = append(tickets, tk)
+				mu.Unlock()
+			} else if !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("Expected ErrCircuitOpen, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(tickets) != 3 {
+		t.Fatalf("Expected 3 admitted requests, got %d", len(tickets))
+	}
+	if allowed, _, reason := m.AllowRequestDetailed("test-server"); allowed || reason != ReasonBulkheadFull {
+		t.Errorf("Expected bulkhead_full rejection, got %v/%s", allowed, reason)
+	}
+
+	// Закрытый билет освобождает слот
+	tickets[0].Success()
+	if _, err := m.Acquire("test-server"); err != nil {
+		t.Errorf("Expected request to be admitted after slot release, got %v", err)
+	}
+	if _, err := m.Acquire("test-server"); err == nil {
+		t.Error("Expected request to be rejected with all slots busy")
+	}
+}
+
+func TestExecuteFrom_DistinctSources(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold:        1,
+		RecoveryTimeout:         time.Minute,
+		SuccessThreshold:        3,
+		HalfOpenPrc:             100,
+		HalfOpenDistinctSources: true,
+		Clock:                   clock,
+	})
+	ok := func() error { return nil }
+
+	m.ReportFailure("test-server")
+	clock.Advance(time.Minute)
+
+	// Повторные успехи одного клиента не замыкают CB
+	for i := 0; i < 5; i++ {
+		if err := m.ExecuteFrom("test-server", "tenant-a", ok); err != nil {
+			t.Fatalf("Expected probe to be admitted, got %v", err)
+		}
+	}
+	if state := m.Peek("test-server"); state != StateHalfOpen {
+		t.Fatalf("Expected half-open after successes from one source, got %s", state)
+	}
+
+	// Успехи разных источников замыкают CB
+	m.ExecuteFrom("test-server", "tenant-b", ok)
+	if state := m.Peek("test-server"); state != StateHalfOpen {
+		t.Fatalf("Expected half-open after 2 distinct sources, got %s", state)
+	}
+	m.ExecuteFrom("test-server", "tenant-c", ok)
+	if state := m.Peek("test-server"); state != StateClosed {
+		t.Errorf("Expected closed after 3 distinct sources, got %s", state)
+	}
+}
+
+func TestExecuteFrom_DistinctSourcesAfterSoftFailure(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold:         1,
+		RecoveryTimeout:          time.Minute,
+		SuccessThreshold:         2,
+		HalfOpenPrc:              100,
+		HalfOpenDistinctSources:  true,
+		HalfOpenFailureTolerance: 1,
+		Clock:                    clock,
+	})
+	ok := func() error { return nil }
+	fail := func() error { return errors.New("boom") }
+
+	m.ReportFailure("test-server")
+	clock.Advance(time.Minute)
+	m.ExecuteFrom("test-server", "tenant-a", ok)
+
+	// Ошибка в пределах допуска обнуляет успехи вместе с учтенными источниками
+	m.ExecuteFrom("test-server", "tenant-b", fail)
+	if state := m.Peek("test-server"); state != StateHalfOpen {
+		t.Fatalf("Expected half-open within failure tolerance, got %s", state)
+	}
+	m.ExecuteFrom("test-server", "tenant-a", ok)
+	m.ExecuteFrom("test-server", "tenant-b", ok)
+	if state := m.Peek("test-server"); state != StateClosed {
+		t.Errorf("Expected closed after sources succeed again, got %s", state)
+	}
+}
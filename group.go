@@ -0,0 +1,149 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrGroupNotFound возвращается при обращении к незарегистрированной группе
+var ErrGroupNotFound = errors.New("group not found")
+
+// GroupConf задает поведение группы circuit breakers
+type GroupConf struct {
+	OpenFraction float64 // Доля разомкнутых участников, при которой группа считается open (по умолчанию 0.5)
+	GateMembers  bool    // Если true, AllowRequest отклоняет запросы к участникам разомкнутой группы
+}
+
+// cbGroup - логическая группа circuit breakers (например, регион с несколькими хостами)
+type cbGroup struct {
+	members []string
+	conf    GroupConf
+}
+
+// NewGroup регистрирует группу name из участников members.
+// Группа считается разомкнутой, когда доля разомкнутых CB участников достигает GroupConf.OpenFraction.
+// Повторный вызов с тем же именем заменяет состав группы, сохраняя ее настройки.
+func (m *CBManager) NewGroup(name string, members []string) error {
+	if name == "" {
+		return fmt.Errorf("%w: group name cannot be empty", ErrInvalidConfig)
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("%w: group must have at least one member", ErrInvalidConfig)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conf := GroupConf{OpenFraction: 0.5}
+	if old, ok := m.groups[name]; ok {
+		conf = old.conf
+		m.unlinkGroup(name, old.members)
+	}
+	normalized := make([]string, len(members))
+	for i, srv := range members {
+		normalized[i] = m.keyLocked(srv)
+	}
+	m.groups[name] = &cbGroup{
+		members: normalized,
+		conf:    conf,
+	}
+	for _, srv := range normalized {
+		m.memberOf[srv] = append(m.memberOf[srv], name)
+	}
+	return nil
+}
+
+// SetGroupConf изменяет настройки группы. Некорректная доля (<= 0 или > 1) заменяется на 0.5.
+func (m *CBManager) SetGroupConf(name string, cfg GroupConf) error {
+	if cfg.OpenFraction <= 0 || cfg.OpenFraction > 1 {
+		cfg.OpenFraction = 0.5
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.groups[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+	}
+	g.conf = cfg
+	return nil
+}
+
+// GroupState возвращает состояние группы: open, если доля разомкнутых участников
+// достигла порога, closed - иначе, notConfigured - если группа не найдена.
+// Участники без настроенного CB считаются замкнутыми.
+func (m *CBManager) GroupState(name string) State {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	g, ok := m.groups[name]
+	if !ok {
+		return notConfigured
+	}
+	return m.groupStateLocked(g)
+}
+
+// groupStateLocked вычисляет состояние группы. Вызывается под m.mu.
+func (m *CBManager) groupStateLocked(g *cbGroup) State {
+	open := 0
+	for _, srv := range g.members {
+		if cb := m.breakers[srv]; cb != nil && cb.curState() == stateOpen {
+			open++
+		}
+	}
+	if float64(open)/float64(len(g.members)) >= g.conf.OpenFraction {
+		return stateOpen
+	}
+	return stateClosed
+}
+
+// groupBlocked проверяет, входит ли сервер в разомкнутую группу с включенным GateMembers
+func (m *CBManager) groupBlocked(serverURL string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, name := range m.memberOf[m.keyLocked(serverURL)] {
+		g := m.groups[name]
+		if g.conf.GateMembers && m.groupStateLocked(g) == stateOpen {
+			return true
+		}
+	}
+	return false
+}
+
+// leaveGroupsLocked исключает сервер srv из всех групп; группа без участников удаляется.
+// Вызывается под m.mu.
+func (m *CBManager) leaveGroupsLocked(srv string) {
+	for _, name := range m.memberOf[srv] {
+		g, ok := m.groups[name]
+		if !ok {
+			// Сервер указан в группе несколько раз, и группа уже удалена
+			continue
+		}
+		g.members = slices.DeleteFunc(g.members, func(s string) bool { return s == srv })
+		if len(g.members) == 0 {
+			delete(m.groups, name)
+		}
+	}
+	delete(m.memberOf, srv)
+}
+
+// unlinkGroup удаляет группу из индекса участников. Вызывается под m.mu.
+func (m *CBManager) unlinkGroup(name string, members []string) {
+	for _, srv := range members {
+		groups := m.memberOf[srv]
+		for i, g := range groups {
+			if g == name {
+				groups = append(groups[:i], groups[i+1:]...)
+				break
+			}
+		}
+		if len(groups) == 0 {
+			delete(m.memberOf, srv)
+		} else {
+			m.memberOf[srv] = groups
+		}
+	}
+}
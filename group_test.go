@@ -0,0 +1,170 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupState(t *testing.T) {
+	servers := []string{"eu-1", "eu-2", "eu-3"}
+	cfg := CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Minute,
+	}
+
+	m := NewCBManager()
+	m.InitCircuitBreakers(servers, cfg)
+	if err := m.NewGroup("eu", servers); err != nil {
+		t.Fatalf("NewGroup() error = %v", err)
+	}
+
+	// Тест: неизвестная группа
+	if state := m.GroupState("us"); state != notConfigured {
+		t.Errorf("Expected notConfigured for unknown group, got %s", state)
+	}
+
+	// Тест: все участники замкнуты
+	if state := m.GroupState("eu"); state != stateClosed {
+		t.Errorf("Expected closed group, got %s", state)
+	}
+
+	// Тест: 1 из 3 разомкнут - ниже порога 50%
+	m.ReportFailure("eu-1")
+	if state := m.GroupState("eu"); state != stateClosed {
+		t.Errorf("Expected closed group with 1 of 3 open, got %s", state)
+	}
+
+	// Тест: 2 из 3 разомкнуты - группа разомкнута
+	m.ReportFailure("eu-2")
+	if state := m.GroupState("eu"); state != stateOpen {
+		t.Errorf("Expected open group with 2 of 3 open, got %s", state)
+	}
+
+	// Тест: без GateMembers запросы к замкнутому участнику пропускаются
+	if allowed, _ := m.AllowRequest("eu-3"); !allowed {
+		t.Error("Expected allowed request to closed member without group gating")
+	}
+
+	// Тест: с GateMembers разомкнутая группа блокирует участников
+	if err := m.SetGroupConf("eu", GroupConf{OpenFraction: 0.5, GateMembers: true}); err != nil {
+		t.Fatalf("SetGroupConf() error = %v", err)
+	}
+	if allowed, state := m.AllowRequest("eu-3"); allowed || state != stateOpen {
+		t.Errorf("Expected denied request to member of open group, got %t/%s", allowed, state)
+	}
+}
+
+func TestNewGroup_Validation(t *testing.T) {
+	m := NewCBManager()
+	if err := m.NewGroup("", []string{"a"}); err == nil {
+		t.Error("Expected error for empty group name")
+	}
+	if err := m.NewGroup("g", nil); err == nil {
+		t.Error("Expected error for empty members")
+	}
+	if err := m.SetGroupConf("missing", GroupConf{}); err == nil {
+		t.Error("Expected error for unknown group")
+	}
+}
+
+func TestGroupGate_HalfOpenMember(t *testing.T) {
+	clock := newFakeClock()
+	servers := []string{"eu-1", "eu-2", "eu-3"}
+	var decisions []DecisionReason
+	m := NewCBManager()
+	m.InitCircuitBreakers(servers, CircuitBreakerConf{
+		FailureThreshold:    1,
+		RecoveryTimeout:     time.Minute,
+		SuccessThreshold:    1,
+		HalfOpenSingleProbe: true,
+		Clock:               clock,
+		OnDecision: func(name string, allowed bool, state State, reason DecisionReason) {
+			if name == "eu-3" && !allowed {
+				decisions = append(decisions, reason)
+			}
+		},
+	})
+	m.NewGroup("eu", servers)
+	m.SetGroupConf("eu", GroupConf{OpenFraction: 0.5, GateMembers: true})
+
+	// eu-3 ожидает пробу в half-open, когда группа снова размыкается
+	m.ReportFailure("eu-3")
+	m.TriggerProbe("eu-3")
+	m.ReportFailure("eu-1")
+	m.ReportFailure("eu-2")
+
+	if allowed, _, reason := m.AllowRequestDetailed("eu-3"); allowed || reason != ReasonGroupOpen {
+		t.Fatalf("Expected group_open rejection, got %v/%s", allowed, reason)
+	}
+	if len(decisions) != 1 || decisions[0] != ReasonGroupOpen {
+		t.Errorf("Expected OnDecision to report group_open rejection, got %v", decisions)
+	}
+
+	// Отказ группы не расходует одиночную пробу участника
+	m.Reset("eu-1")
+	m.Reset("eu-2")
+	if allowed, state, reason := m.AllowRequestDetailed("eu-3"); !allowed || state != stateHalfOpen {
+		t.Fatalf("Expected probe to be admitted after group closed, got %v/%s/%s", allowed, state, reason)
+	}
+	m.ReportSuccess("eu-3")
+	if state := m.Peek("eu-3"); state != stateClosed {
+		t.Errorf("Expected member to recover, got %s", state)
+	}
+}
+
+func TestGroupGate_OpenMemberRecovers(t *testing.T) {
+	clock := newFakeClock()
+	servers := []string{"eu-1", "eu-2"}
+	m := NewCBManager()
+	m.InitCircuitBreakers(servers, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Minute, Clock: clock})
+	m.NewGroup("eu", servers)
+	m.SetGroupConf("eu", GroupConf{OpenFraction: 0.5, GateMembers: true})
+	m.ReportFailure("eu-1")
+	m.ReportFailure("eu-2")
+
+	// Заблокированный группой участник все равно переходит в half-open по таймауту,
+	// иначе группа из разомкнутых участников не восстановилась бы никогда
+	clock.Advance(time.Minute)
+	m.AllowRequest("eu-1")
+	m.AllowRequest("eu-2")
+	if state := m.GroupState("eu"); state != stateClosed {
+		t.Errorf("Expected group to close once members are half-open, got %s", state)
+	}
+}
+
+func TestRemoveCircuitBreaker_LeavesGroupsAndLinks(t *testing.T) {
+	m := NewCBManager()
+	cfg := CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Minute}
+	m.InitCircuitBreakers([]string{"eu-1", "eu-2", "us-1"}, cfg)
+	m.NewGroup("eu", []string{"eu-1", "eu-2"})
+	m.SetGroupConf("eu", GroupConf{OpenFraction: 0.5, GateMembers: true})
+	m.Link("eu-1", "us-1")
+	m.ReportFailure("eu-1")
+
+	// Удаленный сервер больше не размыкает группу и не участвует в связях
+	if err := m.RemoveCircuitBreaker("eu-1"); err != nil {
+		t.Fatal(err)
+	}
+	if state := m.GroupState("eu"); state != stateClosed {
+		t.Errorf("Expected group closed after removing open member, got %s", state)
+	}
+	if _, ok := m.memberOf["eu-1"]; ok {
+		t.Error("Expected removed server to leave group index")
+	}
+	if len(m.links) != 0 {
+		t.Errorf("Expected links of removed server to be dropped, got %v", m.links)
+	}
+
+	// Повторно добавленный сервер не наследует членство
+	m.AddCircuitBreaker("eu-1", cfg)
+	m.ReportFailure("eu-1")
+	if allowed, _ := m.AllowRequest("eu-2"); !allowed {
+		t.Error("Expected re-added server not to gate former group")
+	}
+
+	// Группа без участников удаляется
+	m.RemoveCircuitBreaker("eu-2")
+	if state := m.GroupState("eu"); state != notConfigured {
+		t.Errorf("Expected empty group to be removed, got %s", state)
+	}
+}
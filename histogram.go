@@ -0,0 +1,91 @@
+package circuitbreaker
+
+import "time"
+
+// BucketSample - количество результатов за один интервал гистограммы
+type BucketSample struct {
+	Start     time.Time // начало интервала
+	Successes int
+	Failures  int
+}
+
+// histogram - кольцевой буфер интервалов фиксированной длительности
+type histogram struct {
+	bucket  time.Duration
+	buckets []BucketSample
+	first   int64 // номер первого интервала, в котором велся учет
+}
+
+func newHistogram(bucket, retention time.Duration, now time.Time) *histogram {
+	n := max(int(retention/bucket), 1)
+	return &histogram{
+		bucket:  bucket,
+		buckets: make([]BucketSample, n),
+		first:   now.UnixNano() / int64(bucket),
+	}
+}
+
+// slot возвращает интервал для момента t, сбрасывая устаревшее содержимое ячейки
+func (h *histogram) slot(t time.Time) *BucketSample {
+	idx := t.UnixNano() / int64(h.bucket)
+	b := &h.buckets[idx%int64(len(h.buckets))]
+	if start := time.Unix(0, idx*int64(h.bucket)).In(t.Location()); !b.Start.Equal(start) {
+		*b = BucketSample{Start: start}
+	}
+	return b
+}
+
+func (h *histogram) add(t time.Time, failed bool) {
+	b := h.slot(t)
+	if failed {
+		b.Failures++
+	} else {
+		b.Successes++
+	}
+}
+
+// samples возвращает непрерывный ряд интервалов за период хранения, заканчивающийся
+// текущим, в хронологическом порядке. Интервалы без результатов имеют нулевые счетчики.
+// Для момента раньше начала учета возвращается nil.
+func (h *histogram) samples(now time.Time) []BucketSample {
+	cur := now.UnixNano() / int64(h.bucket)
+	from := max(cur-int64(len(h.buckets))+1, h.first)
+	// Часы ушли назад раньше начала учета
+	if cur < from {
+		return nil
+	}
+
+	out := make([]BucketSample, 0, cur-from+1)
+	for idx := from; idx <= cur; idx++ {
+		start := time.Unix(0, idx*int64(h.bucket)).In(now.Location())
+		b := h.buckets[idx%int64(len(h.buckets))]
+		if !b.Start.Equal(start) {
+			b = BucketSample{Start: start}
+		}
+		b.Start = start
+		out = append(out, b)
+	}
+	return out
+}
+
+// failureHistogram возвращает гистограмму результатов CB (nil, если она отключена)
+func (cb *CircuitBreaker) failureHistogram() []BucketSample {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	if cb.hist == nil {
+		return nil
+	}
+	return cb.hist.samples(cb.clock.Now())
+}
+
+// FailureHistogram возвращает количество успехов и неудач сервера по интервалам
+// HistogramBucket за последние HistogramRetention, от старых к новым.
+// Для ненастроенного сервера и CB без включенной гистограммы возвращается nil.
+func (m *CBManager) FailureHistogram(serverURL string) []BucketSample {
+	cb := m.GetCircuitBreaker(serverURL)
+	if cb == nil {
+		return nil
+	}
+	return cb.failureHistogram()
+}
@@ -0,0 +1,61 @@
+package circuitbreaker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFailureHistogram(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold:   100,
+		HistogramBucket:    time.Minute,
+		HistogramRetention: 3 * time.Minute,
+		Clock:              clock,
+	})
+	start := clock.Now()
+
+	m.ReportFailure("test-server")
+	m.ReportSuccess("test-server")
+	clock.Advance(time.Minute)
+	m.ReportFailure("test-server")
+	m.ReportFailure("test-server")
+	clock.Advance(2 * time.Minute) // интервал без результатов
+	m.ReportSuccess("test-server")
+
+	// Первый интервал вышел за пределы хранения
+	want := []BucketSample{
+		{Start: start.Add(time.Minute), Failures: 2},
+		{Start: start.Add(2 * time.Minute)},
+		{Start: start.Add(3 * time.Minute), Successes: 1},
+	}
+	if got := m.FailureHistogram("test-server"); !reflect.DeepEqual(got, want) {
+		t.Errorf("FailureHistogram =\n%v\nwant\n%v", got, want)
+	}
+
+	// Гистограмма выключена по умолчанию
+	m.InitCircuitBreakers([]string{"plain-server"}, CircuitBreakerConf{})
+	m.ReportFailure("plain-server")
+	if got := m.FailureHistogram("plain-server"); got != nil {
+		t.Errorf("Expected nil histogram by default, got %v", got)
+	}
+}
+
+func TestFailureHistogram_ClockBackwards(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold:   100,
+		HistogramBucket:    time.Minute,
+		HistogramRetention: 3 * time.Minute,
+		Clock:              clock,
+	})
+
+	// Часы ушли назад раньше начала учета: ряд пуст, без паники
+	clock.Advance(-time.Hour)
+	if got := m.FailureHistogram("test-server"); got != nil {
+		t.Errorf("Expected nil histogram before start, got %v", got)
+	}
+}
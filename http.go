@@ -0,0 +1,255 @@
+package circuitbreaker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// httpConf содержит настройки HTTP-интеграции (RoundTripper и Middleware)
+type httpConf struct {
+	isFailure func(statusCode int) bool
+	skip      func(*http.Request) bool
+	onOpen    func(*http.Request) *http.Response
+}
+
+// HTTPOption настраивает RoundTripper и Middleware
+type HTTPOption func(*httpConf)
+
+// WithFailureStatus задает функцию, определяющую, какие коды ответа считаются неудачей.
+// По умолчанию используется DefaultIsFailureStatus.
+func WithFailureStatus(fn func(statusCode int) bool) HTTPOption {
+	return func(c *httpConf) {
+		if fn != nil {
+			c.isFailure = fn
+		}
+	}
+}
+
+// DefaultIsFailureStatus считает неудачей коды 500-599 и 429 (Too Many Requests)
+func DefaultIsFailureStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || (statusCode >= 500 && statusCode <= 599)
+}
+
+// WithSkipAccounting задает предикат запросов, исход которых не учитывается CB
+// (например, повторы идемпотентных запросов или health-check пути). Такие запросы
+// по-прежнему отклоняются в open, но не влияют на счетчики и не расходуют квоту half-open.
+func WithSkipAccounting(fn func(*http.Request) bool) HTTPOption {
+	return func(c *httpConf) {
+		c.skip = fn
+	}
+}
+
+// WithOpenResponse задает ответ на запрос, который CB не пропустил (например, кэшированные
+// данные или фирменная страница ошибки). Если fn возвращает nil, используется ответ по
+// умолчанию: 503 с JSON-телом {"error": ..., "server": ...}. Заголовок Retry-After
+// добавляется, если fn его не задала. Middleware отдает этот ответ клиенту всегда,
+// а RoundTripper - только при заданной опции (без нее возвращается ошибка ErrCircuitOpen).
+func WithOpenResponse(fn func(*http.Request) *http.Response) HTTPOption {
+	return func(c *httpConf) {
+		c.onOpen = fn
+	}
+}
+
+// openResponse формирует ответ на запрос r, отклоненный CB с ключом key
+func (c *httpConf) openResponse(m *CBManager, key string, r *http.Request) *http.Response {
+	var resp *http.Response
+	if c.onOpen != nil {
+		resp = c.onOpen(r)
+	}
+	if resp == nil {
+		body, _ := json.Marshal(map[string]string{"error": ErrCircuitOpen.Error(), "server": key})
+		resp = &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(bytes.NewReader(body)),
+		}
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		retryAfter, _ := m.RetryAfter(key)
+		resp.Header.Set("Retry-After", retryAfterSeconds(retryAfter))
+	}
+	if resp.Body == nil {
+		resp.Body = http.NoBody
+	}
+	if resp.Status == "" {
+		resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+	resp.Request = r
+	return resp
+}
+
+// writeResponse отдает клиенту синтезированный ответ resp
+func writeResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// skipped сообщает, что исход запроса не должен учитываться
+func (c *httpConf) skipped(r *http.Request) bool {
+	return c.skip != nil && c.skip(r)
+}
+
+// blockedUnaccounted проверяет неучитываемый запрос: он блокируется только в open,
+// состояние CB при этом не меняется
+func blockedUnaccounted(m *CBManager, key string) (bool, State) {
+	state := m.Peek(key)
+	return state == stateOpen, state
+}
+
+func newHTTPConf(opts []HTTPOption) *httpConf {
+	c := &httpConf{isFailure: DefaultIsFailureStatus}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// roundTripper защищает исходящие HTTP-запросы circuit breaker'ом
+type roundTripper struct {
+	m     *CBManager
+	next  http.RoundTripper
+	keyFn func(*http.Request) string
+	conf  *httpConf
+}
+
+// NewRoundTripper возвращает http.RoundTripper, пропускающий запросы через CB с ключом keyFn(req).
+// Если next равен nil, используется http.DefaultTransport. Если CB не пропускает запрос,
+// возвращается ошибка, обернутая в ErrCircuitOpen (или ответ, заданный WithOpenResponse).
+// Транспортные ошибки и коды ответа, признанные неудачей (см. WithFailureStatus),
+// учитываются как неудачи.
+func NewRoundTripper(m *CBManager, next http.RoundTripper, keyFn func(*http.Request) string, opts ...HTTPOption) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &roundTripper{m: m, next: next, keyFn: keyFn, conf: newHTTPConf(opts)}
+}
+
+// RoundTrip реализует http.RoundTripper
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := rt.keyFn(req)
+	if rt.conf.skipped(req) {
+		if blocked, state := blockedUnaccounted(rt.m, key); blocked {
+			return rt.rejected(req, key, state)
+		}
+		return rt.next.RoundTrip(req)
+	}
+	if allowed, state := rt.m.AllowRequest(key); !allowed {
+		return rt.rejected(req, key, state)
+	}
+
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil || rt.conf.isFailure(resp.StatusCode) {
+		rt.m.ReportFailure(key)
+	} else {
+		rt.m.ReportSuccess(key)
+	}
+	return resp, err
+}
+
+// rejected возвращает результат запроса, который CB не пропустил. Как и любой
+// RoundTripper, закрывает тело запроса, даже если запрос не отправлен.
+func (rt *roundTripper) rejected(req *http.Request, key string, state State) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if rt.conf.onOpen != nil {
+		return rt.conf.openResponse(rt.m, key, req), nil
+	}
+	return nil, fmt.Errorf("%w: %s (%s)", ErrCircuitOpen, key, state)
+}
+
+// Middleware возвращает middleware для net/http сервера, защищающий обработчик circuit breaker'ом.
+// keyFn определяет ключ CB для запроса (например, маршрут или upstream), что позволяет
+// разделять отказы по маршрутам. Если CB не пропускает запрос, клиенту возвращается
+// 503 с JSON-описанием ошибки и заголовком Retry-After (см. WithOpenResponse). Иначе
+// статус ответа обработчика отслеживается: коды, признанные неудачей (см. WithFailureStatus),
+// учитываются как неудачи, остальные - как успех.
+func Middleware(m *CBManager, keyFn func(*http.Request) string, opts ...HTTPOption) func(http.Handler) http.Handler {
+	conf := newHTTPConf(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFn(r)
+			skip := conf.skipped(r)
+			allowed := true
+			if skip {
+				blocked, _ := blockedUnaccounted(m, key)
+				allowed = !blocked
+			} else {
+				allowed, _ = m.AllowRequest(key)
+			}
+			if !allowed {
+				writeResponse(w, conf.openResponse(m, key, r))
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			if skip {
+				return
+			}
+			if conf.isFailure(sw.status) {
+				m.ReportFailure(key)
+			} else {
+				m.ReportSuccess(key)
+			}
+		})
+	}
+}
+
+// retryAfterSeconds форматирует длительность для заголовка Retry-After (целые секунды, не меньше 1)
+func retryAfterSeconds(d time.Duration) string {
+	secs := int(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.Itoa(secs)
+}
+
+// statusWriter запоминает код ответа, записанный обработчиком
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush передает буферизованные данные клиенту, если исходный ResponseWriter это
+// поддерживает (нужно потоковым обработчикам, например EventStreamHandler)
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного ResponseWriter
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
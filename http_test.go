@@ -0,0 +1,355 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	clock := newFakeClock()
+	cfg := CircuitBreakerConf{
+		FailureThreshold: 2,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	}
+
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"/api"}, cfg)
+
+	var failing atomic.Bool
+	failing.Store(true)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	srv := httptest.NewServer(Middleware(m, func(r *http.Request) string { return r.URL.Path })(handler))
+	defer srv.Close()
+
+	get := func() *http.Response {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/api")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// Две ошибки 5xx переводят маршрут в open
+	for i := 0; i < cfg.FailureThreshold; i++ {
+		if resp := get(); resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("Expected 500 from handler, got %d", resp.StatusCode)
+		}
+	}
+	if state := m.GetCircuitBreakerState("/api"); state != "open" {
+		t.Fatalf("Expected 'open', got '%s'", state)
+	}
+
+	// В open запрос отклоняется без вызова обработчика
+	resp := get()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for open CB, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header for open CB")
+	}
+
+	// После таймаута и успешного ответа маршрут возвращается в closed
+	failing.Store(false)
+	clock.Advance(cfg.RecoveryTimeout)
+	if resp := get(); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 after recovery, got %d", resp.StatusCode)
+	}
+	if state := m.GetCircuitBreakerState("/api"); state != "closed" {
+		t.Errorf("Expected 'closed', got '%s'", state)
+	}
+}
+
+func TestDefaultIsFailureStatus(t *testing.T) {
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNotFound, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		if got := DefaultIsFailureStatus(tt.code); got != tt.want {
+			t.Errorf("DefaultIsFailureStatus(%d) = %t, want %t", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestRoundTripper_FailureStatus(t *testing.T) {
+	var status atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer backend.Close()
+
+	cfg := CircuitBreakerConf{FailureThreshold: 2, RecoveryTimeout: time.Minute}
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"backend"}, cfg)
+
+	client := &http.Client{Transport: NewRoundTripper(m, nil, func(*http.Request) string { return "backend" })}
+	do := func() error {
+		resp, err := client.Get(backend.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// 404 не считается неудачей
+	status.Store(http.StatusNotFound)
+	for i := 0; i < 5; i++ {
+		if err := do(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if state := m.GetCircuitBreakerState("backend"); state != "closed" {
+		t.Fatalf("Expected 'closed' after 404 responses, got '%s'", state)
+	}
+
+	// 429 считается неудачей и размыкает CB
+	status.Store(http.StatusTooManyRequests)
+	do()
+	do()
+	if state := m.GetCircuitBreakerState("backend"); state != "open" {
+		t.Fatalf("Expected 'open' after 429 responses, got '%s'", state)
+	}
+	if err := do(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+}
+
+func TestMiddleware_CustomFailureStatus(t *testing.T) {
+	cfg := CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Minute}
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"route"}, cfg)
+
+	var status atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	})
+	// 501 не считаем неудачей, 404 - считаем
+	onlyNotFound := func(code int) bool { return code == http.StatusNotFound }
+	h := Middleware(m, func(*http.Request) string { return "route" }, WithFailureStatus(onlyNotFound))(handler)
+
+	status.Store(http.StatusNotImplemented)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if state := m.GetCircuitBreakerState("route"); state != "closed" {
+		t.Fatalf("Expected 'closed' after 501, got '%s'", state)
+	}
+
+	status.Store(http.StatusNotFound)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if state := m.GetCircuitBreakerState("route"); state != "open" {
+		t.Fatalf("Expected 'open' after 404, got '%s'", state)
+	}
+}
+
+func TestRoundTripper_SkipAccounting(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"upstream"}, CircuitBreakerConf{
+		FailureThreshold: 2,
+		RecoveryTimeout:  time.Hour,
+	})
+
+	var calls atomic.Int32
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		return &http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody}, nil
+	})
+	rt := NewRoundTripper(m, next, func(*http.Request) string { return "upstream" },
+		WithSkipAccounting(func(r *http.Request) bool { return r.Header.Get("X-Retry") != "" }))
+
+	retry, _ := http.NewRequest(http.MethodGet, "http://upstream/", nil)
+	retry.Header.Set("X-Retry", "1")
+	plain, _ := http.NewRequest(http.MethodGet, "http://upstream/", nil)
+
+	// Помеченные запросы не приводят к срабатыванию
+	for i := 0; i < 5; i++ {
+		if _, err := rt.RoundTrip(retry); err != nil {
+			t.Fatalf("Unexpected error for skipped request: %v", err)
+		}
+	}
+	if state := m.GetCircuitBreakerState("upstream"); state != "closed" {
+		t.Fatalf("Expected skipped failures not to trip CB, got '%s'", state)
+	}
+
+	for i := 0; i < 2; i++ {
+		rt.RoundTrip(plain)
+	}
+	if state := m.GetCircuitBreakerState("upstream"); state != "open" {
+		t.Fatalf("Expected 'open', got '%s'", state)
+	}
+
+	// В open помеченный запрос все равно блокируется
+	before := calls.Load()
+	if _, err := rt.RoundTrip(retry); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen for skipped request, got %v", err)
+	}
+	if calls.Load() != before {
+		t.Error("Expected skipped request not to reach transport when open")
+	}
+}
+
+func TestMiddleware_SkipAccounting(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"/health"}, CircuitBreakerConf{FailureThreshold: 1})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	h := Middleware(m, func(r *http.Request) string { return r.URL.Path },
+		WithSkipAccounting(func(r *http.Request) bool { return r.URL.Query().Has("probe") }))(handler)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health?probe", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500 from handler, got %d", rec.Code)
+	}
+	if state := m.GetCircuitBreakerState("/health"); state != "closed" {
+		t.Fatalf("Expected skipped request not to trip CB, got '%s'", state)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health?probe", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for skipped request when open, got %d", rec.Code)
+	}
+}
+
+// roundTripFunc позволяет использовать функцию как http.RoundTripper в тестах
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestMiddleware_OpenResponse(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"/api"}, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+	m.ReportFailure("/api")
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected handler not to be called for open CB")
+	})
+	keyFn := func(r *http.Request) string { return r.URL.Path }
+
+	// По умолчанию - 503 с JSON-телом и Retry-After
+	rec := httptest.NewRecorder()
+	Middleware(m, keyFn)(handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected 503 JSON response, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["server"] != "/api" {
+		t.Errorf("Unexpected body %q (%v)", rec.Body.String(), err)
+	}
+	if rec.Header().Get("Retry-After") != "3600" {
+		t.Errorf("Expected Retry-After 3600, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	// Пользовательский ответ, например кэшированные данные
+	cached := WithOpenResponse(func(r *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"X-Cache": {"hit"}},
+			Body:       io.NopCloser(strings.NewReader("cached")),
+		}
+	})
+	rec = httptest.NewRecorder()
+	Middleware(m, keyFn, cached)(handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "cached" || rec.Header().Get("X-Cache") != "hit" {
+		t.Errorf("Expected cached response, got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After to be added to custom response")
+	}
+}
+
+func TestRoundTripper_OpenResponse(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"upstream"}, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+	m.ReportFailure("upstream")
+
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Error("Expected request not to reach transport when open")
+		return nil, errors.New("unexpected")
+	})
+	rt := NewRoundTripper(m, next, func(*http.Request) string { return "upstream" },
+		WithOpenResponse(func(r *http.Request) *http.Response {
+			return &http.Response{StatusCode: http.StatusTeapot, Header: http.Header{"Retry-After": {"7"}}}
+		}))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://upstream/", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Expected custom response instead of error, got %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot || resp.Request != req {
+		t.Errorf("Unexpected response: %d", resp.StatusCode)
+	}
+	if ra := resp.Header.Get("Retry-After"); ra != "7" {
+		t.Errorf("Expected custom Retry-After to be kept, got %q", ra)
+	}
+}
+
+// closeTracker отмечает закрытие тела запроса
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRoundTripper_RejectedClosesBody(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"upstream"}, CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour})
+	m.ReportFailure("upstream")
+
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Error("Expected request not to reach transport when open")
+		return nil, errors.New("unexpected")
+	})
+	keyFn := func(*http.Request) string { return "upstream" }
+	openResponse := WithOpenResponse(func(r *http.Request) *http.Response {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable}
+	})
+
+	// Отклоненный запрос закрывает тело и при ошибке, и при синтетическом ответе
+	for name, rt := range map[string]http.RoundTripper{
+		"error":    NewRoundTripper(m, next, keyFn),
+		"response": NewRoundTripper(m, next, keyFn, openResponse),
+	} {
+		body := &closeTracker{Reader: strings.NewReader("payload")}
+		req, _ := http.NewRequest(http.MethodPost, "http://upstream/", body)
+		if resp, _ := rt.RoundTrip(req); resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if !body.closed {
+			t.Errorf("%s: expected request body to be closed on rejection", name)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Link связывает CB серверов a и b (например, "db:read" и "db:write" одного бэкенда),
+// чтобы они разделяли сигнал восстановления: когда один из них восстанавливается
+// (переход half-open -> closed), разомкнутый связанный CB оптимистично переводится
+// в half-open, как при TriggerProbe, не дожидаясь своего RecoveryTimeout. Решение
+// о замыкании связанный CB по-прежнему принимает по результатам собственных проб.
+// Связь симметрична и не транзитивна; CB могут быть зарегистрированы позже.
+func (m *CBManager) Link(a, b string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, b = m.keyLocked(a), m.keyLocked(b)
+	if a == b {
+		return fmt.Errorf("%w: cannot link circuit breaker to itself: %s", ErrInvalidConfig, a)
+	}
+	if !slices.Contains(m.links[a], b) {
+		m.links[a] = append(m.links[a], b)
+		m.links[b] = append(m.links[b], a)
+	}
+	return nil
+}
+
+// unlinkLocked удаляет все связи сервера srv. Вызывается под m.mu.
+func (m *CBManager) unlinkLocked(srv string) {
+	for _, peer := range m.links[srv] {
+		linked := slices.DeleteFunc(m.links[peer], func(s string) bool { return s == srv })
+		if len(linked) == 0 {
+			delete(m.links, peer)
+		} else {
+			m.links[peer] = linked
+		}
+	}
+	delete(m.links, srv)
+}
+
+// propagateRecovery запускает пробы на CB, связанных с восстановившимся CB name.
+// Вызывается вне блокировок CB.
+func (m *CBManager) propagateRecovery(name string) {
+	m.mu.RLock()
+	linked := slices.Clone(m.links[name])
+	m.mu.RUnlock()
+
+	for _, srv := range linked {
+		if cb := m.GetCircuitBreaker(srv); cb != nil {
+			cb.triggerProbe()
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLink(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"db:read", "db:write", "cache"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+	})
+	if err := m.Link("db:read", "db:write"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Link("db:read", "db:read"); err == nil {
+		t.Error("Expected error when linking breaker to itself")
+	}
+
+	// Чтение размыкается позже записи, поэтому его таймаут еще не истек
+	m.ReportFailure("db:write")
+	m.ReportFailure("cache")
+	clock.Advance(20 * time.Second)
+	m.ReportFailure("db:read")
+	clock.Advance(10 * time.Second)
+
+	// Восстановление записи запускает пробу чтения
+	m.AllowRequest("db:write")
+	m.ReportSuccess("db:write")
+	if state := m.GetCircuitBreakerState("db:write"); state != "closed" {
+		t.Fatalf("Expected db:write to recover, got '%s'", state)
+	}
+	if state := m.GetCircuitBreakerState("db:read"); state != "half-open" {
+		t.Errorf("Expected linked db:read to be probing, got '%s'", state)
+	}
+	if state := m.Peek("cache"); state != stateHalfOpen {
+		t.Fatalf("Expected unlinked cache to reach half-open by timeout, got %s", state)
+	}
+
+	// Восстановление несвязанного CB не влияет на остальные
+	m.ReportFailure("db:write")
+	m.AllowRequest("cache")
+	m.ReportSuccess("cache")
+	if state := m.GetCircuitBreakerState("cache"); state != "closed" {
+		t.Fatalf("Expected cache to recover, got '%s'", state)
+	}
+	if state := m.GetCircuitBreakerState("db:write"); state != "open" {
+		t.Errorf("Expected unlinked db:write to stay open, got '%s'", state)
+	}
+}
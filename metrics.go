@@ -0,0 +1,179 @@
+package circuitbreaker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// BreakerMetric - плоский снимок показателей одного Circuit Breaker для отправки
+// в произвольную систему метрик (StatsD, собственные агрегаторы и т.п.)
+type BreakerMetric struct {
+	Name          string
+	State         int // числовое значение State: 0 - closed, 1 - open, 2 - half-open
+	FailureCount  int
+	SuccessCount  int
+	TotalRejected uint64
+	Transitions   int
+	InFlight      int64
+	Labels        map[string]string // метки из CircuitBreakerConf.Labels (копия)
+}
+
+// MetricsSnapshot возвращает показатели всех CB, снятые под одной блокировкой менеджера.
+// Выделяется только результирующий срез, поэтому снимок дешево снимать по таймеру.
+func (m *CBManager) MetricsSnapshot() []BreakerMetric {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]BreakerMetric, 0, len(m.breakers))
+	for srv, cb := range m.breakers {
+		out = append(out, cb.metric(srv))
+	}
+	return out
+}
+
+// metric возвращает снимок показателей CB
+func (cb *CircuitBreaker) metric(name string) BreakerMetric {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	return BreakerMetric{
+		Name:          name,
+		State:         int(cb.state),
+		FailureCount:  cb.failureCount,
+		SuccessCount:  cb.successCount,
+		TotalRejected: cb.rejected.Load(),
+		Transitions:   cb.transaction,
+		InFlight:      cb.inFlight.Load(),
+		Labels:        maps.Clone(cb.conf.Labels),
+	}
+}
+
+// AggregatedStats - суммарные показатели всех CB менеджера
+type AggregatedStats struct {
+	TotalRequests    int64 // запросы с сообщенным результатом (успехи и неудачи)
+	TotalFailures    int64
+	TotalRejections  int64
+	TotalTransitions int64 // переходы closed -> open и half-open -> closed
+	OpenCount        int
+	HalfOpenCount    int
+	ClosedCount      int
+}
+
+// AggregateStats суммирует показатели всех CB, снятые под одной блокировкой менеджера.
+// CB, зарегистрированный под несколькими именами (InitSharedBreaker), учитывается один раз.
+func (m *CBManager) AggregateStats() AggregatedStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var agg AggregatedStats
+	seen := make(map[*CircuitBreaker]struct{}, len(m.breakers))
+	for _, cb := range m.breakers {
+		if _, ok := seen[cb]; ok {
+			continue
+		}
+		seen[cb] = struct{}{}
+		cb.aggregate(&agg)
+	}
+	return agg
+}
+
+// aggregate добавляет показатели CB к agg
+func (cb *CircuitBreaker) aggregate(agg *AggregatedStats) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	agg.TotalRequests += int64(cb.totalSuccesses + cb.totalFailures)
+	agg.TotalFailures += int64(cb.totalFailures)
+	agg.TotalRejections += int64(cb.rejected.Load())
+	agg.TotalTransitions += int64(cb.transaction)
+	switch cb.state {
+	case stateOpen:
+		agg.OpenCount++
+	case stateHalfOpen:
+		agg.HalfOpenCount++
+	default:
+		agg.ClosedCount++
+	}
+}
+
+// promMetric описывает метрику в формате Prometheus и способ получить ее значение
+type promMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func(BreakerMetric) float64
+}
+
+var promMetrics = []promMetric{
+	{"circuitbreaker_state", "Current state: 0 - closed, 1 - open, 2 - half-open.", "gauge",
+		func(bm BreakerMetric) float64 { return float64(bm.State) }},
+	{"circuitbreaker_failures", "Failures counted towards the trip threshold.", "gauge",
+		func(bm BreakerMetric) float64 { return float64(bm.FailureCount) }},
+	{"circuitbreaker_half_open_successes", "Successful probes in the current half-open period.", "gauge",
+		func(bm BreakerMetric) float64 { return float64(bm.SuccessCount) }},
+	{"circuitbreaker_in_flight", "Requests admitted via Acquire/Execute and still running.", "gauge",
+		func(bm BreakerMetric) float64 { return float64(bm.InFlight) }},
+	{"circuitbreaker_rejected_total", "Requests rejected by the breaker.", "counter",
+		func(bm BreakerMetric) float64 { return float64(bm.TotalRejected) }},
+	{"circuitbreaker_transitions_total", "Transitions closed -> open and half-open -> closed.", "counter",
+		func(bm BreakerMetric) float64 { return float64(bm.Transitions) }},
+}
+
+// WriteMetrics записывает показатели всех CB в текстовом формате Prometheus
+// (с # HELP и # TYPE) без зависимости от библиотек Prometheus. Имя CB передается
+// меткой name, метки из CircuitBreakerConf.Labels добавляются к ней (недопустимые
+// символы в именах меток заменяются на "_"). CB упорядочены по имени.
+func (m *CBManager) WriteMetrics(w io.Writer) error {
+	snapshot := m.MetricsSnapshot()
+	slices.SortFunc(snapshot, func(a, b BreakerMetric) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, pm := range promMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", pm.name, pm.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", pm.name, pm.kind)
+		for _, bm := range snapshot {
+			fmt.Fprintf(bw, "%s{%s} %g\n", pm.name, promLabels(bm), pm.value(bm))
+		}
+	}
+	return bw.Flush()
+}
+
+// promLabels форматирует метки CB для текстового формата Prometheus
+func promLabels(bm BreakerMetric) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `name="%s"`, promEscape(bm.Name))
+	for _, k := range slices.Sorted(maps.Keys(bm.Labels)) {
+		name := promLabelName(k)
+		if name == "name" {
+			continue
+		}
+		fmt.Fprintf(&b, `,%s="%s"`, name, promEscape(bm.Labels[k]))
+	}
+	return b.String()
+}
+
+// promLabelName приводит имя метки к допустимому виду [a-zA-Z_][a-zA-Z0-9_]*
+func promLabelName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		ok := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !ok {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+// promEscape экранирует значение метки
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
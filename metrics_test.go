@@ -0,0 +1,177 @@
+package circuitbreaker
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsSnapshot(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"server1", "server2"}, CircuitBreakerConf{FailureThreshold: 2, RecoveryTimeout: time.Minute})
+
+	m.ReportFailure("server1")
+	m.ReportFailure("server1")
+	m.AllowRequest("server1")
+	m.AllowRequest("server1")
+	m.ReportFailure("server2")
+
+	metrics := make(map[string]BreakerMetric)
+	for _, bm := range m.MetricsSnapshot() {
+		metrics[bm.Name] = bm
+	}
+	if len(metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(metrics))
+	}
+
+	want := map[string]BreakerMetric{
+		"server1": {Name: "server1", State: int(stateOpen), FailureCount: 2, TotalRejected: 2, Transitions: 1},
+		"server2": {Name: "server2", State: int(stateClosed), FailureCount: 1},
+	}
+	for name, w := range want {
+		if !reflect.DeepEqual(metrics[name], w) {
+			t.Errorf("metric %s = %+v, want %+v", name, metrics[name], w)
+		}
+	}
+}
+
+func BenchmarkMetricsSnapshot(b *testing.B) {
+	servers := make([]string, 1000)
+	for i := range servers {
+		servers[i] = fmt.Sprintf("server-%d", i)
+	}
+	m := NewCBManager()
+	m.InitCircuitBreakers(servers, CircuitBreakerConf{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = m.MetricsSnapshot()
+	}
+}
+
+func TestLabels(t *testing.T) {
+	labels := map[string]string{"region": "eu", "team": "payments"}
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{Labels: labels})
+
+	// Изменение исходной карты не влияет на CB
+	labels["region"] = "us"
+	want := map[string]string{"region": "eu", "team": "payments"}
+
+	stats := m.GetCircuitBreakerStats()["test-server"].(map[string]any)
+	if got := stats["labels"].(map[string]string); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected labels %v in stats, got %v", want, got)
+	}
+	if got := m.MetricsSnapshot()[0].Labels; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected labels %v in metrics, got %v", want, got)
+	}
+
+	// Метки обновляются через UpdateConfig
+	m.UpdateConfig("test-server", CircuitBreakerConf{Labels: map[string]string{"tier": "1"}})
+	if got := m.MetricsSnapshot()[0].Labels; !reflect.DeepEqual(got, map[string]string{"tier": "1"}) {
+		t.Errorf("Expected labels to be updated, got %v", got)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"b", "a"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Minute,
+		Labels:           map[string]string{"region": `eu "west"`, "team-name": "core"},
+	})
+	m.ReportFailure("b")
+	m.AllowRequest("b")
+
+	var buf bytes.Buffer
+	if err := m.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	// Проверяем синтаксис текстового формата: комментарии HELP/TYPE и строки образцов
+	sample := regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)\{([a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*")*)\} (\S+)$`)
+	typed := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if f := strings.Fields(line); len(f) >= 4 && f[0] == "#" && f[1] == "TYPE" {
+			if f[3] != "gauge" && f[3] != "counter" {
+				t.Errorf("invalid type in %q", line)
+			}
+			typed[f[2]] = f[3]
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		match := sample.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("invalid sample line %q", line)
+		}
+		if _, ok := typed[match[1]]; !ok {
+			t.Errorf("sample %q precedes its TYPE line", line)
+		}
+		if _, err := strconv.ParseFloat(match[3], 64); err != nil {
+			t.Errorf("invalid value in %q: %v", line, err)
+		}
+	}
+
+	for _, want := range []string{
+		`circuitbreaker_state{name="a",region="eu \"west\"",team_name="core"} 0`,
+		`circuitbreaker_state{name="b",region="eu \"west\"",team_name="core"} 1`,
+		`circuitbreaker_rejected_total{name="b",region="eu \"west\"",team_name="core"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("Expected line %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, `{name="a"`) > strings.Index(out, `{name="b"`) {
+		t.Error("Expected breakers sorted by name")
+	}
+}
+
+func TestAggregateStats(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"open", "half-open", "closed", "idle"}, CircuitBreakerConf{
+		FailureThreshold:    2,
+		RecoveryTimeout:     time.Minute,
+		HalfOpenSingleProbe: true,
+		Clock:               clock,
+	})
+
+	m.ReportFailure("open")
+	m.ReportFailure("open")
+	m.AllowRequest("open")
+	m.AllowRequest("open")
+
+	m.ReportFailure("half-open")
+	m.ReportFailure("half-open")
+
+	m.ReportSuccess("closed")
+	m.ReportFailure("closed")
+
+	// "half-open" переходит в half-open при первом запросе после таймаута
+	clock.Advance(2 * time.Minute)
+	if ok, _ := m.AllowRequest("half-open"); !ok {
+		t.Fatal("Expected probe to be allowed")
+	}
+
+	want := AggregatedStats{
+		TotalRequests:    6,
+		TotalFailures:    5,
+		TotalRejections:  2,
+		TotalTransitions: 2,
+		OpenCount:        1,
+		HalfOpenCount:    1,
+		ClosedCount:      2,
+	}
+	if got := m.AggregateStats(); got != want {
+		t.Errorf("AggregateStats() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package circuitbreaker
+
+import "time"
+
+// Option изменяет конфигурацию Circuit Breaker, создаваемого через NewWithOptions
+type Option func(*CircuitBreakerConf)
+
+// NewWithOptions создает Circuit Breaker, собирая конфигурацию из опций.
+// Незаданные параметры получают значения по умолчанию, как и при создании из CircuitBreakerConf.
+func NewWithOptions(name string, opts ...Option) (*CircuitBreaker, error) {
+	var cfg CircuitBreakerConf
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return new(name, cfg)
+}
+
+// WithConfig задает базовую конфигурацию; последующие опции изменяют ее
+func WithConfig(cfg CircuitBreakerConf) Option {
+	return func(c *CircuitBreakerConf) { *c = cfg }
+}
+
+// WithFailureThreshold задает количество неудач до срабатывания
+func WithFailureThreshold(n int) Option {
+	return func(c *CircuitBreakerConf) { c.FailureThreshold = n }
+}
+
+// WithRecoveryTimeout задает время до попытки восстановления
+func WithRecoveryTimeout(d time.Duration) Option {
+	return func(c *CircuitBreakerConf) { c.RecoveryTimeout = d }
+}
+
+// WithSuccessThreshold задает количество успешных запросов для восстановления
+func WithSuccessThreshold(n int) Option {
+	return func(c *CircuitBreakerConf) { c.SuccessThreshold = n }
+}
+
+// WithHalfOpenPercent задает процент пропускаемых в half-open запросов
+func WithHalfOpenPercent(prc int) Option {
+	return func(c *CircuitBreakerConf) { c.HalfOpenPrc = prc }
+}
+
+// WithDecrementOnSuccess задает, уменьшает ли успех счетчик ошибок на единицу (true,
+// по умолчанию) или обнуляет его (false - размыкание только после ошибок подряд)
+func WithDecrementOnSuccess(enabled bool) Option {
+	return func(c *CircuitBreakerConf) { c.DecrementOnSuccess = &enabled }
+}
+
+// WithClock задает источник времени
+func WithClock(clock Clock) Option {
+	return func(c *CircuitBreakerConf) { c.Clock = clock }
+}
+
+// WithOnStateChange задает колбэк, вызываемый при каждом переходе между состояниями
+func WithOnStateChange(fn func(name string, from, to State)) Option {
+	return func(c *CircuitBreakerConf) { c.OnStateChange = fn }
+}
@@ -0,0 +1,93 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	clock := newFakeClock()
+	var transitions []string
+	cb, err := NewWithOptions("test",
+		WithFailureThreshold(2),
+		WithRecoveryTimeout(5*time.Second),
+		WithSuccessThreshold(1),
+		WithHalfOpenPercent(100),
+		WithClock(clock),
+		WithOnStateChange(func(name string, from, to State) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	cfg := cb.Config()
+	if cfg.FailureThreshold != 2 || cfg.RecoveryTimeout != 5*time.Second || cfg.SuccessThreshold != 1 || cfg.HalfOpenPrc != 100 {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	cb.Failure()
+	cb.Failure()
+	clock.Advance(5 * time.Second)
+	cb.Allow()
+	cb.Success()
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("transition %d = %s, want %s", i, transitions[i], want[i])
+		}
+	}
+}
+
+func TestNewWithOptions_Compose(t *testing.T) {
+	// Последующие опции переопределяют базовую конфигурацию, остальное получает значения по умолчанию
+	cb, err := NewWithOptions("test",
+		WithConfig(CircuitBreakerConf{FailureThreshold: 10, SuccessThreshold: 4}),
+		WithFailureThreshold(3),
+	)
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	cfg := cb.Config()
+	if cfg.FailureThreshold != 3 || cfg.SuccessThreshold != 4 || cfg.RecoveryTimeout != 30*time.Second {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	if _, err := NewWithOptions(""); err == nil {
+		t.Error("Expected error for empty name")
+	}
+}
+
+func TestWithDecrementOnSuccess(t *testing.T) {
+	// По умолчанию успех только уменьшает счетчик ошибок
+	cb, _ := NewWithOptions("test", WithFailureThreshold(3))
+	cb.Failure()
+	cb.Failure()
+	cb.Success()
+	if n := cb.Stats()["failure_count"]; n != 1 {
+		t.Errorf("Expected failure_count 1 by default, got %v", n)
+	}
+
+	// Без декремента один успех обнуляет накопленные ошибки
+	cb, _ = NewWithOptions("test", WithFailureThreshold(3), WithDecrementOnSuccess(false))
+	cb.Failure()
+	cb.Failure()
+	cb.Success()
+	if n := cb.Stats()["failure_count"]; n != 0 {
+		t.Errorf("Expected failure_count 0, got %v", n)
+	}
+	cb.Failure()
+	cb.Failure()
+	if s := cb.State(); s != StateClosed {
+		t.Errorf("Expected closed after 2 consecutive failures, got %s", s)
+	}
+	cb.Failure()
+	if s := cb.State(); s != StateOpen {
+		t.Errorf("Expected open after 3 consecutive failures, got %s", s)
+	}
+}
@@ -0,0 +1,44 @@
+package circuitbreaker
+
+import "time"
+
+// RecoveryPolicy решает, когда разомкнутый CB переходит в half-open и когда half-open
+// замыкается. Ограничения ядра (MaxRecoveryAttempts, AlwaysOpen, HalfOpenStabilizeDuration,
+// ramp_up) применяются дополнительно к решению политики.
+// Методы вызываются под блокировкой CB, возможно конкурентно и многократно для одного
+// и того же состояния (в том числе из Peek и WaitUntilAllowed), поэтому должны быть быстрыми,
+// безопасными для конкурентного использования и не обращаться к CB или менеджеру.
+// Время до восстановления по пользовательской политике неизвестно, поэтому RetryAfter
+// сообщает его только после наступления, а WaitUntilAllowed периодически перепроверяет политику.
+type RecoveryPolicy interface {
+	// ShouldProbe сообщает, пора ли перевести разомкнутый CB в half-open
+	ShouldProbe(s RecoverySnapshot) bool
+	// ShouldClose сообщает, достаточно ли успехов в half-open для замыкания
+	ShouldClose(s RecoverySnapshot) bool
+}
+
+// RecoverySnapshot - состояние восстановления CB, доступное RecoveryPolicy
+type RecoverySnapshot struct {
+	Name             string
+	OpenedAt         time.Time     // момент последнего перехода в open
+	HalfOpenAt       time.Time     // момент последнего перехода в half-open
+	Now              time.Time     // текущее время по часам CB
+	FailedRecoveries int           // неудачные попытки восстановления подряд
+	Successes        int           // успехи подряд в текущем half-open
+	RecoveryTimeout  time.Duration // RecoveryTimeout с учетом RecoveryBackoff
+	SuccessThreshold int           // настроенный SuccessThreshold
+}
+
+// FixedRecoveryPolicy - политика по умолчанию: half-open по истечении RecoveryTimeout,
+// замыкание после SuccessThreshold успехов
+type FixedRecoveryPolicy struct{}
+
+// ShouldProbe реализует RecoveryPolicy
+func (FixedRecoveryPolicy) ShouldProbe(s RecoverySnapshot) bool {
+	return s.Now.Sub(s.OpenedAt) >= s.RecoveryTimeout
+}
+
+// ShouldClose реализует RecoveryPolicy
+func (FixedRecoveryPolicy) ShouldClose(s RecoverySnapshot) bool {
+	return s.Successes >= s.SuccessThreshold
+}
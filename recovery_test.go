@@ -0,0 +1,126 @@
+package circuitbreaker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthCheckPolicy переводит CB в half-open после checks успешных внешних проверок
+// здоровья (вместо таймера) и замыкает его после одного успеха
+type healthCheckPolicy struct {
+	passed *atomic.Int32
+	checks int32
+}
+
+func (p healthCheckPolicy) ShouldProbe(s RecoverySnapshot) bool {
+	return p.passed.Load() >= p.checks
+}
+
+func (p healthCheckPolicy) ShouldClose(s RecoverySnapshot) bool {
+	return s.Successes >= 1
+}
+
+func TestRecoveryPolicy_Custom(t *testing.T) {
+	var passed atomic.Int32
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 5,
+		HalfOpenPrc:      100,
+		RecoveryPolicy:   healthCheckPolicy{passed: &passed, checks: 3},
+		Clock:            clock,
+	})
+
+	cb.failure()
+	// Истечение RecoveryTimeout не переводит CB в half-open
+	clock.Advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		passed.Add(1)
+		if allowed, state := cb.allow(); allowed || state != stateOpen {
+			t.Fatalf("Expected open after %d checks, got %v/%s", i+1, allowed, state)
+		}
+	}
+	if _, ok := cb.retryAfter(); ok {
+		t.Error("Expected unknown retry time before policy allows probing")
+	}
+
+	passed.Add(1)
+	if wait, ok := cb.retryAfter(); !ok || wait != 0 {
+		t.Errorf("Expected zero retry time once probing is due, got %v/%v", wait, ok)
+	}
+	if allowed, state := cb.allow(); !allowed || state != stateHalfOpen {
+		t.Fatalf("Expected half-open after 3 checks, got %v/%s", allowed, state)
+	}
+
+	// Политика замыкает CB раньше SuccessThreshold
+	cb.success()
+	if cb.curState() != stateClosed {
+		t.Errorf("Expected closed after one success, got %s", cb.curState())
+	}
+}
+
+// streakPolicy замыкает CB после n успехов подряд независимо от SuccessThreshold
+type streakPolicy struct {
+	FixedRecoveryPolicy
+	n int
+}
+
+func (p streakPolicy) ShouldClose(s RecoverySnapshot) bool {
+	return s.Successes >= p.n
+}
+
+func TestRecoveryPolicy_SuccessesAboveThreshold(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Second,
+		SuccessThreshold: 3,
+		HalfOpenPrc:      100,
+		RecoveryPolicy:   streakPolicy{n: 5},
+		Clock:            clock,
+	})
+	cb.failure()
+	clock.Advance(time.Second)
+	cb.allow()
+
+	// Политика видит полное число успехов, хотя счетчик в статистике ограничен порогом
+	for i := 0; i < 4; i++ {
+		cb.success()
+	}
+	if cb.curState() != stateHalfOpen {
+		t.Fatalf("Expected half-open after 4 successes, got %s", cb.curState())
+	}
+	if n := cb.stats()["success_count"]; n != 3 {
+		t.Errorf("Expected success_count clamped at 3, got %v", n)
+	}
+	cb.success()
+	if cb.curState() != stateClosed {
+		t.Errorf("Expected closed after 5 successes, got %s", cb.curState())
+	}
+}
+
+func TestRecoveryPolicy_Fixed(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := RecoverySnapshot{OpenedAt: start, RecoveryTimeout: time.Minute, SuccessThreshold: 2}
+	p := FixedRecoveryPolicy{}
+
+	s.Now = start.Add(59 * time.Second)
+	if p.ShouldProbe(s) {
+		t.Error("Expected no probe before RecoveryTimeout")
+	}
+	s.Now = start.Add(time.Minute)
+	if !p.ShouldProbe(s) {
+		t.Error("Expected probe after RecoveryTimeout")
+	}
+
+	s.Successes = 1
+	if p.ShouldClose(s) {
+		t.Error("Expected half-open below SuccessThreshold")
+	}
+	s.Successes = 2
+	if !p.ShouldClose(s) {
+		t.Error("Expected close at SuccessThreshold")
+	}
+}
@@ -0,0 +1,77 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryPolicy задает повторы для ExecuteWithRetry
+type RetryPolicy struct {
+	MaxAttempts int           // Максимальное количество попыток, включая первую (по умолчанию 1)
+	Backoff     time.Duration // Пауза перед второй попыткой
+	MaxBackoff  time.Duration // Верхняя граница паузы (0 - без ограничения)
+	Multiplier  float64       // Множитель паузы для каждой следующей попытки (по умолчанию 2)
+
+	// Retryable определяет, имеет ли смысл повторять запрос после ошибки
+	// (по умолчанию повторяется любая ошибка)
+	Retryable func(err error) bool
+}
+
+// delay возвращает паузу перед попыткой attempt (начиная со второй)
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult <= 0 {
+		mult = 2
+	}
+	d := p.Backoff
+	for i := 2; i < attempt; i++ {
+		d = time.Duration(float64(d) * mult)
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+// ExecuteWithRetry выполняет fn через Execute, повторяя неудачные попытки согласно policy.
+// Результат каждой попытки отмечается в CB. Повторы прекращаются сразу, как только CB
+// перестает пропускать запросы: если CB разомкнулся после неудачной попытки, возвращается
+// ошибка, обернутая и в ErrCircuitOpen, и в ошибку последней попытки. При завершении ctx
+// во время паузы возвращается ctx.Err().
+func (m *CBManager) ExecuteWithRetry(ctx context.Context, serverURL string, fn func() error, policy RetryPolicy) error {
+	attempts := max(policy.MaxAttempts, 1)
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			timer := time.NewTimer(policy.delay(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		err = m.Execute(serverURL, fn)
+		if err == nil || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrBreakerNotFound) {
+			return err
+		}
+		if policy.Retryable != nil && !policy.Retryable(err) {
+			return err
+		}
+		// Повторять запрос к разомкнутому CB бессмысленно
+		if attempt < attempts && m.Peek(serverURL) == stateOpen {
+			return fmt.Errorf("%w: %s: %w", ErrCircuitOpen, serverURL, err)
+		}
+	}
+	return err
+}
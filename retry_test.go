@@ -0,0 +1,106 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExecuteWithRetry(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 10})
+
+	// Успех после двух неудач
+	calls := 0
+	err := m.ExecuteWithRetry(context.Background(), "test-server", func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("temporary")
+		}
+		return nil
+	}, RetryPolicy{MaxAttempts: 5, Backoff: time.Millisecond})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on 3rd attempt, got err=%v calls=%d", err, calls)
+	}
+
+	// Все попытки исчерпаны
+	boom := errors.New("boom")
+	calls = 0
+	err = m.ExecuteWithRetry(context.Background(), "test-server", func() error {
+		calls++
+		return boom
+	}, RetryPolicy{MaxAttempts: 3})
+	if !errors.Is(err, boom) || calls != 3 {
+		t.Errorf("Expected last error after 3 attempts, got err=%v calls=%d", err, calls)
+	}
+}
+
+func TestExecuteWithRetry_StopsWhenOpen(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 2,
+		RecoveryTimeout:  time.Hour,
+	})
+
+	boom := errors.New("boom")
+	calls := 0
+	err := m.ExecuteWithRetry(context.Background(), "test-server", func() error {
+		calls++
+		return boom
+	}, RetryPolicy{MaxAttempts: 10, Backoff: time.Millisecond})
+
+	// CB разомкнулся после второй неудачи, дальнейшие попытки не выполняются
+	if calls != 2 {
+		t.Errorf("Expected retries to stop after CB opened, got %d calls", calls)
+	}
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, boom) {
+		t.Errorf("Expected error wrapping ErrCircuitOpen and last error, got %v", err)
+	}
+
+	// При разомкнутом CB fn не вызывается вовсе
+	calls = 0
+	err = m.ExecuteWithRetry(context.Background(), "test-server", func() error {
+		calls++
+		return nil
+	}, RetryPolicy{MaxAttempts: 3})
+	if !errors.Is(err, ErrCircuitOpen) || calls != 0 {
+		t.Errorf("Expected ErrCircuitOpen without calls, got err=%v calls=%d", err, calls)
+	}
+}
+
+func TestExecuteWithRetry_ContextAndRetryable(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{FailureThreshold: 100})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := m.ExecuteWithRetry(ctx, "test-server", func() error {
+		calls++
+		cancel()
+		return errors.New("fail")
+	}, RetryPolicy{MaxAttempts: 5, Backoff: time.Hour})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("Expected context.Canceled after 1 call, got err=%v calls=%d", err, calls)
+	}
+
+	fatal := errors.New("fatal")
+	calls = 0
+	err = m.ExecuteWithRetry(context.Background(), "test-server", func() error {
+		calls++
+		return fatal
+	}, RetryPolicy{MaxAttempts: 5, Retryable: func(err error) bool { return !errors.Is(err, fatal) }})
+	if !errors.Is(err, fatal) || calls != 1 {
+		t.Errorf("Expected non-retryable error to stop retries, got err=%v calls=%d", err, calls)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 35 * time.Millisecond}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 35 * time.Millisecond, 35 * time.Millisecond}
+	for i, w := range want {
+		if d := p.delay(i + 2); d != w {
+			t.Errorf("delay(%d) = %v, want %v", i+2, d, w)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// streamEvent - JSON-представление события в потоке EventStreamHandler
+type streamEvent struct {
+	Name string    `json:"name"`
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to"`
+	Time time.Time `json:"time"`
+}
+
+// EventStreamHandler возвращает http.Handler, транслирующий переходы всех CB менеджера
+// в формате Server-Sent Events. При подключении клиент получает снимок текущих состояний
+// (события "snapshot", по одному на CB), затем - события "transition" при каждом переходе.
+// Данные события - JSON вида {"name", "from", "to", "time"}. Поток завершается при
+// отключении клиента. Если клиент не успевает читать, события для него отбрасываются
+// (см. DroppedEvents).
+func EventStreamHandler(m *CBManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		// Подписываемся до снимка, чтобы не пропустить переходы между ними
+		ch := m.events.subscribe(eventBufferSize)
+		defer m.events.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		for _, s := range m.stateSnapshot() {
+			if writeSSE(w, "snapshot", streamEvent{Name: s.name, To: s.state.String(), Time: s.at}) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-ch:
+				err := writeSSE(w, "transition", streamEvent{
+					Name: ev.Name,
+					From: ev.From.String(),
+					To:   ev.To.String(),
+					Time: ev.Time,
+				})
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+// namedState - состояние CB с его именем и моментом чтения по часам CB
+type namedState struct {
+	name  string
+	state State
+	at    time.Time
+}
+
+// stateSnapshot возвращает текущие состояния всех CB, упорядоченные по имени
+func (m *CBManager) stateSnapshot() []namedState {
+	breakers := m.breakerSnapshot()
+	snapshot := make([]namedState, 0, len(breakers))
+	for _, b := range breakers {
+		snapshot = append(snapshot, namedState{name: b.name, state: b.cb.curState(), at: b.cb.clock.Now()})
+	}
+	return snapshot
+}
+
+// writeSSE записывает одно событие в формате Server-Sent Events
+func writeSSE(w http.ResponseWriter, event string, data streamEvent) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
@@ -0,0 +1,115 @@
+package circuitbreaker
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventStreamHandler(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"a", "b"}, CircuitBreakerConf{FailureThreshold: 1, Clock: clock})
+	m.ReportFailure("b")
+
+	srv := httptest.NewServer(EventStreamHandler(m))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %q", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	next := func() (string, streamEvent) {
+		t.Helper()
+		var event string
+		var data streamEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data); err != nil {
+					t.Fatalf("bad data: %v", err)
+				}
+			case line == "":
+				return event, data
+			}
+		}
+	}
+
+	// Снимок текущих состояний при подключении; время берется из часов CB
+	for _, want := range []streamEvent{{Name: "a", To: "closed"}, {Name: "b", To: "open"}} {
+		event, data := next()
+		if event != "snapshot" || data.Name != want.Name || data.To != want.To {
+			t.Fatalf("Expected snapshot %s=%s, got %s %+v", want.Name, want.To, event, data)
+		}
+		if !data.Time.Equal(clock.Now()) {
+			t.Errorf("Expected snapshot time from CB clock %v, got %v", clock.Now(), data.Time)
+		}
+	}
+
+	// Переход после подключения
+	m.ReportFailure("a")
+	event, data := next()
+	if event != "transition" || data.Name != "a" || data.From != "closed" || data.To != "open" {
+		t.Errorf("Expected transition a closed->open, got %s %+v", event, data)
+	}
+}
+
+func TestEventStreamHandler_Middleware(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"a", "events"}, CircuitBreakerConf{FailureThreshold: 1})
+
+	// Поток за Middleware по-прежнему поддерживает Flush
+	handler := Middleware(m, func(*http.Request) string { return "events" })(EventStreamHandler(m))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "event: snapshot\n" {
+		t.Errorf("Expected flushed snapshot event, got %q (%v)", line, err)
+	}
+}
+
+func TestEventStreamHandler_ClientDisconnect(t *testing.T) {
+	m := NewCBManager()
+	srv := httptest.NewServer(EventStreamHandler(m))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// После отключения клиента подписка удаляется
+	srv.Close()
+	m.events.mu.RLock()
+	subs := len(m.events.subs)
+	m.events.mu.RUnlock()
+	if subs != 0 {
+		t.Errorf("Expected subscription to be removed after disconnect, got %d", subs)
+	}
+}
@@ -0,0 +1,226 @@
+package circuitbreaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StoredState - состояние CB во внешнем хранилище
+type StoredState struct {
+	State  State     // состояние CB
+	Since  time.Time // момент перехода в это состояние
+	Origin string    // идентификатор экземпляра, опубликовавшего состояние
+}
+
+// StateStore - внешнее хранилище состояний CB для согласования нескольких экземпляров
+// приложения (Redis, etcd и т.п.). Ключ - имя CB в менеджере. Реализация должна быть
+// безопасна для конкурентного использования.
+type StateStore interface {
+	// Get возвращает последнее опубликованное состояние ключа (false, если его нет)
+	Get(ctx context.Context, key string) (StoredState, bool, error)
+	// Set публикует состояние ключа
+	Set(ctx context.Context, key string, s StoredState) error
+	// Watch возвращает канал изменений ключа, который закрывается после отмены ctx
+	Watch(ctx context.Context, key string) (<-chan StoredState, error)
+}
+
+// SetStateStore подключает внешнее хранилище состояний: переходы CB менеджера публикуются
+// в store от имени экземпляра instanceID, а переходы, опубликованные другими экземплярами,
+// применяются к одноименным локальным CB (так размыкание на одном экземпляре размыкает
+// CB на остальных). Распространяются только размыкание и замыкание: half-open каждый
+// экземпляр проходит сам, а удаленное замыкание применяется лишь к разомкнутому или half-open
+// CB. Примененный удаленный переход обратно не публикуется; CB, разомкнутый через AlwaysOpen,
+// удаленным переходом не замыкается.
+//
+// Публикация выполняется асинхронно и не задерживает запросы: при переполнении буфера
+// переходы отбрасываются, ошибки store игнорируются (повторы и журналирование - забота
+// реализации). Синхронизация работает, пока не отменен ctx. Хранилище подключается один раз.
+func (m *CBManager) SetStateStore(ctx context.Context, store StateStore, instanceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sync != nil {
+		return fmt.Errorf("%w: state store already set", ErrInvalidConfig)
+	}
+	s := &stateSync{
+		ctx:     ctx,
+		store:   store,
+		origin:  instanceID,
+		out:     make(chan Event, eventBufferSize),
+		watched: make(map[*CircuitBreaker]context.CancelFunc),
+	}
+	m.sync = s
+	go s.run()
+
+	for _, cb := range m.breakers {
+		if _, ok := s.watched[cb]; ok {
+			continue
+		}
+		cb.mu.Lock()
+		cb.publish = s.publish
+		cb.mu.Unlock()
+		s.watch(cb)
+	}
+	return nil
+}
+
+// stateSync связывает CB менеджера с внешним хранилищем состояний
+type stateSync struct {
+	ctx     context.Context
+	store   StateStore
+	origin  string
+	out     chan Event                             // локальные переходы, ожидающие публикации
+	watched map[*CircuitBreaker]context.CancelFunc // отслеживаемые CB (под m.mu)
+}
+
+// publish ставит локальный переход в очередь публикации. Вызывается под cb.mu.
+func (s *stateSync) publish(ev Event) {
+	select {
+	case s.out <- ev:
+	default:
+	}
+}
+
+// run публикует локальные переходы в хранилище до отмены контекста
+func (s *stateSync) run() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case ev := <-s.out:
+			_ = s.store.Set(s.ctx, ev.Name, StoredState{State: ev.To, Since: ev.Time, Origin: s.origin})
+		}
+	}
+}
+
+// watch применяет к cb переходы, опубликованные другими экземплярами. Вызывается под m.mu.
+func (s *stateSync) watch(cb *CircuitBreaker) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.watched[cb] = cancel
+
+	go func() {
+		updates, err := s.store.Watch(ctx, cb.name)
+		if err != nil {
+			return
+		}
+		// Текущее состояние читается после подписки, чтобы не пропустить изменения между ними
+		if st, ok, err := s.store.Get(ctx, cb.name); err == nil && ok && st.Origin != s.origin {
+			cb.applyRemote(st.State)
+		}
+		for st := range updates {
+			if st.Origin != s.origin {
+				cb.applyRemote(st.State)
+			}
+		}
+	}()
+}
+
+// unwatch прекращает отслеживание cb. Вызывается под m.mu.
+func (s *stateSync) unwatch(cb *CircuitBreaker) {
+	if cancel, ok := s.watched[cb]; ok {
+		cancel()
+		delete(s.watched, cb)
+	}
+}
+
+// syncRegisterLocked подключает к хранилищу CB, зарегистрированный в менеджере, и отключает
+// CB replaced, если тот больше не зарегистрирован ни под одним именем. Вызывается под m.mu.
+func (m *CBManager) syncRegisterLocked(cb, replaced *CircuitBreaker) {
+	if m.sync == nil {
+		return
+	}
+	if replaced != nil && replaced != cb {
+		m.syncReleaseLocked(replaced)
+	}
+	if _, ok := m.sync.watched[cb]; !ok {
+		cb.publish = m.sync.publish
+		m.sync.watch(cb)
+	}
+}
+
+// syncReleaseLocked отключает от хранилища CB, удаленный из менеджера, если он
+// больше не зарегистрирован ни под одним именем. Вызывается под m.mu.
+func (m *CBManager) syncReleaseLocked(cb *CircuitBreaker) {
+	if m.sync == nil {
+		return
+	}
+	for _, other := range m.breakers {
+		if other == cb {
+			return
+		}
+	}
+	m.sync.unwatch(cb)
+}
+
+// MemoryStateStore - StateStore в памяти процесса. Подходит для тестов и для нескольких
+// менеджеров в одном процессе; между процессами состояние не разделяется.
+type MemoryStateStore struct {
+	mu      sync.Mutex
+	states  map[string]StoredState
+	watches map[string]map[chan StoredState]struct{}
+}
+
+// NewMemoryStateStore создает пустое хранилище состояний в памяти
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{
+		states:  make(map[string]StoredState),
+		watches: make(map[string]map[chan StoredState]struct{}),
+	}
+}
+
+// Get возвращает последнее сохраненное состояние ключа
+func (s *MemoryStateStore) Get(_ context.Context, key string) (StoredState, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[key]
+	return st, ok, nil
+}
+
+// Set сохраняет состояние ключа и рассылает его наблюдателям. Наблюдатель, не успевающий
+// читать изменения, теряет самые старые из них, но всегда получает последнее.
+func (s *MemoryStateStore) Set(_ context.Context, key string, st StoredState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[key] = st
+	for ch := range s.watches[key] {
+		for {
+			select {
+			case ch <- st:
+			default:
+				// Буфер заполнен: вытесняем самое старое изменение и повторяем
+				select {
+				case <-ch:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Watch подписывается на изменения ключа до отмены ctx
+func (s *MemoryStateStore) Watch(ctx context.Context, key string) (<-chan StoredState, error) {
+	ch := make(chan StoredState, eventBufferSize)
+
+	s.mu.Lock()
+	if s.watches[key] == nil {
+		s.watches[key] = make(map[chan StoredState]struct{})
+	}
+	s.watches[key][ch] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.watches[key], ch)
+		s.mu.Unlock()
+		close(ch)
+	}()
+	return ch, nil
+}
@@ -0,0 +1,115 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitState ожидает, пока CB сервера перейдет в состояние want
+func waitState(t *testing.T, m *CBManager, server string, want State) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for m.Peek(server) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: expected %s, got %s", server, want, m.Peek(server))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStateStore_SharedAcrossManagers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStateStore()
+	cfg := CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour}
+	a, b := NewCBManager(), NewCBManager()
+	a.InitCircuitBreakers([]string{"db"}, cfg)
+	b.InitCircuitBreakers([]string{"db"}, cfg)
+	if err := a.SetStateStore(ctx, store, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetStateStore(ctx, store, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Размыкание на экземпляре A размыкает CB на экземпляре B
+	a.ReportFailure("db")
+	waitState(t, b, "db", StateOpen)
+
+	// Примененный удаленный переход обратно не публикуется
+	if st, _, _ := store.Get(ctx, "db"); st.Origin != "a" || st.State != StateOpen {
+		t.Errorf("Expected open published by a, got %+v", st)
+	}
+
+	// Сброс на B замыкает CB на A
+	b.Reset("db")
+	waitState(t, a, "db", StateClosed)
+
+	if err := a.SetStateStore(ctx, store, "a"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig on second SetStateStore, got %v", err)
+	}
+}
+
+func TestStateStore_LateJoiner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStateStore()
+	cfg := CircuitBreakerConf{FailureThreshold: 1, RecoveryTimeout: time.Hour}
+	a := NewCBManager()
+	a.SetStateStore(ctx, store, "a")
+	a.AddCircuitBreaker("db", cfg)
+	a.ReportFailure("db")
+
+	// Экземпляр, подключившийся позже, получает текущее состояние из хранилища
+	b := NewCBManager()
+	b.AddCircuitBreaker("db", cfg)
+	b.AddCircuitBreaker("cache", cfg)
+	b.SetStateStore(ctx, store, "b")
+	waitState(t, b, "db", StateOpen)
+	if s := b.Peek("cache"); s != StateClosed {
+		t.Errorf("Expected unrelated breaker to stay closed, got %s", s)
+	}
+}
+
+func TestStateStore_ApplyRemote(t *testing.T) {
+	clock := newFakeClock()
+	var downtime time.Duration
+	cb, _ := new("db", CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Minute,
+		SuccessThreshold: 1,
+		HalfOpenPrc:      100,
+		Clock:            clock,
+		OnRecover: func(name string, d time.Duration) {
+			downtime = d
+		},
+	})
+
+	// Удаленный half-open не применяется: каждый экземпляр проверяет сервер сам
+	cb.applyRemote(stateHalfOpen)
+	if s := cb.curState(); s != stateClosed {
+		t.Fatalf("Expected remote half-open to be ignored, got %s", s)
+	}
+
+	// Удаленное размыкание начинает инцидент, и время простоя считается от него
+	cb.applyRemote(stateOpen)
+	clock.Advance(time.Minute)
+	if allowed, state := cb.allow(); !allowed || state != stateHalfOpen {
+		t.Fatalf("Expected probe after recovery timeout, got %v/%s", allowed, state)
+	}
+	cb.success()
+	if downtime != time.Minute {
+		t.Errorf("Expected downtime of 1m, got %v", downtime)
+	}
+
+	// Удаленное замыкание применяется только к разомкнутому CB
+	cb.failure()
+	cb.applyRemote(stateClosed)
+	if s := cb.curState(); s != stateClosed {
+		t.Errorf("Expected remote close to close open breaker, got %s", s)
+	}
+}
@@ -0,0 +1,30 @@
+package testutil_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/a3ak/circuitbreaker"
+	"github.com/a3ak/circuitbreaker/testutil"
+)
+
+func ExampleForceState() {
+	clock := testutil.NewClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	m := circuitbreaker.NewCBManager()
+	m.InitCircuitBreakers([]string{"api"}, circuitbreaker.CircuitBreakerConf{
+		RecoveryTimeout: time.Minute,
+		HalfOpenPrc:     100,
+		Clock:           clock,
+	})
+
+	// Размыкаем CB без генерации ошибок
+	testutil.ForceState(m.GetCircuitBreaker("api"), circuitbreaker.StateOpen)
+	fmt.Println(m.AllowRequest("api"))
+
+	// Таймаут восстановления истекает без ожидания
+	clock.Advance(time.Minute)
+	fmt.Println(m.AllowRequest("api"))
+	// Output:
+	// false open
+	// true half-open
+}
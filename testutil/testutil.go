@@ -0,0 +1,53 @@
+// Package testutil содержит помощники для детерминированного тестирования кода,
+// использующего circuitbreaker: принудительную смену состояния CB и управляемые часы.
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/a3ak/circuitbreaker"
+)
+
+// ForceState переводит CB в состояние s без ожидания порогов и таймаутов.
+// Паникует при недопустимом состоянии (например, StateNotConfigured).
+func ForceState(cb *circuitbreaker.CircuitBreaker, s circuitbreaker.State) {
+	if err := cb.ForceState(s); err != nil {
+		panic("testutil: " + err.Error())
+	}
+}
+
+// Clock - управляемый источник времени, реализующий circuitbreaker.Clock.
+// Время меняется только вызовами Advance и Set. Безопасен для конкурентного использования.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock создает часы, показывающие start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now возвращает текущее время часов
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance сдвигает часы вперед на d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set устанавливает часы на t
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+var _ circuitbreaker.Clock = (*Clock)(nil)
@@ -0,0 +1,46 @@
+package circuitbreaker
+
+import "time"
+
+// TripPolicy принимает решение о переходе closed -> open по снимку счетчиков CB.
+// Вызывается под блокировкой CB после каждой неудачи (и при UpdateConfig), поэтому
+// должен быть быстрым и не обращаться к CB или менеджеру.
+type TripPolicy interface {
+	ShouldTrip(s BreakerSnapshot) bool
+}
+
+// BreakerSnapshot - счетчики CB в closed, доступные TripPolicy
+type BreakerSnapshot struct {
+	Name           string
+	FailureCount   int       // ошибки с учетом декремента при успехах
+	FailureScore   float64   // взвешенный счет ошибок (см. ReportFailureWeighted)
+	TimeoutCount   int       // таймауты с учетом декремента при успехах
+	Threshold      float64   // порог ошибок с учетом RelaxFor и LoadSignal
+	WindowRequests int       // запросы в скользящем окне WindowSize
+	WindowFailures int       // ошибки в скользящем окне
+	Now            time.Time // текущее время по часам CB
+}
+
+// CountTripPolicy размыкает CB, когда взвешенный счет ошибок достигает порога
+// (стратегия count, используется по умолчанию)
+type CountTripPolicy struct{}
+
+// ShouldTrip реализует TripPolicy
+func (CountTripPolicy) ShouldTrip(s BreakerSnapshot) bool {
+	return s.FailureScore >= s.Threshold
+}
+
+// RatioTripPolicy размыкает CB, когда доля ошибок в окне достигает Ratio
+// при наличии в окне не менее MinRequests запросов (стратегия ratio)
+type RatioTripPolicy struct {
+	Ratio       float64
+	MinRequests int
+}
+
+// ShouldTrip реализует TripPolicy
+func (p RatioTripPolicy) ShouldTrip(s BreakerSnapshot) bool {
+	if s.WindowRequests == 0 || s.WindowRequests < p.MinRequests {
+		return false
+	}
+	return float64(s.WindowFailures)/float64(s.WindowRequests) >= p.Ratio
+}
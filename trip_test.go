@@ -0,0 +1,90 @@
+package circuitbreaker
+
+import "testing"
+
+// consecutiveTripPolicy размыкает CB после n ошибок подряд в скользящем окне
+type consecutiveTripPolicy struct {
+	n     int
+	calls *[]BreakerSnapshot
+}
+
+func (p consecutiveTripPolicy) ShouldTrip(s BreakerSnapshot) bool {
+	*p.calls = append(*p.calls, s)
+	return s.WindowFailures >= p.n && s.WindowFailures == s.WindowRequests
+}
+
+func TestTripPolicy_Custom(t *testing.T) {
+	var calls []BreakerSnapshot
+	clock := newFakeClock()
+	cb, _ := new("test", CircuitBreakerConf{
+		FailureThreshold: 1,
+		WindowSize:       3,
+		TripPolicy:       consecutiveTripPolicy{n: 3, calls: &calls},
+		Clock:            clock,
+	})
+
+	// FailureThreshold не действует: решение принимает политика
+	cb.failure()
+	cb.failure()
+	if cb.curState() != stateClosed {
+		t.Fatalf("Expected custom policy to keep CB closed, got %s", cb.curState())
+	}
+	cb.failure()
+	if cb.curState() != stateOpen {
+		t.Fatalf("Expected custom policy to trip CB, got %s", cb.curState())
+	}
+
+	if len(calls) != 3 {
+		t.Fatalf("Expected policy to be consulted on each failure, got %d calls", len(calls))
+	}
+	want := BreakerSnapshot{
+		Name:           "test",
+		FailureCount:   3,
+		FailureScore:   3,
+		Threshold:      1,
+		WindowRequests: 3,
+		WindowFailures: 3,
+		Now:            clock.Now(),
+	}
+	if calls[2] != want {
+		t.Errorf("Expected snapshot %+v, got %+v", want, calls[2])
+	}
+}
+
+func TestTripPolicy_BuiltIn(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy TripPolicy
+		s      BreakerSnapshot
+		want   bool
+	}{
+		{"count below", CountTripPolicy{}, BreakerSnapshot{FailureScore: 2, Threshold: 3}, false},
+		{"count reached", CountTripPolicy{}, BreakerSnapshot{FailureScore: 3, Threshold: 3}, true},
+		{"ratio too few requests", RatioTripPolicy{Ratio: 0.5, MinRequests: 4}, BreakerSnapshot{WindowRequests: 3, WindowFailures: 3}, false},
+		{"ratio below", RatioTripPolicy{Ratio: 0.5, MinRequests: 4}, BreakerSnapshot{WindowRequests: 4, WindowFailures: 1}, false},
+		{"ratio reached", RatioTripPolicy{Ratio: 0.5, MinRequests: 4}, BreakerSnapshot{WindowRequests: 4, WindowFailures: 2}, true},
+		{"ratio empty window", RatioTripPolicy{Ratio: 0.5}, BreakerSnapshot{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.ShouldTrip(tt.s); got != tt.want {
+				t.Errorf("ShouldTrip() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTripPolicy_TimeoutThreshold(t *testing.T) {
+	var calls []BreakerSnapshot
+	cb, _ := new("test", CircuitBreakerConf{
+		TimeoutThreshold: 1,
+		TripPolicy:       consecutiveTripPolicy{n: 100, calls: &calls},
+		Clock:            newFakeClock(),
+	})
+
+	// TimeoutThreshold действует независимо от пользовательской политики
+	cb.timeout()
+	if cb.curState() != stateOpen {
+		t.Errorf("Expected timeout threshold to trip CB, got %s", cb.curState())
+	}
+}
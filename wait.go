@@ -0,0 +1,45 @@
+package circuitbreaker
+
+import (
+	"context"
+	"time"
+)
+
+// waitPollInterval - период перепроверки состояния в WaitUntilAllowed, когда время
+// восстановления CB неизвестно
+const waitPollInterval = 100 * time.Millisecond
+
+// WaitUntilAllowed блокируется, пока CB сервера не начнет пропускать запросы: возвращает nil,
+// как только CB замкнут, находится в half-open или готов перейти в half-open (а также для
+// ненастроенного сервера и при DisableAll). Ожидание не расходует квоту half-open, поэтому
+// сам запрос по-прежнему нужно согласовать через AllowRequest или Execute.
+// Ожидание строится на событиях переходов и таймере до истечения таймаута восстановления;
+// если время восстановления неизвестно (RecoveryPolicy, исчерпанный MaxRecoveryAttempts),
+// состояние перепроверяется раз в waitPollInterval. При завершении ctx возвращается ctx.Err().
+func (m *CBManager) WaitUntilAllowed(ctx context.Context, serverURL string) error {
+	wake := m.events.subscribeWake()
+	defer m.events.unsubscribeWake(wake)
+
+	for {
+		if m.disabled.Load() || m.Peek(serverURL) != stateOpen {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Ждем перехода любого CB или истечения таймаута восстановления, а без подсказки
+		// о нем - очередной перепроверки
+		wait, ok := m.RetryAfter(serverURL)
+		if !ok {
+			wait = waitPollInterval
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
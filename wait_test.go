@@ -0,0 +1,106 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitUntilAllowed(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Minute,
+		Clock:            clock,
+	})
+
+	// Замкнутый CB не ожидает
+	if err := m.WaitUntilAllowed(context.Background(), "test-server"); err != nil {
+		t.Fatalf("Expected nil for closed CB, got %v", err)
+	}
+
+	// Разомкнутый CB ожидает, пока не истечет таймаут восстановления
+	m.ReportFailure("test-server")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.WaitUntilAllowed(ctx, "test-server"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected open CB to wait, got %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := m.WaitUntilAllowed(context.Background(), "test-server"); err != nil {
+		t.Fatalf("Expected nil after recovery timeout, got %v", err)
+	}
+}
+
+func TestWaitUntilAllowed_WakesOnTransition(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Hour,
+		Clock:            newFakeClock(),
+	})
+	m.ReportFailure("test-server")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.WaitUntilAllowed(context.Background(), "test-server")
+	}()
+
+	// Таймаут восстановления не истекает: ожидание прерывает только переход
+	m.TriggerProbe("test-server")
+	if err := <-done; err != nil {
+		t.Errorf("Expected nil after transition, got %v", err)
+	}
+}
+
+func TestWaitUntilAllowed_ContextCanceled(t *testing.T) {
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Hour,
+		Clock:            newFakeClock(),
+	})
+	m.ReportFailure("test-server")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	if err := m.WaitUntilAllowed(ctx, "test-server"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Canceled, got %v", err)
+	}
+	if state := m.GetCircuitBreakerState("test-server"); state != "open" {
+		t.Errorf("Expected waiting not to change state, got '%s'", state)
+	}
+}
+
+func TestWaitUntilAllowed_RecoveryPolicy(t *testing.T) {
+	var passed atomic.Int32
+	m := NewCBManager()
+	m.InitCircuitBreakers([]string{"test-server"}, CircuitBreakerConf{
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Hour,
+		RecoveryPolicy:   healthCheckPolicy{passed: &passed, checks: 1},
+		Clock:            newFakeClock(),
+	})
+	m.ReportFailure("test-server")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.WaitUntilAllowed(context.Background(), "test-server")
+	}()
+
+	// Политика разрешает пробу без перехода и без подсказки о времени: ожидание
+	// завершается очередной перепроверкой
+	passed.Add(1)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected nil once policy allows probing, got %v", err)
+		}
+	case <-time.After(10 * waitPollInterval):
+		t.Fatal("Expected WaitUntilAllowed to notice RecoveryPolicy decision")
+	}
+}